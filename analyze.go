@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// AnalysisFile is the file in the output directory that the analyses of
+// audio files are kept in, by path.
+const AnalysisFile = ".iowa-analysis.json"
+
+// harmonicCount is the number of harmonics whose amplitudes are analyzed.
+const harmonicCount = 10
+
+// Analysis is the analysis of an audio file.
+type Analysis struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"` // Of the file when it was analyzed.
+	SampleRate int       `json:"sample_rate"`
+	Channels   int       `json:"channels"`
+	Duration   float64   `json:"duration"` // In seconds.
+
+	// F0 is the fundamental frequency in Hz, zero if the file's name
+	// doesn't say what note it is or it has no clear pitch. Cents is how
+	// far it is from that note.
+	F0    float64 `json:"f0,omitempty"`
+	Cents float64 `json:"cents,omitempty"`
+
+	// Harmonics are the amplitudes of the first harmonics relative to the
+	// fundamental, and Centroid the frequency they are centered on.
+	Harmonics []float64 `json:"harmonics,omitempty"`
+	Centroid  float64   `json:"centroid,omitempty"`
+
+	// Fingerprint is nil if the file couldn't be decoded, see the
+	// duplicates and identify commands.
+	Fingerprint Fingerprint `json:"fingerprint"`
+}
+
+// Analyses are the analyses of audio files, by path.
+type Analyses struct {
+	mu    sync.Mutex
+	name  string
+	files map[string]Analysis
+}
+
+// LoadAnalyses loads the analyses in a file, if it exists.
+func LoadAnalyses(name string) (*Analyses, error) {
+	as := &Analyses{name: name, files: map[string]Analysis{}}
+
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return as, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading analyses")
+	}
+	if err := json.Unmarshal(data, &as.files); err != nil {
+		return nil, errors.Wrap(err, "parsing "+name)
+	}
+	return as, nil
+}
+
+// Get returns the analysis of a file if it hasn't changed since.
+func (as *Analyses) Get(name string) (Analysis, bool) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return Analysis{}, false
+	}
+	as.mu.Lock()
+	a, ok := as.files[name]
+	as.mu.Unlock()
+
+	return a, ok && a.Size == info.Size() && a.ModTime.Equal(info.ModTime())
+}
+
+// Put adds an analysis.
+func (as *Analyses) Put(a Analysis) {
+	as.mu.Lock()
+	as.files[a.Path] = a
+	as.mu.Unlock()
+}
+
+// Save writes the analyses to their file.
+func (as *Analyses) Save() error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	data, err := json.MarshalIndent(as.files, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding analyses")
+	}
+	return errors.Wrap(ioutil.WriteFile(as.name, append(data, '\n'), 0644), "writing analyses")
+}
+
+// Analyze analyzes a decoded file. Its pitch is only detected if the note
+// it is, by its name, is known.
+func Analyze(a *Audio, f File, a4 float64) Analysis {
+	an := Analysis{Path: f.Path, SampleRate: a.SampleRate, Channels: a.Channels, Fingerprint: NewFingerprint(a)}
+	if a.SampleRate > 0 {
+		an.Duration = float64(a.Frames()) / float64(a.SampleRate)
+	}
+	if f.MIDI == 0 || f.HighMIDI > f.MIDI {
+		return an
+	}
+	want := Frequency(f.MIDI, a4)
+	hz, ok := DetectPitch(a, want)
+	if !ok {
+		return an
+	}
+	an.F0, an.Cents = hz, Cents(hz, want)
+	an.Harmonics, an.Centroid = harmonics(a, hz)
+	return an
+}
+
+// harmonics returns the amplitudes of the harmonics of f0 below the
+// Nyquist frequency, relative to the fundamental, over the steady state
+// part of a, and their centroid.
+func harmonics(a *Audio, f0 float64) ([]float64, float64) {
+	mono := *a
+	mono.Data = append([]float64(nil), a.Data...)
+	MixToMono(&mono)
+
+	// Skip the attack, and analyze up to a second.
+	x := mono.Data[len(mono.Data)/5:]
+	if len(x) > a.SampleRate {
+		x = x[:a.SampleRate]
+	}
+	if len(x) == 0 {
+		return nil, 0
+	}
+	var (
+		amps      []float64
+		sum, wsum float64
+	)
+	for k := 1; k <= harmonicCount && float64(k)*f0 < float64(a.SampleRate)/2; k++ {
+		amp := goertzel(x, float64(k)*f0, float64(a.SampleRate))
+		amps = append(amps, amp)
+		sum += amp
+		wsum += amp * float64(k) * f0
+	}
+	if len(amps) == 0 || amps[0] == 0 {
+		return nil, 0
+	}
+	for k := len(amps) - 1; k >= 0; k-- {
+		amps[k] /= amps[0]
+	}
+	return amps, wsum / sum
+}
+
+// goertzel returns the amplitude of the frequency f in x, Hann windowed.
+func goertzel(x []float64, f, rate float64) float64 {
+	var (
+		n      = float64(len(x))
+		coeff  = 2 * math.Cos(2*math.Pi*f/rate)
+		s1, s2 float64
+	)
+	for i, v := range x {
+		w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/n)
+		s := v*w + coeff*s1 - s2
+		s2, s1 = s1, s
+	}
+	power := s1*s1 + s2*s2 - coeff*s1*s2
+	return 2 * math.Sqrt(math.Max(power, 0)) / (n / 2)
+}
+
+// indexAnalyses adds the analyses to the search index, if there is one.
+func (app *App) indexAnalyses() error {
+	idx, err := LoadSearchIndex(SearchIndexFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i, doc := range idx.Docs {
+		if an, ok := app.analyses.Get(doc.Path); ok {
+			idx.Docs[i].Analysis = &an
+		}
+	}
+	return idx.Save(SearchIndexFile)
+}
+
+// analyzeFiles analyzes every audio file under root, as many at once as
+// -jobs allows, and returns their analyses sorted by path. Analyses are kept
+// in AnalysisFile, so files are only analyzed again if they change, and in
+// the search index.
+func (app *App) analyzeFiles(ctx context.Context, root string) ([]Analysis, error) {
+	insts, err := app.localInstruments(root)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		files []File
+		g     errgroup.Group
+	)
+	for _, inst := range insts {
+		files = append(files, inst.Files...)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	results := make([]Analysis, len(files))
+	for i, f := range files {
+		if an, ok := app.analyses.Get(f.Path); ok && an.Fingerprint != nil {
+			results[i] = an
+			continue
+		}
+		i, f := i, f
+		g.Go(func() error {
+			if err := app.limiter.Acquire(ctx); err != nil {
+				return err
+			}
+			defer app.limiter.Release()
+
+			info, err := os.Stat(f.Path)
+			if err != nil {
+				return err
+			}
+			a, err := app.engine.Decode(f.Path)
+			if err != nil {
+				app.term.Status(StatusWarn, "%s: %s", f.Path, err)
+				results[i] = Analysis{Path: f.Path, Size: info.Size(), ModTime: info.ModTime()}
+				return nil
+			}
+			an := Analyze(a, f, app.Pipeline.A4)
+			an.Size, an.ModTime = info.Size(), info.ModTime()
+			app.analyses.Put(an)
+			results[i] = an
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if err := app.analyses.Save(); err != nil {
+		return nil, err
+	}
+	return results, app.indexAnalyses()
+}
+
+// analyze runs the analyze command: "iowa analyze [flags] [dir]" analyzes
+// the duration, fundamental frequency, harmonics and fingerprint of every
+// audio file under dir (the current directory by default) and lists them.
+func (app *App) analyze(ctx context.Context) error {
+	if len(app.Args) > 1 {
+		return withKind(FailureConfig, errors.New("usage: iowa analyze [flags] [dir]"))
+	}
+	root := "."
+	if len(app.Args) == 1 {
+		root = app.Args[0]
+	}
+	results, err := app.analyzeFiles(ctx, root)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, len(results))
+	for i, an := range results {
+		f0, cents, centroid := "-", "-", "-"
+		if an.F0 > 0 {
+			f0 = fmt.Sprintf("%.2f", an.F0)
+			cents = fmt.Sprintf("%+.1f", an.Cents)
+			centroid = fmt.Sprintf("%.0f", an.Centroid)
+		}
+		rows[i] = []string{an.Path, fmt.Sprintf("%.3f", an.Duration), f0, cents, centroid, strconv.Itoa(len(an.Harmonics))}
+	}
+	return app.printList(os.Stdout, results, []string{"PATH", "DURATION", "F0", "CENTS", "CENTROID", "HARMONICS"}, rows)
+}
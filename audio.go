@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Audio is decoded PCM audio.
+type Audio struct {
+	SampleRate int
+	Channels   int
+	BitDepth   int // Bit depth of the source.
+
+	// Data holds interleaved samples scaled to [-1, 1].
+	Data []float64
+}
+
+// Frames returns the number of sample frames.
+func (a *Audio) Frames() int {
+	if a.Channels == 0 {
+		return 0
+	}
+	return len(a.Data) / a.Channels
+}
+
+// DecodeFile decodes an AIFF, AIFC or WAV file.
+func DecodeFile(name string) (*Audio, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }() // Best effort.
+
+	return Decode(bufio.NewReader(f))
+}
+
+// Decode decodes an AIFF, AIFC or WAV stream.
+func Decode(r io.Reader) (*Audio, error) {
+	var header [12]byte
+
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, errors.Wrap(err, "reading header")
+	}
+	switch {
+	case string(header[0:4]) == "FORM" && string(header[8:12]) == "AIFF":
+		return decodeAIFF(r, false)
+	case string(header[0:4]) == "FORM" && string(header[8:12]) == "AIFC":
+		return decodeAIFF(r, true)
+	case string(header[0:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		return decodeWAV(r)
+	}
+	return nil, errors.New("unsupported audio format")
+}
+
+// sampleFormat describes how samples are stored.
+type sampleFormat struct {
+	Size      int // Bytes per sample.
+	Float     bool
+	Unsigned  bool // Offset binary, e.g. 8-bit WAV.
+	BigEndian bool
+}
+
+// aifcFormats maps the AIFC compression types that are really
+// uncompressed to their sample formats. The size of integer formats
+// comes from the COMM chunk.
+var aifcFormats = map[string]sampleFormat{
+	"NONE": {BigEndian: true},
+	"twos": {BigEndian: true},
+	"sowt": {},
+	"in24": {Size: 3, BigEndian: true},
+	"in32": {Size: 4, BigEndian: true},
+	"42ni": {Size: 3},
+	"23ni": {Size: 4},
+	"fl32": {Size: 4, Float: true, BigEndian: true},
+	"FL32": {Size: 4, Float: true, BigEndian: true},
+	"fl64": {Size: 8, Float: true, BigEndian: true},
+	"FL64": {Size: 8, Float: true, BigEndian: true},
+	"raw ": {Size: 1, Unsigned: true},
+}
+
+// decodeAIFF decodes the chunks of an AIFF or AIFC file following the FORM header.
+func decodeAIFF(r io.Reader, aifc bool) (*Audio, error) {
+	var (
+		a      Audio
+		format sampleFormat
+		frames uint32
+		comm   bool
+	)
+	for {
+		id, size, err := readChunkHeader(r, binary.BigEndian)
+		if err == io.EOF {
+			return nil, errors.New("no SSND chunk")
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case "COMM":
+			var c struct {
+				Channels int16
+				Frames   uint32
+				Bits     int16
+				Rate     [10]byte
+			}
+			if err := binary.Read(r, binary.BigEndian, &c); err != nil {
+				return nil, errors.Wrap(err, "reading COMM chunk")
+			}
+			a.Channels, a.BitDepth, a.SampleRate = int(c.Channels), int(c.Bits), int(extendedToFloat(c.Rate))
+			frames, comm = c.Frames, true
+			format = sampleFormat{BigEndian: true}
+
+			rest := int64(size) - 18
+			if aifc && rest >= 4 {
+				var compression [4]byte
+				if _, err := io.ReadFull(r, compression[:]); err != nil {
+					return nil, errors.Wrap(err, "reading COMM chunk")
+				}
+				var ok bool
+				if format, ok = aifcFormats[string(compression[:])]; !ok {
+					return nil, errors.Errorf("unsupported aifc compression %q", compression)
+				}
+				rest -= 4
+			}
+			if format.Size == 0 {
+				format.Size = (a.BitDepth + 7) / 8
+			}
+			if err := skip(r, rest+int64(size&1)); err != nil {
+				return nil, err
+			}
+		case "SSND":
+			if !comm {
+				return nil, errors.New("SSND chunk before COMM chunk")
+			}
+			var offset [8]byte // Offset and block size.
+			if _, err := io.ReadFull(r, offset[:]); err != nil {
+				return nil, errors.Wrap(err, "reading SSND chunk")
+			}
+			if err := skip(r, int64(binary.BigEndian.Uint32(offset[:4]))); err != nil {
+				return nil, err
+			}
+			n := int64(frames) * int64(a.Channels)
+			if err := a.readSamples(r, n, format); err != nil {
+				return nil, errors.Wrap(err, "reading SSND chunk")
+			}
+			return &a, nil
+		default:
+			if err := skip(r, int64(size)+int64(size&1)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// WAV format tags.
+const (
+	wavPCM        = 1
+	wavFloat      = 3
+	wavExtensible = 0xfffe
+)
+
+// decodeWAV decodes the chunks of a WAV file following the RIFF header.
+func decodeWAV(r io.Reader) (*Audio, error) {
+	var (
+		a       Audio
+		format  sampleFormat
+		haveFmt bool
+	)
+	for {
+		id, size, err := readChunkHeader(r, binary.LittleEndian)
+		if err == io.EOF {
+			return nil, errors.New("no data chunk")
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, errors.New("short fmt chunk")
+			}
+			c := make([]byte, int64(size)+int64(size&1))
+			if _, err := io.ReadFull(r, c); err != nil {
+				return nil, errors.Wrap(err, "reading fmt chunk")
+			}
+			var (
+				tag        = binary.LittleEndian.Uint16(c[0:])
+				blockAlign = int(binary.LittleEndian.Uint16(c[12:]))
+			)
+			a.Channels = int(binary.LittleEndian.Uint16(c[2:]))
+			a.SampleRate = int(binary.LittleEndian.Uint32(c[4:]))
+			a.BitDepth = int(binary.LittleEndian.Uint16(c[14:]))
+
+			if tag == wavExtensible {
+				if size < 40 {
+					return nil, errors.New("short extensible fmt chunk")
+				}
+				if valid := int(binary.LittleEndian.Uint16(c[18:])); valid > 0 {
+					a.BitDepth = valid
+				}
+				tag = binary.LittleEndian.Uint16(c[24:]) // First bytes of the sub-format GUID.
+			}
+			if a.Channels <= 0 {
+				return nil, errors.New("invalid channel count")
+			}
+			format = sampleFormat{Size: blockAlign / a.Channels}
+			switch tag {
+			case wavPCM:
+				format.Unsigned = format.Size == 1
+			case wavFloat:
+				format.Float = true
+			default:
+				return nil, errors.Errorf("unsupported wav format %d", tag)
+			}
+			haveFmt = true
+		case "data":
+			if !haveFmt {
+				return nil, errors.New("data chunk before fmt chunk")
+			}
+			if format.Size == 0 {
+				return nil, errors.New("invalid block alignment")
+			}
+			n := int64(size) / int64(format.Size)
+			n -= n % int64(a.Channels)
+			if err := a.readSamples(r, n, format); err != nil {
+				return nil, errors.Wrap(err, "reading data chunk")
+			}
+			return &a, nil
+		default:
+			if err := skip(r, int64(size)+int64(size&1)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// readSamples reads n samples. Integer samples are scaled by the size of
+// their container, so that e.g. 20-bit samples in 24-bit words work.
+func (a *Audio) readSamples(r io.Reader, n int64, format sampleFormat) error {
+	if a.Channels <= 0 {
+		return errors.New("invalid channel count")
+	}
+	switch {
+	case format.Float:
+		if format.Size != 4 && format.Size != 8 {
+			return errors.Errorf("unsupported float size %d", 8*format.Size)
+		}
+	case format.Size < 1 || format.Size > 4:
+		return errors.Errorf("unsupported bit depth %d", a.BitDepth)
+	}
+	buf := make([]byte, int64(format.Size)*n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	var (
+		bits  = uint(8 * format.Size)
+		scale = float64(int64(1) << (bits - 1))
+	)
+	a.Data = make([]float64, n)
+	for i := range a.Data {
+		b := buf[i*format.Size : (i+1)*format.Size]
+
+		var u uint64
+		for j := range b {
+			if format.BigEndian {
+				u = u<<8 | uint64(b[j])
+			} else {
+				u = u<<8 | uint64(b[len(b)-1-j])
+			}
+		}
+		switch {
+		case format.Float && format.Size == 4:
+			a.Data[i] = float64(math.Float32frombits(uint32(u)))
+		case format.Float:
+			a.Data[i] = math.Float64frombits(u)
+		case format.Unsigned:
+			a.Data[i] = (float64(u) - scale) / scale
+		default:
+			a.Data[i] = float64(int64(u<<(64-bits))>>(64-bits)) / scale // Sign extend.
+		}
+	}
+	return nil
+}
+
+// EncodeWAV writes a as a PCM WAV file with the provided bit depth (16, 24 or 32).
+func EncodeWAV(w io.Writer, a *Audio, bits int) error {
+	if bits != 16 && bits != 24 && bits != 32 {
+		return errors.Errorf("unsupported bit depth %d", bits)
+	}
+	var (
+		bytesPerSample = bits / 8
+		dataSize       = len(a.Data) * bytesPerSample
+		bw             = bufio.NewWriter(w)
+	)
+	header := struct {
+		RIFF       [4]byte
+		Size       uint32
+		WAVE       [4]byte
+		Fmt        [4]byte
+		FmtSize    uint32
+		Format     uint16
+		Channels   uint16
+		SampleRate uint32
+		ByteRate   uint32
+		BlockAlign uint16
+		Bits       uint16
+		Data       [4]byte
+		DataSize   uint32
+	}{
+		RIFF:       [4]byte{'R', 'I', 'F', 'F'},
+		Size:       uint32(36 + dataSize),
+		WAVE:       [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:        [4]byte{'f', 'm', 't', ' '},
+		FmtSize:    16,
+		Format:     1,
+		Channels:   uint16(a.Channels),
+		SampleRate: uint32(a.SampleRate),
+		ByteRate:   uint32(a.SampleRate * a.Channels * bytesPerSample),
+		BlockAlign: uint16(a.Channels * bytesPerSample),
+		Bits:       uint16(bits),
+		Data:       [4]byte{'d', 'a', 't', 'a'},
+		DataSize:   uint32(dataSize),
+	}
+	if err := binary.Write(bw, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	var (
+		max = float64(int64(1)<<uint(bits-1)) - 1
+		buf = make([]byte, bytesPerSample)
+	)
+	for _, s := range a.Data {
+		v := int32(math.Round(math.Max(-1, math.Min(1, s)) * max))
+		for i := range buf {
+			buf[i] = byte(v >> uint(8*i))
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	if dataSize&1 == 1 {
+		if err := bw.WriteByte(0); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteWAVFile writes a to a WAV file.
+func WriteWAVFile(name string, a *Audio, bits int) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := EncodeWAV(f, a, bits); err != nil {
+		_ = f.Close() // Best effort.
+		return err
+	}
+	return f.Close()
+}
+
+// Resample returns a copy of a at a new sample rate using windowed sinc interpolation.
+func Resample(a *Audio, rate int) *Audio {
+	if rate == a.SampleRate || a.Frames() == 0 {
+		out := *a
+		out.Data = append([]float64(nil), a.Data...)
+		return &out
+	}
+	const taps = 16 // Zero crossings on each side of the kernel.
+
+	var (
+		ratio   = float64(rate) / float64(a.SampleRate)
+		cutoff  = math.Min(1, ratio) // Low-pass when downsampling.
+		inN     = a.Frames()
+		outN    = int(math.Ceil(float64(inN) * ratio))
+		out     = &Audio{SampleRate: rate, Channels: a.Channels, BitDepth: a.BitDepth, Data: make([]float64, outN*a.Channels)}
+		halfLen = float64(taps) / cutoff
+	)
+	for i := 0; i < outN; i++ {
+		var (
+			center = float64(i) / ratio
+			lo     = int(math.Ceil(center - halfLen))
+			hi     = int(math.Floor(center + halfLen))
+		)
+		if lo < 0 {
+			lo = 0
+		}
+		if hi >= inN {
+			hi = inN - 1
+		}
+		for c := 0; c < a.Channels; c++ {
+			var sum float64
+			for j := lo; j <= hi; j++ {
+				x := (float64(j) - center) * cutoff
+				sum += a.Data[j*a.Channels+c] * sinc(x) * blackman(x/float64(taps))
+			}
+			out.Data[i*a.Channels+c] = sum * cutoff
+		}
+	}
+	return out
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// blackman is a Blackman window over [-1, 1].
+func blackman(x float64) float64 {
+	if x < -1 || x > 1 {
+		return 0
+	}
+	return 0.42 + 0.5*math.Cos(math.Pi*x) + 0.08*math.Cos(2*math.Pi*x)
+}
+
+func readChunkHeader(r io.Reader, order binary.ByteOrder) (string, uint32, error) {
+	var header [8]byte
+
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return "", 0, errors.New("truncated chunk header")
+		}
+		return "", 0, err
+	}
+	return string(header[:4]), order.Uint32(header[4:]), nil
+}
+
+func skip(r io.Reader, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, n); err != nil {
+		return errors.Wrap(err, "skipping chunk")
+	}
+	return nil
+}
+
+// extendedToFloat converts an 80-bit IEEE 754 extended precision number
+// (used for AIFF sample rates) to a float64.
+func extendedToFloat(b [10]byte) float64 {
+	var (
+		sign     = 1.0
+		exponent = int(binary.BigEndian.Uint16(b[0:2]))
+		mantissa = binary.BigEndian.Uint64(b[2:10])
+	)
+	if exponent&0x8000 != 0 {
+		sign = -1
+		exponent &= 0x7fff
+	}
+	if exponent == 0 && mantissa == 0 {
+		return 0
+	}
+	return sign * float64(mantissa) * math.Pow(2, float64(exponent-16383-63))
+}
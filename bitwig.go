@@ -0,0 +1,174 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Bitwig exports a Bitwig Studio .multisample per articulation of an
+// instrument, e.g. MISCello2012.arco.multisample. It is a zip of the
+// samples and a multisample.xml that maps them, and can be dragged onto
+// Bitwig's Sampler device.
+type Bitwig struct {
+	engine   Engine
+	fill     Fill
+	velocity Velocity
+}
+
+// NewBitwig creates a Bitwig exporter.
+func NewBitwig(conf Config, engine Engine) Exporter {
+	return Bitwig{engine: engine, fill: conf.Fill, velocity: conf.Velocity}
+}
+
+type bitwigMultisample struct {
+	XMLName     xml.Name      `xml:"multisample"`
+	Name        string        `xml:"name,attr"`
+	Generator   string        `xml:"generator"`
+	Category    string        `xml:"category"`
+	Creator     string        `xml:"creator"`
+	Description string        `xml:"description"`
+	Layers      []bitwigLayer `xml:"layer"`
+}
+
+type bitwigLayer struct {
+	Name    string         `xml:"name,attr"`
+	Samples []bitwigSample `xml:"sample"`
+}
+
+type bitwigSample struct {
+	File  string `xml:"file,attr"`
+	Start int    `xml:"sample-start,attr"`
+	Stop  int    `xml:"sample-stop,attr"`
+	Key   struct {
+		Root  int     `xml:"root,attr"`
+		Track float64 `xml:"track,attr"`
+		Low   int     `xml:"low,attr"`
+		High  int     `xml:"high,attr"`
+	} `xml:"key"`
+	Velocity struct {
+		Low  int `xml:"low,attr"`
+		High int `xml:"high,attr"`
+	} `xml:"velocity"`
+	Loop struct {
+		Mode string `xml:"mode,attr"`
+	} `xml:"loop"`
+}
+
+// Export writes <instrument>.<articulation>.multisample for every articulation.
+func (bw Bitwig) Export(inst Instrument) error {
+	for _, art := range groupLayers(Layers(inst.Files)) {
+		var (
+			name   = strings.Join(nonEmpty(inst.Name(), art[0].Articulation), ".")
+			ranges = bw.velocity.Ranges(len(art))
+			ms     = bitwigMultisample{
+				Name:        strings.TrimSpace(inst.Page.Instrument + " " + art[0].Articulation),
+				Generator:   "iowa",
+				Category:    strings.Title(inst.Page.Section),
+				Creator:     "University of Iowa Electronic Music Studios",
+				Description: "Generated by iowa from " + inst.Page.URL,
+			}
+			files   []multisampleFile
+			entries = map[string]string{} // Of each file, by path.
+			used    = map[string]int{}
+		)
+		for i, l := range art {
+			layer := bitwigLayer{Name: l.Name()}
+			for j, keys := range l.KeyRanges(bw.fill) {
+				f := l.Files[j]
+				a, err := bw.engine.Decode(f.Path)
+				if err != nil {
+					return errors.Wrap(err, "decoding "+f.Path)
+				}
+				// Names are deduped, as files of different dynamics can
+				// have the same name, e.g. with -layout by-note.
+				entry, ok := entries[f.Path]
+				if !ok {
+					ext := path.Ext(f.Path)
+					entry = uniqueName(used, strings.TrimSuffix(path.Base(f.Path), ext)) + ext
+					entries[f.Path] = entry
+					files = append(files, multisampleFile{Entry: entry, Path: f.Path})
+				}
+				s := bitwigSample{File: entry, Stop: a.Frames()}
+				s.Key.Root, s.Key.Track, s.Key.Low, s.Key.High = f.MIDI, 1, keys[0], keys[1]
+				s.Velocity.Low, s.Velocity.High = ranges[i].Low, ranges[i].High
+				s.Loop.Mode = "off"
+				layer.Samples = append(layer.Samples, s)
+			}
+			ms.Layers = append(ms.Layers, layer)
+		}
+		if err := writeMultisample(name+".multisample", ms, files); err != nil {
+			return errors.Wrap(err, "writing "+name+".multisample")
+		}
+	}
+	return nil
+}
+
+// multisampleFile is a file of a multisample and the name of its entry in
+// the zip.
+type multisampleFile struct {
+	Entry string
+	Path  string
+}
+
+// writeMultisample writes a zip of multisample.xml and the files it maps.
+func writeMultisample(name string, ms bitwigMultisample, files []multisampleFile) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+
+	if err := writeMultisampleEntries(zw, ms, files); err != nil {
+		_ = f.Close() // Best effort.
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		_ = f.Close() // Best effort.
+		return err
+	}
+	return f.Close()
+}
+
+func writeMultisampleEntries(zw *zip.Writer, ms bitwigMultisample, files []multisampleFile) error {
+	w, err := zw.Create("multisample.xml")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(ms); err != nil {
+		return errors.Wrap(err, "encoding multisample.xml")
+	}
+	for _, file := range files {
+		// Samples are already compressed so they are stored as is.
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: file.Entry, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		if err := copyFile(w, file.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies the contents of a file to w.
+func copyFile(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }() // Best effort.
+
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// onsetThreshold is the level, relative to the peak, at which a sample is
+// considered to start when aligning samples to blend them.
+const onsetThreshold = 0.1
+
+// Blend returns a mix of a and b, aligned at their onsets, weighted
+// t towards b. The result is as long as the longer of the two.
+func Blend(a, b *Audio, t float64) *Audio {
+	if b.SampleRate != a.SampleRate {
+		b = Resample(b, a.SampleRate)
+	}
+	if a.Channels != b.Channels {
+		a, b = copyAudio(a), copyAudio(b)
+		MixToMono(a)
+		MixToMono(b)
+	}
+	var (
+		ch     = a.Channels
+		oa, ob = Onset(a, onsetThreshold), Onset(b, onsetThreshold)
+		lead   = oa
+	)
+	if ob > lead {
+		lead = ob
+	}
+	var (
+		shiftA = lead - oa // Frames of silence before a.
+		shiftB = lead - ob
+		frames = a.Frames() + shiftA
+	)
+	if n := b.Frames() + shiftB; n > frames {
+		frames = n
+	}
+	out := &Audio{SampleRate: a.SampleRate, Channels: ch, BitDepth: a.BitDepth, Data: make([]float64, frames*ch)}
+	if b.BitDepth > out.BitDepth {
+		out.BitDepth = b.BitDepth
+	}
+	for i := range a.Data {
+		out.Data[i+shiftA*ch] += (1 - t) * a.Data[i]
+	}
+	for i := range b.Data {
+		out.Data[i+shiftB*ch] += t * b.Data[i]
+	}
+	return out
+}
+
+// Onset returns the first frame whose level reaches threshold times the peak.
+func Onset(a *Audio, threshold float64) int {
+	level := threshold * Peak(a)
+	for i, s := range a.Data {
+		if math.Abs(s) >= level && level > 0 {
+			return i / a.Channels
+		}
+	}
+	return 0
+}
+
+func copyAudio(a *Audio) *Audio {
+	out := *a
+	out.Data = append([]float64(nil), a.Data...)
+	return &out
+}
+
+// BlendFiles renders p.Blend evenly spaced blends between the samples of
+// each pair of adjacent dynamics of a note, processed by the pipeline, and
+// returns the paths they were written to. A blend is named after the
+// softer file with its dynamic replaced, e.g. Cello.arco.mf-ff-33.sulA.B3.stereo.wav
+// is a third of the way from mf to ff.
+func (p Pipeline) BlendFiles(engine Engine, files []File) ([]string, error) {
+	var written []string
+
+	for _, art := range groupLayers(Layers(files)) {
+		for i := 0; i+1 < len(art); i++ {
+			louder := map[int]File{}
+			for _, f := range art[i+1].Files {
+				louder[f.MIDI] = f
+			}
+			for _, soft := range art[i].Files {
+				loud, ok := louder[soft.MIDI]
+				if !ok {
+					continue
+				}
+				out, err := p.blendPair(engine, soft, loud)
+				if err != nil {
+					return written, errors.Wrapf(err, "blending %s and %s", soft.Path, loud.Path)
+				}
+				written = append(written, out...)
+			}
+		}
+	}
+	return written, nil
+}
+
+func (p Pipeline) blendPair(engine Engine, soft, loud File) ([]string, error) {
+	a, err := engine.Decode(soft.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding")
+	}
+	b, err := engine.Decode(loud.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding")
+	}
+	for _, pair := range []struct {
+		a *Audio
+		f File
+	}{{a, soft}, {b, loud}} {
+		if g, ok := p.gains[pair.f.Path]; ok {
+			Gain(pair.a, dbToAmp(g.Gain))
+		}
+	}
+	var written []string
+
+	for k := 1; k <= p.Blend; k++ {
+		var (
+			t    = float64(k) / float64(p.Blend+1)
+			dyn  = soft.Dynamic + "-" + loud.Dynamic + "-" + strconv.Itoa(int(math.Round(100*t)))
+			out  = p.Output(blendName(soft.Path, soft.Dynamic, dyn))
+			mix  = Blend(a, b, t)
+			bits = p.OutputBits(mix)
+		)
+		if err := os.MkdirAll(filepath.Dir(out), os.ModePerm); err != nil {
+			return written, errors.Wrap(err, "making directory")
+		}
+		if err := WriteWAVFile(out, p.Apply(mix), bits); err != nil {
+			return written, errors.Wrap(err, "writing")
+		}
+		written = append(written, out)
+	}
+	return written, nil
+}
+
+// blendName replaces the dynamic field in the base name of a file, or the
+// directory named after the dynamic in the by-note layout.
+func blendName(name, dynamic, blend string) string {
+	var (
+		dir    = path.Dir(name)
+		fields = strings.Split(path.Base(name), ".")
+	)
+	for i, field := range fields {
+		if strings.ToLower(field) == dynamic {
+			fields[i] = blend
+			return path.Join(dir, strings.Join(fields, "."))
+		}
+	}
+	if path.Base(dir) == dynamic {
+		dir = path.Join(path.Dir(dir), blend)
+	}
+	return path.Join(dir, strings.Join(fields, "."))
+}
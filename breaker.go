@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCircuitOpen is returned for requests to a host whose circuit breaker has tripped.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Breaker is a per-host circuit breaker with a retry budget.
+//
+// After Threshold consecutive failures the breaker for a host opens and
+// requests to it fail fast until Cooldown has passed. Then a single
+// request is let through: if it succeeds the breaker closes, otherwise it
+// opens for another cool-down.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	Budget    int // Retries allowed per host for the whole run.
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+	retries   int
+}
+
+// NewBreaker creates a new circuit breaker.
+func NewBreaker(threshold int, cooldown time.Duration, budget int) *Breaker {
+	return &Breaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		Budget:    budget,
+		hosts:     map[string]*hostState{},
+	}
+}
+
+// Allow returns ErrCircuitOpen if requests to host should not be made.
+func (b *Breaker) Allow(host string) error {
+	if b.Threshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	hs := b.host(host)
+	if hs.failures < b.Threshold {
+		return nil
+	}
+	if hs.probing || time.Now().Before(hs.openUntil) {
+		return ErrCircuitOpen
+	}
+	hs.probing = true
+	return nil
+}
+
+// Success records a successful request to host.
+func (b *Breaker) Success(host string) {
+	b.mu.Lock()
+	hs := b.host(host)
+	hs.failures = 0
+	hs.probing = false
+	b.mu.Unlock()
+}
+
+// Failure records a failed request to host.
+func (b *Breaker) Failure(host string) {
+	b.mu.Lock()
+	hs := b.host(host)
+	hs.failures++
+	hs.probing = false
+	if b.Threshold > 0 && hs.failures >= b.Threshold {
+		hs.openUntil = time.Now().Add(b.Cooldown)
+	}
+	b.mu.Unlock()
+}
+
+// Retry returns true if the retry budget for host allows another retry,
+// and if so spends one retry from it.
+func (b *Breaker) Retry(host string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	hs := b.host(host)
+	if b.Budget >= 0 && hs.retries >= b.Budget {
+		return false
+	}
+	hs.retries++
+	return true
+}
+
+// host must be called with b.mu held.
+func (b *Breaker) host(host string) *hostState {
+	hs, ok := b.hosts[host]
+	if !ok {
+		hs = &hostState{}
+		b.hosts[host] = hs
+	}
+	return hs
+}
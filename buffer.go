@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultBufferSize is the default size of the buffers downloads are copied with.
+const DefaultBufferSize = 32 * 1024
+
+// BufferPool shares the buffers downloads are copied with between
+// transfers, so that hundreds of concurrent transfers don't each allocate
+// their own.
+type BufferPool struct {
+	pool sync.Pool
+}
+
+// NewBufferPool creates a pool of buffers of the given size in bytes.
+func NewBufferPool(size int) *BufferPool {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	bp := &BufferPool{}
+	bp.pool.New = func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return bp
+}
+
+// Copy copies from src to dst with a buffer from the pool.
+func (bp *BufferPool) Copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := bp.pool.Get().(*[]byte)
+	defer bp.pool.Put(buf)
+
+	// Hide any ReadFrom method of dst (e.g. *os.File's), which would
+	// allocate a buffer of its own instead of using ours.
+	return io.CopyBuffer(struct{ io.Writer }{dst}, src, *buf)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// attributionName is the name of the attribution file of a bundle.
+const attributionName = "ATTRIBUTION.txt"
+
+// bundleTime is the modification time of every file in a bundle's zip, so
+// that bundling the same files makes the same zip.
+var bundleTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// attribution is the text of the attribution file of a bundle.
+const attribution = `Samples from the University of Iowa Electronic Music Studios Musical
+Instrument Samples (MIS), http://theremin.music.uiowa.edu/MIS.html
+
+The samples are freely available from the University of Iowa and may be
+used without restriction. Please credit the University of Iowa Electronic
+Music Studios when you use them.
+`
+
+// bundle runs the bundle command: "iowa bundle [flags] [dir]" bundles the
+// audio files under dir (the current directory by default), see
+// bundleFiles, or those of the -collection, into -bundle-dir as a
+// distributable: a deterministic zip, its JSON manifest, an attribution
+// file and a SHA256SUMS of them, and with -sign signatures of the zip and
+// manifest. The manifest is dated by the
+// newest file, or SOURCE_DATE_EPOCH, so bundling the same files makes the
+// same bundle.
+func (app *App) bundle(ctx context.Context) error {
+	if len(app.Args) > 1 {
+		return withKind(FailureConfig, errors.New("usage: iowa bundle [flags] [dir]"))
+	}
+	root := "."
+	if len(app.Args) == 1 {
+		root = app.Args[0]
+	}
+	audio, err := app.bundleFiles(root)
+	if err != nil {
+		return err
+	}
+	p, err := loadPack(root, app.MD5, func(rel string) bool { return audio[rel] })
+	if err != nil {
+		return withKind(FailureConfig, err)
+	}
+	out, err := filepath.Abs(app.BundleDir)
+	if err != nil {
+		return errors.Wrap(err, "resolving bundle directory")
+	}
+	var (
+		m     = Manifest{Files: []ManifestFile{}}
+		pages = app.scrapes.LinkPages()
+		seen  = map[string]bool{}
+	)
+	for _, f := range p.Files {
+		abs, err := filepath.Abs(filepath.Join(root, filepath.FromSlash(f.Path)))
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(abs, out+string(filepath.Separator)) || !app.inScope(f.Path) {
+			continue
+		}
+		m.Files = append(m.Files, f)
+
+		info, err := os.Stat(abs)
+		if err != nil {
+			return err
+		}
+		if t := info.ModTime().UTC(); t.After(m.Created) {
+			m.Created = t.Truncate(time.Second) // The newest file, unless SOURCE_DATE_EPOCH says otherwise.
+		}
+		if page := pages[f.URL]; len(page) > 0 && !seen[page] {
+			seen[page] = true
+			m.Pages = append(m.Pages, pageOf(app.Config, page))
+		}
+	}
+	if len(m.Files) == 0 {
+		return withKind(FailureConfig, errors.New("nothing to bundle in "+root))
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); len(epoch) > 0 {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return withKind(FailureConfig, errors.New("invalid SOURCE_DATE_EPOCH: "+epoch))
+		}
+		m.Created = time.Unix(secs, 0).UTC()
+	}
+	sort.Slice(m.Pages, func(i, j int) bool { return m.Pages[i].URL < m.Pages[j].URL })
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding manifest")
+	}
+	manifest = append(manifest, '\n')
+
+	if err := os.MkdirAll(app.BundleDir, os.ModePerm); err != nil {
+		return errors.Wrap(err, "making bundle directory")
+	}
+	var (
+		base    = filepath.Join(app.BundleDir, app.BundleName)
+		archive = base + ".zip"
+		text    = attributionText(m)
+	)
+	if err := writeBundleZip(archive, app.BundleName, root, m, manifest, text); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(base+".json", manifest, 0644); err != nil {
+		return errors.Wrap(err, "writing manifest")
+	}
+	if err := ioutil.WriteFile(filepath.Join(app.BundleDir, attributionName), []byte(text), 0644); err != nil {
+		return errors.Wrap(err, "writing attribution")
+	}
+	if err := writeSums(filepath.Join(app.BundleDir, sumsName), []string{archive, base + ".json", filepath.Join(app.BundleDir, attributionName)}); err != nil {
+		return err
+	}
+	if app.Sign {
+		for _, name := range []string{archive, base + ".json"} {
+			sig, err := Sign(ctx, name, app.SignKey)
+			if err != nil {
+				return err
+			}
+			app.term.Status(StatusOK, "signed %s", sig)
+		}
+	}
+	app.term.Status(StatusOK, "%s: %d files", archive, len(m.Files))
+	return nil
+}
+
+// bundleFiles returns the audio files under root that are bundled, by path
+// relative to it: those of localInstruments, which leaves out the site and
+// processed directories, except those merged into -pack-dir. Exported
+// instruments, snapshots, signatures and the like aren't bundled.
+func (app *App) bundleFiles(root string) (map[string]bool, error) {
+	insts, err := app.localInstruments(root)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := filepath.Abs(app.PackDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving pack directory")
+	}
+	files := map[string]bool{}
+
+	for _, inst := range insts {
+		for _, f := range inst.Files {
+			name := filepath.FromSlash(f.Path)
+			if abs, err := filepath.Abs(name); err != nil || strings.HasPrefix(abs, merged+string(filepath.Separator)) {
+				continue
+			}
+			rel, err := filepath.Rel(root, name)
+			if err != nil {
+				return nil, err
+			}
+			files[filepath.ToSlash(rel)] = true
+		}
+	}
+	return files, nil
+}
+
+// pageOf returns the page of the catalog with a URL, or just its URL if
+// the catalog doesn't have it.
+func pageOf(conf Config, url string) Page {
+	for _, p := range conf.Pages() {
+		if p.URL == url {
+			return p
+		}
+	}
+	return Page{URL: url, Instrument: InstrumentName(url)}
+}
+
+// attributionText returns the attribution of the files of a manifest,
+// with the pages they came from.
+func attributionText(m Manifest) string {
+	var buf bytes.Buffer
+
+	buf.WriteString(attribution)
+	if len(m.Pages) > 0 {
+		buf.WriteString("\nSource pages:\n\n")
+		for _, p := range m.Pages {
+			fmt.Fprintf(&buf, "  %s\n", p.URL)
+		}
+	}
+	return buf.String()
+}
+
+// writeBundleZip writes the files of a manifest under root, with the
+// manifest and attribution, to a zip in a directory called name. Entries
+// are sorted and have the same time and permissions, so that the zip only
+// changes when the files do.
+func writeBundleZip(archive, name, root string, m Manifest, manifest []byte, text string) error {
+	f, err := os.Create(archive + partSuffix)
+	if err != nil {
+		return errors.Wrap(err, "creating zip")
+	}
+	defer func() { _ = os.Remove(archive + partSuffix) }() // Renamed on success.
+
+	zw := zip.NewWriter(f)
+	add := func(entry string, write func(w io.Writer) error) error {
+		hdr := &zip.FileHeader{Name: path.Join(name, entry), Method: zip.Deflate, Modified: bundleTime}
+		hdr.SetMode(0644)
+
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		return write(w)
+	}
+	entries := append([]ManifestFile(nil), m.Files...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	for _, mf := range entries {
+		mf := mf
+		if err := add(mf.Path, func(w io.Writer) error {
+			return copyFile(w, filepath.Join(root, filepath.FromSlash(mf.Path)))
+		}); err != nil {
+			_ = f.Close() // Best effort.
+			return errors.Wrap(err, "adding "+mf.Path)
+		}
+	}
+	for _, e := range []struct {
+		name string
+		data []byte
+	}{
+		{"manifest.json", manifest},
+		{attributionName, []byte(text)},
+	} {
+		e := e
+		if err := add(e.name, func(w io.Writer) error { _, err := w.Write(e.data); return err }); err != nil {
+			_ = f.Close() // Best effort.
+			return errors.Wrap(err, "adding "+e.name)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		_ = f.Close() // Best effort.
+		return errors.Wrap(err, "writing zip")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "writing zip")
+	}
+	return errors.Wrap(os.Rename(archive+partSuffix, archive), "writing zip")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ScrapeCacheFile is the file in the output directory that the audio
+// links of every instrument page scraped are cached in.
+const ScrapeCacheFile = ".iowa-scrape.json"
+
+// ScrapeEntry is the cached result of scraping an instrument page.
+type ScrapeEntry struct {
+	Scraped    time.Time `json:"scraped"`
+	Extensions []string  `json:"extensions"` // Extensions the links were matched with.
+	Links      []string  `json:"links"`
+}
+
+// ScrapeCache caches the audio links of instrument pages, by URL.
+type ScrapeCache struct {
+	mu      sync.Mutex
+	name    string
+	entries map[string]ScrapeEntry
+}
+
+// LoadScrapeCache loads the scrape cache in a file, if it exists.
+func LoadScrapeCache(name string) (*ScrapeCache, error) {
+	sc := &ScrapeCache{name: name, entries: map[string]ScrapeEntry{}}
+
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return sc, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading scrape cache")
+	}
+	if err := json.Unmarshal(data, &sc.entries); err != nil {
+		return nil, errors.Wrap(err, "parsing "+name)
+	}
+	return sc, nil
+}
+
+// Get returns the cached links of a page, if they were matched with the
+// same extensions.
+func (sc *ScrapeCache) Get(url string, exts []string) (ScrapeEntry, bool) {
+	sc.mu.Lock()
+	e, ok := sc.entries[url]
+	sc.mu.Unlock()
+
+	return e, ok && strings.Join(e.Extensions, ",") == strings.Join(exts, ",")
+}
+
+// Fresh returns the cached links of a page if they were matched with the
+// same extensions and scraped less than ttl ago.
+func (sc *ScrapeCache) Fresh(url string, exts []string, ttl time.Duration) ([]string, bool) {
+	e, ok := sc.Get(url, exts)
+	if !ok || time.Since(e.Scraped) >= ttl {
+		return nil, false
+	}
+	return e.Links, true
+}
+
+// Put caches the links of a page and saves the cache.
+func (sc *ScrapeCache) Put(url string, exts, links []string) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.entries[url] = ScrapeEntry{Scraped: time.Now().UTC(), Extensions: exts, Links: links}
+
+	data, err := json.MarshalIndent(sc.entries, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding scrape cache")
+	}
+	if err := ioutil.WriteFile(sc.name, append(data, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "writing scrape cache")
+	}
+	return nil
+}
+
+// LinkPages returns the page that links to each cached link, by link.
+func (sc *ScrapeCache) LinkPages() map[string]string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	pages := map[string]string{}
+	for url, e := range sc.entries {
+		for _, link := range e.Links {
+			pages[link] = url
+		}
+	}
+	return pages
+}
+
+// cachedLinks returns the cached links of a page, reporting how old they
+// are, for when the network can't be used.
+func (app *App) cachedLinks(url string) ([]string, error) {
+	e, ok := app.scrapes.Get(url, app.Extensions)
+	if !ok {
+		return nil, errors.New(url + " is not in the scrape cache")
+	}
+	app.term.Status(StatusWarn, "%s: using %d links cached as of %s", url, len(e.Links), e.Scraped.Local().Format(time.RFC3339))
+	return e.Links, nil
+}
+
+// isNetworkError returns true if err means the server couldn't be reached,
+// rather than that it responded with an error. An open circuit breaker
+// counts, since it opens when the server can't be reached.
+func isNetworkError(err error) bool {
+	cause := errors.Cause(err)
+	if cause == ErrCircuitOpen {
+		return true
+	}
+	_, ok := cause.(net.Error)
+	return ok
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxCandidates is how many of the files matching an ambiguous query are
+// shown.
+const maxCandidates = 5
+
+// cat runs the cat subcommand: "iowa cat query-or-url" writes the bytes of
+// a single audio file to stdout. A query such as "cello C2 ff" names an
+// instrument and words that the file's note, dynamic, articulation and
+// so on must match, see matchQuery.
+func (app *App) cat(ctx context.Context) error {
+	if len(app.Args) == 0 {
+		return withKind(FailureConfig, errors.New("usage: iowa cat query-or-url"))
+	}
+	url := strings.Join(app.Args, " ")
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		found, err := app.find(ctx, strings.Fields(url))
+		if err != nil {
+			return err
+		}
+		url = found
+	}
+	resp, err := app.get(ctx, url)
+	if err != nil {
+		return withKind(FailureDownload, errors.Wrap(err, "fetching "+url))
+	}
+	defer func() { _ = resp.Body.Close() }() // Best effort.
+
+	if _, err := app.buffers.Copy(os.Stdout, resp.Body); err != nil && err != io.ErrClosedPipe {
+		return errors.Wrap(err, "writing to stdout")
+	}
+	return nil
+}
+
+// find returns the URL of the only audio file matching a query among the
+// pages selected by -e and -s.
+func (app *App) find(ctx context.Context, query []string) (string, error) {
+	pages, err := app.pages()
+	if err != nil {
+		return "", err
+	}
+	pages, rest := queryPages(pages, query)
+	if len(pages) == 0 {
+		return "", withKind(FailureConfig, errors.New("the query doesn't name an instrument: "+strings.Join(query, " ")))
+	}
+	var matches []string
+
+	for _, page := range pages {
+		links, err := app.scrape(ctx, page.URL)
+		if err != nil {
+			return "", withKind(FailureScrape, errors.Wrap(err, "scraping "+page.URL))
+		}
+		for _, link := range links {
+			if matchQuery(ParseSample(link), rest) {
+				matches = append(matches, link)
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", withKind(FailureConfig, errors.New("no file matches "+strings.Join(query, " ")))
+	case 1:
+		return matches[0], nil
+	}
+	var names []string
+	for i, m := range matches {
+		if i == maxCandidates {
+			names = append(names, "...")
+			break
+		}
+		names = append(names, path.Base(m))
+	}
+	return "", withKind(FailureConfig, errors.Errorf("%d files match %s, narrow it down: %s",
+		len(matches), strings.Join(query, " "), strings.Join(names, ", ")))
+}
+
+// queryPages returns the pages of the instrument a query names, exactly if
+// possible, and the rest of the query.
+func queryPages(pages []Page, query []string) ([]Page, []string) {
+	for _, exact := range []bool{true, false} {
+		for i, word := range query {
+			word = strings.ToLower(word)
+
+			var found []Page
+			for _, page := range pages {
+				if page.Instrument == word || (!exact && strings.Contains(page.Instrument, word)) {
+					found = append(found, page)
+				}
+			}
+			if len(found) > 0 {
+				rest := append(append([]string(nil), query[:i]...), query[i+1:]...)
+				return found, rest
+			}
+		}
+	}
+	return nil, query
+}
+
+// matchQuery returns true if every word of a query matches the sample:
+// a note (within the range of the file), its dynamic, channels, or part
+// of its articulation or extras, such as arco or sulA. Words are matched
+// ignoring case.
+func matchQuery(s Sample, query []string) bool {
+	for _, word := range query {
+		if !matchWord(s, word) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchWord(s Sample, word string) bool {
+	if m := noteRE.FindStringSubmatch(word); m != nil && len(m[4]) == 0 {
+		midi := noteNumber(m[1], m[2], m[3])
+		return s.MIDI > 0 && midi >= s.MIDI && midi <= s.HighMIDI
+	}
+	word = strings.ToLower(word)
+	if word == s.Dynamic || word == s.Channels {
+		return true
+	}
+	for _, field := range append(strings.Split(s.Articulation, "."), s.Extra...) {
+		if strings.ToLower(field) == word {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// Page is an instrument page in the catalog.
+type Page struct {
+	Era        string `json:"era"`
+	Section    string `json:"section"`
+	Instrument string `json:"instrument"`
+	URL        string `json:"url"`
+}
+
+// String returns the page as era/section/instrument.
+func (p Page) String() string {
+	return p.Era + "/" + p.Section + "/" + p.Instrument
+}
+
+// Pages returns every page in the catalog sorted by era, section, and instrument.
+func (config Config) Pages() []Page {
+	var pages []Page
+
+	for era, sections := range config.Samples {
+		for section, urls := range sections {
+			for _, url := range urls {
+				pages = append(pages, Page{
+					Era:        era,
+					Section:    section,
+					Instrument: InstrumentName(url),
+					URL:        url,
+				})
+			}
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].String() != pages[j].String() {
+			return pages[i].String() < pages[j].String()
+		}
+		return pages[i].URL < pages[j].URL
+	})
+	return pages
+}
+
+// sectionAliases maps other names of sections to the name they go by
+// here, so that the sections of both eras can be selected by one name.
+// Names are compared ignoring case.
+var sectionAliases = map[string]string{
+	"woodwind":      "woodwinds", // As pre-2012 calls them.
+	"winds":         "woodwinds",
+	"string":        "strings",
+	"perc":          "percussion",
+	"piano":         "piano/other",
+	"other":         "piano/other",
+	"piano-other":   "piano/other",
+	"found":         "foundobjects",
+	"found-objects": "foundobjects",
+	"objects":       "foundobjects",
+}
+
+// canonicalSection returns the name a section goes by in sectionAliases.
+func canonicalSection(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := sectionAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// SectionMatches returns true if name, which may be an alias, selects the
+// section of the catalog called key.
+func SectionMatches(key, name string) bool {
+	return canonicalSection(key) == canonicalSection(name)
+}
+
+// hasSection returns true if name selects a section of an era, or of
+// any era if era is "all".
+func (config Config) hasSection(era, name string) bool {
+	for e, sections := range config.Samples {
+		if era != "all" && e != era {
+			continue
+		}
+		for key := range sections {
+			if SectionMatches(key, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// excluded returns true if the -exclude-section flags select a section.
+func (config Config) excluded(section string) bool {
+	for _, name := range config.ExcludeSections {
+		if SectionMatches(section, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// InstrumentName returns the instrument name for an instrument page URL,
+// e.g. http://theremin.music.uiowa.edu/MIS-Pitches-2012/MISBbClarinet2012.html
+// is "bbclarinet".
+func InstrumentName(url string) string {
+	name := strings.TrimSuffix(path.Base(url), ".html")
+	if len(name) > 3 && strings.EqualFold(name[:3], "MIS") {
+		name = name[3:]
+	}
+	return strings.ToLower(strings.TrimSuffix(name, "2012"))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// Channel configurations of audio files, see ChannelConfig.
+const (
+	ChannelsMono     = "mono"
+	ChannelsDualMono = "dual-mono" // Several channels of the same audio.
+	ChannelsStereo   = "stereo"    // Several channels of different audio.
+)
+
+// dualMonoLevel is how loud the difference between the channels of a file
+// can be, relative to the file, for it to be dual mono, i.e. -60 dB, about
+// the noise of 16 bit dither.
+const dualMonoLevel = 0.001
+
+// ChannelConfig returns whether a is mono, dual mono (every channel is the
+// same, give or take dualMonoLevel) or stereo.
+func ChannelConfig(a *Audio) string {
+	if a.Channels <= 1 {
+		return ChannelsMono
+	}
+	var signal, diff float64
+
+	for i := 0; i < a.Frames(); i++ {
+		frame := a.Data[i*a.Channels : (i+1)*a.Channels]
+		for _, s := range frame {
+			signal += s * s
+		}
+		for _, s := range frame[1:] {
+			diff += (s - frame[0]) * (s - frame[0])
+		}
+	}
+	if diff <= signal*dualMonoLevel*dualMonoLevel {
+		return ChannelsDualMono
+	}
+	return ChannelsStereo
+}
+
+// FoldDualMono keeps only the first channel of a if it is dual mono, and
+// returns true if it was.
+func FoldDualMono(a *Audio) bool {
+	if ChannelConfig(a) != ChannelsDualMono {
+		return false
+	}
+	a.Data, a.Channels = a.channel(0), 1
+	return true
+}
+
+// FileChannels is the channel configuration of a file.
+type FileChannels struct {
+	Path     string `json:"path"`
+	Channels int    `json:"channels"`
+	Config   string `json:"config"` // Empty if it couldn't be decoded.
+}
+
+// ChannelReport is the channel configurations of the files of an
+// instrument.
+type ChannelReport struct {
+	Page
+	Mono     int            `json:"mono"`
+	DualMono int            `json:"dual_mono"`
+	Stereo   int            `json:"stereo"`
+	Files    []FileChannels `json:"files"`
+}
+
+// channels runs the channels command: "iowa channels [flags] [dir]"
+// detects whether every audio file under dir (the current directory by
+// default) is mono, dual mono or stereo, and reports them by instrument.
+// Processing with -fold-dual-mono makes dual mono files mono.
+func (app *App) channels(ctx context.Context) error {
+	if len(app.Args) > 1 {
+		return withKind(FailureConfig, errors.New("usage: iowa channels [flags] [dir]"))
+	}
+	root := "."
+	if len(app.Args) == 1 {
+		root = app.Args[0]
+	}
+	insts, err := app.localInstruments(root)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(insts, func(i, j int) bool { return insts[i].Page.String() < insts[j].Page.String() })
+
+	var (
+		reports = make([]ChannelReport, len(insts))
+		rows    = make([][]string, len(insts))
+	)
+	for i, inst := range insts {
+		var (
+			r = ChannelReport{Page: inst.Page, Files: make([]FileChannels, len(inst.Files))}
+			g errgroup.Group
+		)
+		for j, f := range inst.Files {
+			j, f := j, f
+			g.Go(func() error {
+				if err := app.limiter.Acquire(ctx); err != nil {
+					return err
+				}
+				defer app.limiter.Release()
+
+				r.Files[j] = FileChannels{Path: f.Path}
+				a, err := app.engine.Decode(f.Path)
+				if err != nil {
+					app.term.Status(StatusWarn, "%s: %s", f.Path, err)
+					return nil
+				}
+				r.Files[j].Channels, r.Files[j].Config = a.Channels, ChannelConfig(a)
+				return nil
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		}
+		for _, fc := range r.Files {
+			switch fc.Config {
+			case ChannelsMono:
+				r.Mono++
+			case ChannelsDualMono:
+				r.DualMono++
+			case ChannelsStereo:
+				r.Stereo++
+			}
+		}
+		reports[i] = r
+		rows[i] = []string{r.Era, r.Section, r.Instrument, strconv.Itoa(len(r.Files)), strconv.Itoa(r.Mono), strconv.Itoa(r.DualMono), strconv.Itoa(r.Stereo)}
+	}
+	return app.printList(os.Stdout, reports, []string{"ERA", "SECTION", "INSTRUMENT", "FILES", "MONO", "DUAL-MONO", "STEREO"}, rows)
+}
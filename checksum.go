@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Checksums are the size and checksums of a file.
+type Checksums struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5,omitempty"` // With -md5.
+}
+
+// check returns an error if the checksums don't match a manifest entry.
+// Sizes and checksums that the entry doesn't have aren't checked.
+func (c Checksums) check(mf ManifestFile) error {
+	switch {
+	case mf.Size > 0 && c.Size != mf.Size:
+		return errors.Errorf("%d bytes, expected %d", c.Size, mf.Size)
+	case len(mf.SHA256) > 0 && c.SHA256 != mf.SHA256:
+		return errors.New("checksum mismatch")
+	case len(mf.MD5) > 0 && c.MD5 != mf.MD5:
+		return errors.New("md5 checksum mismatch")
+	}
+	return nil
+}
+
+// hasher computes the Checksums of what is written to it, so that files
+// are checksummed while they are downloaded rather than read again.
+type hasher struct {
+	size   int64
+	sha256 hash.Hash
+	md5    hash.Hash // nil without -md5.
+}
+
+func newHasher(withMD5 bool) *hasher {
+	h := &hasher{sha256: sha256.New()}
+	if withMD5 {
+		h.md5 = md5.New()
+	}
+	return h
+}
+
+func (h *hasher) Write(p []byte) (int, error) {
+	h.size += int64(len(p))
+	_, _ = h.sha256.Write(p) // Never fails.
+	if h.md5 != nil {
+		_, _ = h.md5.Write(p) // Never fails.
+	}
+	return len(p), nil
+}
+
+// Sum returns the checksums of everything written so far.
+func (h *hasher) Sum() *Checksums {
+	c := &Checksums{Size: h.size, SHA256: hex.EncodeToString(h.sha256.Sum(nil))}
+	if h.md5 != nil {
+		c.MD5 = hex.EncodeToString(h.md5.Sum(nil))
+	}
+	return c
+}
+
+// hashPart returns a hasher for a download to a partial file, which has
+// already hashed the offset bytes of the file that a resumed download
+// continues.
+func (app *App) hashPart(part string, offset int64) (*hasher, error) {
+	h := newHasher(app.MD5)
+	if offset == 0 {
+		return h, nil
+	}
+	f, err := os.Open(part)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening partial file")
+	}
+	defer func() { _ = f.Close() }() // Best effort.
+
+	if _, err := app.buffers.Copy(h, io.LimitReader(f, offset)); err != nil {
+		return nil, errors.Wrap(err, "reading partial file")
+	}
+	return h, nil
+}
+
+// hashFile returns the checksums of a file.
+func hashFile(name string, withMD5 bool) (*Checksums, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening "+name)
+	}
+	defer func() { _ = f.Close() }() // Best effort.
+
+	h := newHasher(withMD5)
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, errors.Wrap(err, "reading "+name)
+	}
+	return h.Sum(), nil
+}
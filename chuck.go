@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ChucK exports a ChucK script per instrument. Each articulation gets an
+// array of SndBufs indexed by dynamic and MIDI note, and a function that
+// plays the sample nearest to a note, retuned to it, e.g.
+//
+//	cello_arco_play(2, 60, 0.5); // ff, middle C
+//
+// Running the script with a "demo" argument plays every sample.
+type ChucK struct {
+	fill Fill
+}
+
+// NewChucK creates a ChucK exporter.
+func NewChucK(conf Config, engine Engine) Exporter {
+	return ChucK{fill: conf.Fill}
+}
+
+// Export writes <instrument>.ck.
+func (ck ChucK) Export(inst Instrument) error {
+	layers := Layers(inst.Files)
+	if len(layers) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "// %s (%s/%s)\n", inst.Page.Instrument, inst.Page.Era, inst.Page.Section)
+	fmt.Fprintf(&buf, "// Generated by iowa from %s\n\n", inst.Page.URL)
+	buf.WriteString("me.dir() => string dir;\n")
+
+	var demo []string
+
+	for _, art := range groupLayers(layers) {
+		var (
+			name    = strings.TrimPrefix(scVariable(inst.Page.Instrument, art[0].Articulation), "~")
+			ugen    = "SndBuf2"
+			dyns    []string
+			nearest []string
+		)
+		if art[0].Files[0].Channels == "mono" {
+			ugen = "SndBuf"
+		}
+		for _, layer := range art {
+			dyns = append(dyns, quoteString(layer.Dynamic))
+
+			var notes []int
+			for _, idx := range layer.KeyMap(ck.fill) {
+				notes = append(notes, layer.Files[idx].MIDI)
+			}
+			nearest = append(nearest, "["+csInts(notes)+"]")
+		}
+		fmt.Fprintf(&buf, "\n// %s\n", art[0].Articulation)
+		fmt.Fprintf(&buf, "[%s] @=> string %s_dynamics[];\n", strings.Join(dyns, ", "), name)
+		fmt.Fprintf(&buf, "%s %s[%d][128];\n", ugen, name, len(art))
+		fmt.Fprintf(&buf, "[%s] @=> int %s_nearest[][];\n", strings.Join(nearest, ",\n "), name)
+
+		for i, layer := range art {
+			for _, f := range layer.Files {
+				fmt.Fprintf(&buf, "dir + \"/\" + %s => %s[%d][%d].read;\n", quoteString(f.Path), name, i, f.MIDI)
+				demo = append(demo, fmt.Sprintf("    %s_play(%d, %d, 0.5);", name, i, f.MIDI))
+			}
+		}
+		fmt.Fprintf(&buf, `
+// Plays the %s sample nearest to note for a dynamic (an index into %s_dynamics).
+fun void %s_play(int dynamic, int note, float gain) {
+    %s_nearest[dynamic][note] => int base;
+    %s[dynamic][base] @=> %s buf;
+    Math.pow(2.0, (note - base) / 12.0) => buf.rate;
+    gain => buf.gain;
+    0 => buf.pos;
+    buf => dac;
+    buf.length() / buf.rate() => now;
+    buf =< dac;
+}
+`, art[0].Articulation, name, name, name, name, ugen)
+	}
+	buf.WriteString("\nif (me.args() > 0 && me.arg(0) == \"demo\") {\n")
+	buf.WriteString(strings.Join(demo, "\n"))
+	buf.WriteString("\n}\n")
+
+	if err := ioutil.WriteFile(inst.Name()+".ck", buf.Bytes(), 0644); err != nil {
+		return errors.Wrap(err, "writing chuck script")
+	}
+	return nil
+}
+
+// groupLayers groups consecutive layers by articulation.
+func groupLayers(layers []Layer) [][]Layer {
+	var out [][]Layer
+
+	for i, layer := range layers {
+		if i == 0 || layer.Articulation != layers[i-1].Articulation {
+			out = append(out, nil)
+		}
+		out[len(out)-1] = append(out[len(out)-1], layer)
+	}
+	return out
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+)
+
+// NewClient creates the HTTP client shared by every request. With -record
+// or -replay its traffic is recorded in or replayed from a cassette, and
+// with -cookies or -login it keeps cookies.
+func NewClient(conf Config) (*http.Client, error) {
+	resolve, err := ParseResolve(conf.Resolve)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing resolve")
+	}
+	tlsConfig, err := NewTLSConfig(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "configuring tls")
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if pinned, ok := resolve[addr]; ok {
+				addr = pinned
+			}
+			if network == "tcp" && conf.IPVersion != IPAuto {
+				network += conf.IPVersion // tcp4 or tcp6.
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   connsPerHost(conf.Jobs),
+		MaxConnsPerHost:       connsPerHost(conf.Jobs),
+		IdleConnTimeout:       90 * time.Second,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	// A custom dialer and TLS configuration turn HTTP/2 off unless it is
+	// configured explicitly.
+	if err := http2.ConfigureTransport(transport); err != nil {
+		return nil, errors.Wrap(err, "configuring http/2")
+	}
+	jar, err := NewCookieJar(conf)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(conf.Record) > 0 && len(conf.Replay) > 0:
+		return nil, errors.New("can't record and replay at the same time")
+	case len(conf.Record) > 0:
+		rec, err := NewRecorder(transport, conf.Record)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{Transport: rec, Jar: jar}, nil
+	case len(conf.Replay) > 0:
+		rep, err := NewReplayer(conf.Replay)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{Transport: rep, Jar: jar}, nil
+	}
+	return &http.Client{Transport: transport, Jar: jar}, nil
+}
+
+// connsPerHost returns how many connections to a host the shared
+// transport opens and keeps open for reuse: enough for every transfer that
+// can run at once, so that sections of many small files don't pay for a
+// new connection per file, but no more than maxAutoJobs unless -jobs asks
+// for more.
+func connsPerHost(jobs int) int {
+	if jobs > maxAutoJobs {
+		return jobs
+	}
+	return maxAutoJobs
+}
+
+// IP versions of -ip-version.
+const (
+	IPAuto = "auto" // Either, falling back from IPv6 to IPv4 quickly if both are available.
+	IPv4   = "4"
+	IPv6   = "6"
+)
+
+// NewTLSConfig creates the TLS configuration for the shared HTTP client.
+func NewTLSConfig(conf Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: conf.Insecure,
+	}
+	if len(conf.CACert) > 0 {
+		pem, err := ioutil.ReadFile(conf.CACert)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading ca certificates")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + conf.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(conf.Cert) > 0 || len(conf.Key) > 0 {
+		if len(conf.Cert) == 0 || len(conf.Key) == 0 {
+			return nil, errors.New("client certificates need both -cert and -key")
+		}
+		cert, err := tls.LoadX509KeyPair(conf.Cert, conf.Key)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// ParseResolve parses curl-style host:port:addr entries into a map from
+// host:port to the addr:port that should be dialed instead.
+func ParseResolve(entries []string) (map[string]string, error) {
+	m := map[string]string{}
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+			return nil, errors.New("expected host:port:addr, got " + entry)
+		}
+		host, port, addr := parts[0], parts[1], strings.Trim(parts[2], "[]")
+
+		if net.ParseIP(addr) == nil {
+			return nil, errors.New("invalid address in " + entry)
+		}
+		m[net.JoinHostPort(host, port)] = net.JoinHostPort(addr, port)
+	}
+	return m, nil
+}
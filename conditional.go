@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ValidatorsFile is the file in the output directory that records the
+// validators of every file downloaded, for -if-changed.
+const ValidatorsFile = ".iowa-validators.json"
+
+// Validator is what the server said identifies the version of a file.
+type Validator struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// validatorOf returns the validator of a response.
+func validatorOf(resp *http.Response) Validator {
+	return Validator{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+}
+
+// Header returns the headers of a request that the server answers with
+// 304 Not Modified if the file hasn't changed, or nil if there are none.
+func (v Validator) Header() http.Header {
+	if len(v.ETag) == 0 && len(v.LastModified) == 0 {
+		return nil
+	}
+	h := http.Header{}
+	if len(v.ETag) > 0 {
+		h.Set("If-None-Match", v.ETag)
+	}
+	if len(v.LastModified) > 0 {
+		h.Set("If-Modified-Since", v.LastModified)
+	}
+	return h
+}
+
+// Validators are the validators of downloaded files, by path.
+type Validators struct {
+	mu         sync.Mutex
+	name       string
+	validators map[string]Validator
+	changed    bool
+}
+
+// LoadValidators loads the validators recorded in a file, if it exists.
+func LoadValidators(name string) (*Validators, error) {
+	vs := &Validators{name: name, validators: map[string]Validator{}}
+
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return vs, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading validators")
+	}
+	if err := json.Unmarshal(data, &vs.validators); err != nil {
+		return nil, errors.Wrap(err, "parsing "+name)
+	}
+	return vs, nil
+}
+
+// Get returns the validator of a downloaded file.
+func (vs *Validators) Get(name string) Validator {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	return vs.validators[name]
+}
+
+// Put records the validator of a downloaded file. Files the server gave
+// no validator for are forgotten.
+func (vs *Validators) Put(name string, v Validator) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	if v == (Validator{}) {
+		if _, ok := vs.validators[name]; ok {
+			delete(vs.validators, name)
+			vs.changed = true
+		}
+		return
+	}
+	if vs.validators[name] != v {
+		vs.validators[name] = v
+		vs.changed = true
+	}
+}
+
+// Save writes the validators to their file if they have changed.
+func (vs *Validators) Save() error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	if !vs.changed {
+		return nil
+	}
+	data, err := json.MarshalIndent(vs.validators, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding validators")
+	}
+	if err := ioutil.WriteFile(vs.name, append(data, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "writing validators")
+	}
+	vs.changed = false
+	return nil
+}
+
+// condition returns the validator to download a file with if it exists
+// and -if-changed is set.
+func (app *App) condition(name string) Validator {
+	if !app.IfChanged {
+		return Validator{}
+	}
+	if _, err := os.Stat(name); err != nil {
+		return Validator{}
+	}
+	return app.validators.Get(name)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// convert runs the processing pipeline and exporters over an already
+// downloaded tree, without touching the network. Every directory of audio
+// files is treated as an instrument.
+func (app *App) convert(ctx context.Context) error {
+	if len(app.Args) != 1 {
+		return withKind(FailureConfig, errors.New("usage: iowa convert [flags] <dir>"))
+	}
+	insts, err := app.localInstruments(app.Args[0])
+	if err != nil {
+		return err
+	}
+	for _, inst := range insts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var files []File
+		for _, f := range inst.Files {
+			if app.inScope(f.Path) {
+				files = append(files, f)
+			}
+		}
+		if len(files) == 0 {
+			continue
+		}
+		inst.Files = files
+		app.process(ctx, inst.Files)
+
+		if err := app.export(inst); err != nil {
+			return errors.Wrap(err, "exporting "+inst.Page.Instrument)
+		}
+	}
+	if n := app.failures.Count(FailureProcess); n > 0 {
+		return withKind(FailureProcess, errors.Errorf("%d files could not be processed", n))
+	}
+	return nil
+}
+
+// localInstruments finds the audio files under root, skipping the
+// processed and site directories, and groups them into instruments by
+// directory, see localSample.
+func (app *App) localInstruments(root string) ([]Instrument, error) {
+	processed, err := filepath.Abs(app.Pipeline.Dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving processed directory")
+	}
+	site, err := filepath.Abs(app.SiteDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving site directory")
+	}
+	recorded, err := stateFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	dirs := map[string]*fileList{}
+
+	err = filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if abs, err := filepath.Abs(name); err == nil && (abs == processed || abs == site) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !IsAudioFile(name, app.Extensions) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		dir, sample := localSample(name, recorded[filepath.ToSlash(rel)].Sample)
+		if dirs[dir] == nil {
+			dirs[dir] = &fileList{}
+		}
+		dirs[dir].add(File{Path: filepath.ToSlash(name), Sample: sample})
+		return nil
+	})
+	if err != nil {
+		return nil, withKind(FailureConfig, errors.Wrap(err, "reading "+root))
+	}
+	var insts []Instrument
+
+	for dir, files := range dirs {
+		insts = append(insts, Instrument{Page: localPage(dir), Files: files.sorted()})
+	}
+	sort.Slice(insts, func(i, j int) bool { return insts[i].Page.URL < insts[j].Page.URL })
+	return insts, nil
+}
+
+// localSample returns the directory of the instrument of a downloaded file
+// and the file's metadata, as recorded when it was downloaded if it was,
+// or else parsed from its name. Files in the by-note layout, e.g.
+// Cello/ff/A3.arco.sulA.aif, belong to the directory above their dynamic,
+// which their instrument and dynamic are parsed from if they weren't
+// recorded.
+func localSample(name string, recorded *Sample) (string, Sample) {
+	var (
+		dir    = filepath.Dir(name)
+		base   = filepath.Base(name)
+		byNote = DynamicIndex(filepath.Base(dir)) >= 0 && noteRE.MatchString(strings.SplitN(base, ".", 2)[0])
+		sample Sample
+	)
+	switch {
+	case recorded != nil:
+		sample = *recorded
+	case byNote:
+		sample = ParseSample(filepath.Base(filepath.Dir(dir)) + "." + filepath.Base(dir) + "." + base)
+	default:
+		sample = ParseSample(name)
+	}
+	if byNote {
+		dir = filepath.Dir(dir)
+	}
+	return dir, sample
+}
+
+// localPage returns a page for a directory of downloaded files, guessing
+// its era and section from the layout of the site, e.g.
+// sound files/MIS Pitches - 2014/Strings/Cello.
+func localPage(dir string) Page {
+	var (
+		slashed = filepath.ToSlash(dir)
+		page    = Page{
+			Era:        "pre-2012",
+			Section:    strings.ToLower(filepath.Base(filepath.Dir(dir))),
+			Instrument: strings.ToLower(filepath.Base(dir)),
+			URL:        "file://" + slashed,
+		}
+	)
+	if strings.Contains(slashed, "MIS Pitches") {
+		page.Era = "post-2012"
+	}
+	return page
+}
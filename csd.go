@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// firstCsoundTable is the number of the first GEN01 table, leaving low
+// numbers free for user tables.
+const firstCsoundTable = 101
+
+// Csound exports a .csd per instrument with a deferred-size GEN01 table for
+// every sample and a "Note" instrument that plays the sample nearest to a
+// MIDI note, e.g.
+//
+//	i "Note" 0 2 60 1 0.5 ; MIDI note 60, layer 1, amplitude 0.5
+type Csound struct {
+	fill Fill
+}
+
+// NewCsound creates a Csound exporter.
+func NewCsound(conf Config, engine Engine) Exporter {
+	return Csound{fill: conf.Fill}
+}
+
+// Export writes <instrument>.csd.
+func (cs Csound) Export(inst Instrument) error {
+	layers := Layers(inst.Files)
+	if len(layers) == 0 {
+		return nil
+	}
+	var (
+		buf    bytes.Buffer
+		table  = firstCsoundTable
+		tables []int // Table number of each (layer, MIDI note).
+		bases  []int // Sampled note of each (layer, MIDI note).
+		chans  []int // Channels of each (layer, MIDI note).
+	)
+	fmt.Fprintf(&buf, "; %s (%s/%s)\n", inst.Page.Instrument, inst.Page.Era, inst.Page.Section)
+	fmt.Fprintf(&buf, "; Generated by iowa from %s\n", inst.Page.URL)
+	buf.WriteString("<CsoundSynthesizer>\n<CsOptions>\n-odac\n</CsOptions>\n<CsInstruments>\n")
+	buf.WriteString("sr = 44100\nksmps = 32\nnchnls = 2\n0dbfs = 1\n\n")
+
+	for i, layer := range layers {
+		fmt.Fprintf(&buf, "; Layer %d: %s\n", i, layer.Name())
+
+		first := table
+		for _, f := range layer.Files {
+			fmt.Fprintf(&buf, "gi_%d ftgen %d, 0, 0, 1, %s, 0, 0, 0 ; %s\n", table, table, quoteString(f.Path), f.Note)
+			table++
+		}
+		for _, idx := range layer.KeyMap(cs.fill) {
+			f := layer.Files[idx]
+			tables = append(tables, first+idx)
+			bases = append(bases, f.MIDI)
+			chans = append(chans, csChannels(f))
+		}
+		buf.WriteString("\n")
+	}
+	fmt.Fprintf(&buf, "giTables ftgen 0, 0, -%d, -2, %s\n", len(tables), csInts(tables))
+	fmt.Fprintf(&buf, "giBases ftgen 0, 0, -%d, -2, %s\n", len(bases), csInts(bases))
+	fmt.Fprintf(&buf, "giChannels ftgen 0, 0, -%d, -2, %s\n\n", len(chans), csInts(chans))
+	buf.WriteString(csInstruments)
+	buf.WriteString("</CsInstruments>\n<CsScore>\n; Play every sampled note of every layer.\n")
+
+	t := 0
+	for i, layer := range layers {
+		for _, f := range layer.Files {
+			fmt.Fprintf(&buf, "i \"Note\" %d 2 %d %d 0.5\n", t, f.MIDI, i)
+			t += 2
+		}
+	}
+	buf.WriteString("</CsScore>\n</CsoundSynthesizer>\n")
+
+	if err := ioutil.WriteFile(inst.Name()+".csd", buf.Bytes(), 0644); err != nil {
+		return errors.Wrap(err, "writing csound file")
+	}
+	return nil
+}
+
+// csInstruments looks up the nearest sample for p4 (MIDI note) and p5
+// (layer) and plays it retuned with loscil.
+const csInstruments = `; p4 = MIDI note, p5 = layer, p6 = amplitude
+instr Note
+  index = p5 * 128 + p4
+  itab table index, giTables
+  ibase table index, giBases
+  ichn table index, giChannels
+  iratio = semitone(p4 - ibase)
+  if ichn == 2 then
+    schedule "Stereo", 0, p3, itab, iratio, p6
+  else
+    schedule "Mono", 0, p3, itab, iratio, p6
+  endif
+endin
+
+instr Mono
+  aenv linsegr 1, p3, 1, 0.3, 0
+  asig loscil3 p6, p5, p4, 1, 0
+  outs asig * aenv, asig * aenv
+endin
+
+instr Stereo
+  aenv linsegr 1, p3, 1, 0.3, 0
+  aL, aR loscil3 p6, p5, p4, 1, 0
+  outs aL * aenv, aR * aenv
+endin
+
+`
+
+// csChannels returns the number of channels of a file according to its name.
+func csChannels(f File) int {
+	if f.Channels == "mono" {
+		return 1
+	}
+	return 2
+}
+
+func csInts(ints []int) string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = fmt.Sprint(n)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dashboard renders the state of a job as a full-screen terminal UI.
+type Dashboard struct {
+	progress *Progress
+	w        io.Writer
+	color    bool
+}
+
+// NewDashboard creates a dashboard that draws the state of p to w.
+func NewDashboard(p *Progress, w io.Writer, noColor bool) *Dashboard {
+	return &Dashboard{
+		progress: p,
+		w:        w,
+		color:    !noColor && os.Getenv("NO_COLOR") == "",
+	}
+}
+
+// Run redraws the dashboard every interval until ctx is done.
+// The final state of the job is left on the screen.
+func (d *Dashboard) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	_, _ = io.WriteString(d.w, "\x1b[?25l") // Hide the cursor.
+	defer func() { _, _ = io.WriteString(d.w, "\x1b[?25h") }()
+
+	for {
+		d.Draw()
+
+		select {
+		case <-ctx.Done():
+			d.Draw()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Draw redraws the whole screen.
+func (d *Dashboard) Draw() {
+	var (
+		buf           bytes.Buffer
+		snap          = d.progress.Snapshot()
+		width, height = terminalSize(d.w)
+	)
+	buf.WriteString("\x1b[H\x1b[2J") // Home and clear.
+
+	fmt.Fprintf(&buf, "iowa  %d/%d files  %s failed  %s  %s/s  elapsed %s  ETA %s\n\n",
+		snap.Finished, snap.Files, d.paint(StatusFail, strconv.Itoa(snap.Failed)),
+		FormatBytes(snap.Bytes), FormatBytes(snap.Rate),
+		snap.Elapsed.Round(time.Second), FormatETA(snap.ETA))
+
+	// Split whatever rows are left after the headers between the sections.
+	rows := height - 10
+	if rows < 3 {
+		rows = 3
+	}
+	nactive, nerrors := rows/2, rows/4
+	nqueue := rows - nactive - nerrors
+
+	fmt.Fprintf(&buf, "Active (%d)\n", len(snap.Active))
+	for i, t := range snap.Active {
+		if i == nactive {
+			fmt.Fprintf(&buf, "  ... %d more\n", len(snap.Active)-nactive)
+			break
+		}
+		fmt.Fprintf(&buf, "  %s\n", d.transferLine(t, width-2))
+	}
+	fmt.Fprintf(&buf, "\nQueue (%d)\n", len(snap.Queue))
+	for i, l := range snap.Queue {
+		if i == nqueue {
+			fmt.Fprintf(&buf, "  ... %d more\n", len(snap.Queue)-nqueue)
+			break
+		}
+		fmt.Fprintf(&buf, "  %s\n", truncate(l, width-2))
+	}
+	fmt.Fprintf(&buf, "\nRecent errors (%d)\n", snap.Failed)
+	errs := snap.Errors
+	if len(errs) > nerrors {
+		errs = errs[len(errs)-nerrors:]
+	}
+	for _, e := range errs {
+		fmt.Fprintf(&buf, "  %s\n", d.paint(StatusFail, truncate(e, width-2)))
+	}
+	_, _ = d.w.Write(buf.Bytes())
+}
+
+// transferLine renders a transfer with a progress bar that fits in width columns.
+func (d *Dashboard) transferLine(t TransferState, width int) string {
+	stats := fmt.Sprintf(" %9s/s ETA %-6s", FormatBytes(t.Rate), FormatETA(t.ETA))
+
+	name := width - len(stats) - 32
+	if name < 10 {
+		name = 10
+	}
+	var (
+		bar    = 20
+		filled = 0
+		pct    = "  ?%"
+	)
+	if t.Size > 0 {
+		filled = int(int64(bar) * t.Copied / t.Size)
+		pct = fmt.Sprintf("%3d%%", 100*t.Copied/t.Size)
+	}
+	if filled > bar {
+		filled = bar
+	}
+	return fmt.Sprintf("%-*s [%s%s] %s%s",
+		name, truncate(path.Base(t.Location), name),
+		d.paint(StatusOK, strings.Repeat("#", filled)), strings.Repeat(".", bar-filled),
+		pct, stats)
+}
+
+func (d *Dashboard) paint(s Status, text string) string {
+	if !d.color {
+		return text
+	}
+	return s.color() + text + colorReset
+}
+
+// terminalSize returns the size of the terminal w writes to, or if it
+// can't be queried, from $COLUMNS and $LINES, defaulting to 80x24.
+func terminalSize(w io.Writer) (width, height int) {
+	if f, ok := w.(*os.File); ok {
+		if width, height, ok = windowSize(f); ok {
+			return width, height
+		}
+	}
+	width, height = 80, 24
+
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		width = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil && n > 0 {
+		height = n
+	}
+	return width, height
+}
+
+func truncate(s string, n int) string {
+	if n <= 3 || len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
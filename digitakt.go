@@ -0,0 +1,64 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// digitaktNameLength is the longest sample name the Digitakt shows in full.
+const digitaktNameLength = 24
+
+// Digitakt exports each instrument as a folder of 48 kHz, 16-bit mono WAV
+// files for the Digitakt, e.g. digitakt/cello12/arco-ff-sula-a3.wav. The
+// digitakt folder can be dropped onto the +Drive in Elektron Transfer.
+type Digitakt struct {
+	engine Engine
+}
+
+// NewDigitakt creates a Digitakt exporter.
+func NewDigitakt(conf Config, engine Engine) Exporter {
+	return Digitakt{engine: engine}
+}
+
+// Export converts the instrument's files into digitakt/<instrument>.
+func (d Digitakt) Export(inst Instrument) error {
+	var (
+		dir   = filepath.Join("digitakt", truncateName(shortInstrumentName(inst.Page), digitaktNameLength))
+		names = map[string]int{}
+	)
+	return convertFiles(d.engine, dir, inst.Files, 16, func(f File) string {
+		return digitaktName(names, f)
+	}, func(a *Audio) *Audio {
+		MixToMono(a)
+		return Resample(a, 48000)
+	})
+}
+
+// digitaktName returns a unique short sample name that fits in
+// digitaktNameLength, keeping the note at the end so it stays visible.
+func digitaktName(used map[string]int, f File) string {
+	var (
+		name = shortSampleName(f)
+		tail string
+	)
+	if note := "-" + strings.ToLower(f.Note); len(f.Note) > 0 && strings.HasSuffix(name, note) {
+		name, tail = strings.TrimSuffix(name, note), note
+	}
+	// Names are unique once truncated, with room for the counter.
+	out := truncateName(name, digitaktNameLength-len(tail)) + tail
+	for n := 2; used[out] > 0; n++ {
+		counter := "-" + strconv.Itoa(n)
+		out = truncateName(name, digitaktNameLength-len(counter)-len(tail)) + counter + tail
+	}
+	used[out]++
+	return out
+}
+
+// truncateName shortens name to n bytes, dropping any trailing separator.
+func truncateName(name string, n int) string {
+	if len(name) <= n {
+		return name
+	}
+	return strings.TrimRight(name[:n], "-_. ")
+}
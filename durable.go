@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// syncDir flushes a directory to disk, so that files created or renamed in
+// it survive a crash or the drive being unplugged.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrap(err, "opening directory")
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close() // Best effort.
+		return errors.Wrap(err, "syncing directory")
+	}
+	return d.Close()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Engines for decoding downloaded files.
+const (
+	EngineAuto   = "auto"
+	EngineNative = "native"
+	EngineFFmpeg = "ffmpeg"
+)
+
+// Engine decodes audio files for the processing pipeline.
+type Engine interface {
+	Decode(name string) (*Audio, error)
+}
+
+// NewEngine creates the engine named by conf.Engine. The auto engine
+// decodes in Go and falls back to ffmpeg, if it is on the PATH, for files
+// Go can't decode.
+func NewEngine(conf Config) (Engine, error) {
+	switch conf.Engine {
+	case EngineNative:
+		return NativeEngine{}, nil
+	case EngineFFmpeg:
+		ff, err := NewFFmpeg()
+		if err != nil {
+			return nil, withKind(FailureConfig, err)
+		}
+		return ff, nil
+	case EngineAuto, "":
+		ff, err := NewFFmpeg()
+		if err != nil {
+			return NativeEngine{}, nil
+		}
+		return fallbackEngine{NativeEngine{}, ff}, nil
+	}
+	return nil, withKind(FailureConfig, errors.Errorf("unknown engine %q", conf.Engine))
+}
+
+// NativeEngine decodes files in Go.
+type NativeEngine struct{}
+
+// Decode decodes an AIFF, AIFC or WAV file.
+func (NativeEngine) Decode(name string) (*Audio, error) {
+	return DecodeFile(name)
+}
+
+// fallbackEngine tries each of its engines in order.
+type fallbackEngine []Engine
+
+func (engines fallbackEngine) Decode(name string) (*Audio, error) {
+	var err error
+	for _, engine := range engines {
+		var a *Audio
+		if a, err = engine.Decode(name); err == nil {
+			return a, nil
+		}
+	}
+	return nil, err
+}
+
+// FFmpeg decodes files with ffmpeg and ffprobe.
+type FFmpeg struct {
+	FFmpeg  string
+	FFprobe string
+}
+
+// NewFFmpeg finds ffmpeg and ffprobe on the PATH.
+func NewFFmpeg() (FFmpeg, error) {
+	var (
+		ff  FFmpeg
+		err error
+	)
+	if ff.FFmpeg, err = exec.LookPath("ffmpeg"); err != nil {
+		return ff, errors.Wrap(err, "finding ffmpeg")
+	}
+	if ff.FFprobe, err = exec.LookPath("ffprobe"); err != nil {
+		return ff, errors.Wrap(err, "finding ffprobe")
+	}
+	return ff, nil
+}
+
+// Decode probes the format of a file and has ffmpeg decode it to doubles.
+func (ff FFmpeg) Decode(name string) (*Audio, error) {
+	a, err := ff.probe(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "probing")
+	}
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(ff.FFmpeg, "-v", "error", "-i", name, "-map", "0:a:0", "-f", "f64le", "-acodec", "pcm_f64le", "-")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "ffmpeg: "+strings.TrimSpace(stderr.String()))
+	}
+	a.Data = make([]float64, len(out)/8)
+	for i := range a.Data {
+		a.Data[i] = math.Float64frombits(binary.LittleEndian.Uint64(out[8*i:]))
+	}
+	return a, nil
+}
+
+// probe returns the format of the first audio stream of a file.
+func (ff FFmpeg) probe(name string) (*Audio, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(ff.FFprobe, "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "stream=sample_rate,channels,bits_per_raw_sample,bits_per_sample",
+		"-of", "default=noprint_wrappers=1", name)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "ffprobe: "+strings.TrimSpace(stderr.String()))
+	}
+	a := &Audio{}
+	for _, line := range strings.Split(string(out), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil {
+			continue // N/A
+		}
+		switch kv[0] {
+		case "sample_rate":
+			a.SampleRate = n
+		case "channels":
+			a.Channels = n
+		case "bits_per_raw_sample", "bits_per_sample":
+			if a.BitDepth == 0 {
+				a.BitDepth = n
+			}
+		}
+	}
+	if a.SampleRate <= 0 || a.Channels <= 0 {
+		return nil, errors.New("no audio stream")
+	}
+	return a, nil
+}
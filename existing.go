@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// checkExisting scans the audio files under root, skipping the processed
+// directory, and returns the paths of the ones that are intact so they
+// aren't downloaded again. Broken files are reported and left to be
+// replaced if they are downloaded again.
+func (app *App) checkExisting(root string) (map[string]bool, error) {
+	var (
+		existing = map[string]bool{}
+		skipDir  = filepath.Clean(app.Pipeline.Dir)
+		broken   int
+	)
+	err := filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case info.IsDir() && name == skipDir:
+			return filepath.SkipDir
+		case info.IsDir() || !IsAudioFile(name, app.Extensions):
+			return nil
+		}
+		if err := ValidateAudioFile(name); err != nil {
+			app.term.Status(StatusFail, "%s: %s, it will be downloaded again", name, err)
+			broken++
+			return nil
+		}
+		existing[filepath.ToSlash(name)] = true
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "checking existing files")
+	}
+	app.term.Status(StatusOK, "checked %d existing files, %d broken", len(existing)+broken, broken)
+	return existing, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Exit codes.
+const (
+	ExitOK       = 0
+	ExitError    = 1 // Anything not covered below.
+	ExitConfig   = 2 // Bad flags or configuration.
+	ExitScrape   = 3 // An instrument page could not be scraped.
+	ExitDownload = 4 // One or more files could not be downloaded.
+	ExitVerify   = 5 // One or more files failed verification.
+)
+
+// Failure kinds.
+const (
+	FailureConfig   = "config"
+	FailureScrape   = "scrape"
+	FailureDownload = "download"
+	FailureVerify   = "verify"
+	FailureProcess  = "process"
+	FailureOther    = "other"
+)
+
+// exitCodes maps failure kinds to exit codes.
+var exitCodes = map[string]int{
+	FailureConfig:   ExitConfig,
+	FailureScrape:   ExitScrape,
+	FailureDownload: ExitDownload,
+	FailureVerify:   ExitVerify,
+}
+
+// Failure is a single failure recorded during a run.
+type Failure struct {
+	Kind  string `json:"kind"`
+	URL   string `json:"url,omitempty"`
+	Error string `json:"error"`
+}
+
+// Failures collects the failures of a run.
+type Failures struct {
+	mu   sync.Mutex
+	list []Failure
+}
+
+// Add records a failure.
+func (fs *Failures) Add(kind, url string, err error) {
+	fs.mu.Lock()
+	fs.list = append(fs.list, Failure{Kind: kind, URL: url, Error: err.Error()})
+	fs.mu.Unlock()
+}
+
+// Count returns the number of failures of the provided kind.
+func (fs *Failures) Count(kind string) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	n := 0
+	for _, f := range fs.list {
+		if f.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// List returns the failures.
+func (fs *Failures) List() []Failure {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]Failure(nil), fs.list...)
+}
+
+// WriteFile writes the failures to a JSON file.
+func (fs *Failures) WriteFile(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	f, err := os.Create(name)
+	if err != nil {
+		return errors.Wrap(err, "creating file")
+	}
+	list := fs.list
+	if list == nil {
+		list = []Failure{}
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(list); err != nil {
+		_ = f.Close() // Best effort.
+		return errors.Wrap(err, "encoding failures")
+	}
+	return f.Close()
+}
+
+// exitError is an error that determines the process's exit code.
+type exitError struct {
+	kind string
+	err  error
+}
+
+func (e exitError) Error() string { return e.err.Error() }
+func (e exitError) Cause() error  { return e.err }
+
+// withKind marks err as a failure of the provided kind.
+func withKind(kind string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return exitError{kind: kind, err: err}
+}
+
+// errorKind returns the failure kind of err, or "" if it doesn't have one.
+func errorKind(err error) string {
+	type causer interface {
+		Cause() error
+	}
+	for err != nil {
+		if e, ok := err.(exitError); ok {
+			return e.kind
+		}
+		c, ok := err.(causer)
+		if !ok {
+			break
+		}
+		err = c.Cause()
+	}
+	return ""
+}
+
+// ExitCode returns the exit code for err.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if code, ok := exitCodes[errorKind(err)]; ok {
+		return code
+	}
+	return ExitError
+}
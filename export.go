@@ -0,0 +1,139 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// File is a downloaded audio file.
+type File struct {
+	URL  string `json:"url"`
+	Path string `json:"path"` // Local path, relative to the output directory.
+	Sample
+
+	// Checksums are computed while the file is downloaded, nil if it
+	// wasn't.
+	Checksums *Checksums `json:"checksums,omitempty"`
+}
+
+// Instrument is the set of files downloaded from an instrument page.
+type Instrument struct {
+	Page  Page
+	Files []File
+}
+
+// Name returns a name for the instrument that is unique across the catalog,
+// derived from its page, e.g. MISCello2012.
+func (inst Instrument) Name() string {
+	return strings.TrimSuffix(path.Base(inst.Page.URL), ".html")
+}
+
+// Exporter writes files (e.g. sampler presets) for an instrument once its
+// samples have been downloaded.
+type Exporter interface {
+	Export(inst Instrument) error
+}
+
+// exporters maps the names accepted by -export to exporter constructors.
+var exporters = map[string]func(conf Config, engine Engine) Exporter{
+	"bitwig":   NewBitwig,
+	"ck":       NewChucK,
+	"csd":      NewCsound,
+	"digitakt": NewDigitakt,
+	"mid":      NewAudition,
+	"norns":    NewNorns,
+	"pd":       NewPureData,
+	"reaper":   NewReaper,
+	"scd":      NewSuperCollider,
+	"sfz":      NewSFZ,
+	"sox":      NewSoX,
+	"sp404":    NewSP404,
+	"tal":      NewTALSampler,
+}
+
+// NewExporters creates the exporters named in the configuration, which
+// decode files with engine. An audition file is exported along with any
+// format that maps samples to notes.
+func NewExporters(conf Config, engine Engine) ([]Exporter, error) {
+	var (
+		out      []Exporter
+		audition bool
+	)
+	for _, name := range conf.Export {
+		newExporter, ok := exporters[name]
+		if !ok {
+			return nil, errors.New("unsupported export format: " + name)
+		}
+		out = append(out, newExporter(conf, engine))
+		audition = audition || mappingFormats[name]
+	}
+	if audition && !contains(conf.Export, "mid") {
+		out = append(out, NewAudition(conf, engine))
+	}
+	return out, nil
+}
+
+func contains(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportFormats returns the names of the supported export formats.
+func ExportFormats() []string {
+	var names []string
+
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileList collects the files downloaded by concurrent writers.
+type fileList struct {
+	mu    sync.Mutex
+	files []File
+}
+
+func (fl *fileList) add(f File) {
+	fl.mu.Lock()
+	fl.files = append(fl.files, f)
+	fl.mu.Unlock()
+}
+
+// sorted returns the files sorted by articulation, dynamic, note, and path.
+func (fl *fileList) sorted() []File {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	files := append([]File(nil), fl.files...)
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.Articulation != b.Articulation {
+			return a.Articulation < b.Articulation
+		}
+		if a.Dynamic != b.Dynamic {
+			return DynamicIndex(a.Dynamic) < DynamicIndex(b.Dynamic)
+		}
+		if a.MIDI != b.MIDI {
+			return a.MIDI < b.MIDI
+		}
+		return a.Path < b.Path
+	})
+	return files
+}
+
+// quoteString returns a double quoted string literal for the exporters
+// whose languages escape backslashes and double quotes alike: ChucK, Csound
+// and SuperCollider.
+func quoteString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ExternalPipeline is a sequence of external commands run over every
+// downloaded file, e.g.
+//
+//	{
+//	  "jobs": 4,
+//	  "steps": [
+//	    {"command": ["sox", "{in}", "{out}", "silence", "1", "0.1", "1%"], "ext": ".wav"},
+//	    {"command": ["flac", "-s", "-o", "{out}", "{in}"], "ext": ".flac"}
+//	  ]
+//	}
+//
+// Each step reads the output of the previous one. The placeholders {in}
+// and {out} are the input and output files, and {name}, {note}, {midi},
+// {dynamic} and {articulation} come from the name of the downloaded file.
+type ExternalPipeline struct {
+	Jobs  int    `json:"jobs,omitempty"` // Files processed at once, 0 for the number of CPUs.
+	Steps []Step `json:"steps"`
+}
+
+// Step is a command of an external pipeline.
+type Step struct {
+	Command []string `json:"command"`
+	Ext     string   `json:"ext,omitempty"` // Extension of the output, defaults to that of the input.
+}
+
+// LoadExternalPipeline reads an external pipeline from a JSON file.
+func LoadExternalPipeline(name string) (ExternalPipeline, error) {
+	var ep ExternalPipeline
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return ep, errors.Wrap(err, "reading pipeline")
+	}
+	if err := json.Unmarshal(data, &ep); err != nil {
+		return ep, errors.Wrap(err, "parsing pipeline "+name)
+	}
+	for i, step := range ep.Steps {
+		if len(step.Command) == 0 {
+			return ep, errors.Errorf("pipeline step %d has no command", i+1)
+		}
+	}
+	return ep, nil
+}
+
+// Run runs the pipeline over files, writing the output of the last step
+// under dir mirroring the downloaded tree. A file that fails is reported
+// to fail and doesn't stop the others.
+func (ep ExternalPipeline) Run(ctx context.Context, files []File, dir string, done func(f File, out string, err error)) {
+	jobs := ep.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	var (
+		sem = make(chan struct{}, jobs)
+		wg  sync.WaitGroup
+	)
+	for _, f := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(f File) {
+			defer func() { <-sem; wg.Done() }()
+
+			out, err := ep.runFile(ctx, f, dir)
+			done(f, out, err)
+		}(f)
+	}
+	wg.Wait()
+}
+
+// runFile runs every step over a file, keeping intermediate files in a
+// temporary directory.
+func (ep ExternalPipeline) runFile(ctx context.Context, f File, dir string) (string, error) {
+	tmp, err := ioutil.TempDir("", "iowa")
+	if err != nil {
+		return "", errors.Wrap(err, "making temporary directory")
+	}
+	defer func() { _ = os.RemoveAll(tmp) }() // Best effort.
+
+	var (
+		in   = f.Path
+		base = strings.TrimSuffix(filepath.Base(f.Path), filepath.Ext(f.Path))
+	)
+	for i, step := range ep.Steps {
+		ext := step.Ext
+		if len(ext) == 0 {
+			ext = filepath.Ext(in)
+		}
+		out := filepath.Join(tmp, strconv.Itoa(i)+ext)
+		if i == len(ep.Steps)-1 {
+			out = filepath.Join(dir, strings.TrimSuffix(f.Path, filepath.Ext(f.Path))+ext)
+			if err := os.MkdirAll(filepath.Dir(out), os.ModePerm); err != nil {
+				return "", errors.Wrap(err, "making directory")
+			}
+		}
+		r := strings.NewReplacer(
+			"{in}", in,
+			"{out}", out,
+			"{name}", base,
+			"{note}", f.Note,
+			"{midi}", strconv.Itoa(f.MIDI),
+			"{dynamic}", f.Dynamic,
+			"{articulation}", f.Articulation,
+		)
+		args := make([]string, len(step.Command))
+		for j, arg := range step.Command {
+			args[j] = r.Replace(arg)
+		}
+		var stderr bytes.Buffer
+
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+				err = errors.Wrap(err, msg)
+			}
+			return "", errors.Wrapf(err, "step %d (%s)", i+1, args[0])
+		}
+		in = out
+	}
+	return in, nil
+}
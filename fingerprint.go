@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Parameters of fingerprints: audio is mixed to mono and resampled to
+// fingerprintRate, and every fingerprintHop samples the energies of 33
+// bands between fingerprintLow and fingerprintHigh Hz, spaced like the
+// notes of a scale, over fingerprintFrame samples are compared to the
+// previous ones'.
+const (
+	fingerprintRate  = 11025
+	fingerprintFrame = 1024
+	fingerprintHop   = 256
+	fingerprintLow   = 150
+	fingerprintHigh  = 3000
+	fingerprintBands = 33 // Each code has a bit for each pair of adjacent bands.
+)
+
+// fingerprintSilence is the level in dBFS below which the ends of files are
+// left out of their fingerprint, so that the same recording trimmed
+// differently matches.
+const fingerprintSilence = -60
+
+// maxCodeFiles is how many files the same code can be in for it to be used
+// to find candidate duplicates; codes that more files have, e.g. of
+// silence or noise, say little.
+const maxCodeFiles = 50
+
+// A Fingerprint of audio, a code of 32 bits for each frame, in the manner
+// of Haitsma and Kalker's, and Chromaprint's: each bit is whether the
+// difference in energy between two adjacent bands grew since the previous
+// frame. It doesn't change with gain, and little with encoding, sample rate
+// or noise, so recordings that sound the same have fingerprints that are
+// mostly the same bits.
+type Fingerprint []uint32
+
+// NewFingerprint returns the fingerprint of a. It is empty if a is silent.
+func NewFingerprint(a *Audio) Fingerprint {
+	mono := *a
+	mono.Data = append([]float64(nil), a.Data...)
+	MixToMono(&mono)
+	TrimSilence(&mono, dbToAmp(fingerprintSilence))
+	if len(mono.Data) == 0 {
+		return Fingerprint{}
+	}
+	x := Resample(&mono, fingerprintRate).Data
+	if len(x) < fingerprintFrame+fingerprintHop {
+		x = append(x, make([]float64, fingerprintFrame+fingerprintHop-len(x))...) // Short files have at least a code.
+	}
+	var (
+		fp     = Fingerprint{}
+		window = make([]float64, fingerprintFrame)
+		frame  = make([]complex128, fingerprintFrame)
+		edges  = make([]int, fingerprintBands+1) // FFT bins.
+		prev   []float64
+	)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/fingerprintFrame)
+	}
+	for k := range edges {
+		hz := fingerprintLow * math.Pow(fingerprintHigh/fingerprintLow, float64(k)/fingerprintBands)
+		edges[k] = int(math.Round(hz * fingerprintFrame / fingerprintRate))
+	}
+	for start := 0; start+fingerprintFrame <= len(x); start += fingerprintHop {
+		for i := range frame {
+			frame[i] = complex(x[start+i]*window[i], 0)
+		}
+		fft(frame)
+
+		energies := make([]float64, fingerprintBands)
+		for k := range energies {
+			hi := edges[k+1]
+			if hi <= edges[k] {
+				hi = edges[k] + 1 // At least a bin.
+			}
+			for bin := edges[k]; bin < hi; bin++ {
+				energies[k] += real(frame[bin])*real(frame[bin]) + imag(frame[bin])*imag(frame[bin])
+			}
+		}
+		if prev != nil {
+			var code uint32
+			for k := 0; k < 32; k++ {
+				if (energies[k]-energies[k+1])-(prev[k]-prev[k+1]) > 0 {
+					code |= 1 << uint(k)
+				}
+			}
+			fp = append(fp, code)
+		}
+		prev = energies
+	}
+	return fp
+}
+
+// fft transforms x in place, whose length is a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			wk := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := x[start+k], x[start+k+size/2]*wk
+				x[start+k], x[start+k+size/2] = even+odd, even-odd
+				wk *= w
+			}
+		}
+	}
+}
+
+// Similarity returns the fraction of the bits of two fingerprints that are
+// the same, where they overlap, aligned by the offset that the most of
+// their codes agree on, or at their starts if none do. Recordings that
+// sound the same are about 0.9 or more, unrelated ones about 0.5.
+func Similarity(a, b Fingerprint) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	var (
+		positions = map[uint32][]int{}
+		votes     = map[int]int{}
+		offset    = 0 // Of b in a.
+	)
+	for i, code := range a {
+		positions[code] = append(positions[code], i)
+	}
+	for j, code := range b {
+		for _, i := range positions[code] {
+			votes[i-j]++
+		}
+	}
+	best := 0
+	for off, n := range votes {
+		if n > best || n == best && abs(off) < abs(offset) {
+			offset, best = off, n
+		}
+	}
+	var same, total int
+	for j, code := range b {
+		if i := j + offset; i >= 0 && i < len(a) {
+			same += 32 - bits.OnesCount32(a[i]^code)
+			total += 32
+		}
+	}
+	shorter := len(a)
+	if len(b) < shorter {
+		shorter = len(b)
+	}
+	if total < 32*shorter/2 {
+		return 0 // They barely overlap.
+	}
+	return float64(same) / float64(total)
+}
+
+// DuplicateCluster is a set of files that sound the same.
+type DuplicateCluster struct {
+	Files []string `json:"files"`
+
+	// Similarity is the lowest similarity of the pairs of files that put
+	// them in the same cluster, see Similarity.
+	Similarity float64 `json:"similarity"`
+}
+
+// ClusterDuplicates clusters files by their fingerprints, putting files
+// that are at least threshold similar in the same cluster, and returns the
+// clusters of more than one file. Only files that share codes, the rarer
+// ones, are compared, so that it scales to whole libraries.
+func ClusterDuplicates(names []string, fps []Fingerprint, threshold float64) []DuplicateCluster {
+	var (
+		parent = make([]int, len(names))
+		lowest = make([]float64, len(names)) // Of each cluster, by root.
+		files  = map[uint32][]int{}
+	)
+	for i := range parent {
+		parent[i], lowest[i] = i, 1
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i, fp := range fps {
+		seen := map[uint32]bool{}
+		for _, code := range fp {
+			if !seen[code] {
+				seen[code] = true
+				files[code] = append(files[code], i)
+			}
+		}
+	}
+	compared := map[[2]int]bool{}
+	for _, candidates := range files {
+		if len(candidates) < 2 || len(candidates) > maxCodeFiles {
+			continue
+		}
+		for x, i := range candidates {
+			for _, j := range candidates[x+1:] {
+				if compared[[2]int{i, j}] {
+					continue
+				}
+				compared[[2]int{i, j}] = true
+
+				s := Similarity(fps[i], fps[j])
+				if s < threshold {
+					continue
+				}
+				ri, rj := find(i), find(j)
+				if ri != rj {
+					parent[rj] = ri
+					lowest[ri] = math.Min(lowest[ri], lowest[rj])
+				}
+				lowest[ri] = math.Min(lowest[ri], s)
+			}
+		}
+	}
+	byRoot := map[int]*DuplicateCluster{}
+	for i, name := range names {
+		r := find(i)
+		if byRoot[r] == nil {
+			byRoot[r] = &DuplicateCluster{Similarity: lowest[r]}
+		}
+		byRoot[r].Files = append(byRoot[r].Files, name)
+	}
+	var clusters []DuplicateCluster
+	for _, c := range byRoot {
+		if len(c.Files) > 1 {
+			sort.Strings(c.Files)
+			clusters = append(clusters, *c)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Files[0] < clusters[j].Files[0] })
+	return clusters
+}
+
+// duplicates runs the duplicates command: "iowa duplicates [flags] [dir]"
+// fingerprints every audio file under dir (the current directory by
+// default), keeping them with their analyses, and lists the clusters of
+// files that sound the same, at least -similarity alike, whatever their
+// names or eras, as candidates to deduplicate.
+func (app *App) duplicates(ctx context.Context) error {
+	if len(app.Args) > 1 {
+		return withKind(FailureConfig, errors.New("usage: iowa duplicates [flags] [dir]"))
+	}
+	root := "."
+	if len(app.Args) == 1 {
+		root = app.Args[0]
+	}
+	analyses, err := app.analyzeFiles(ctx, root)
+	if err != nil {
+		return err
+	}
+	var (
+		names = make([]string, len(analyses))
+		fps   = make([]Fingerprint, len(analyses))
+	)
+	for i, an := range analyses {
+		names[i], fps[i] = an.Path, an.Fingerprint
+	}
+	var (
+		clusters = ClusterDuplicates(names, fps, app.Similarity)
+		rows     [][]string
+		dupes    = 0
+	)
+	for i, c := range clusters {
+		for _, name := range c.Files {
+			rows = append(rows, []string{strconv.Itoa(i + 1), fmt.Sprintf("%.2f", c.Similarity), name})
+		}
+		dupes += len(c.Files) - 1
+	}
+	if clusters == nil {
+		clusters = []DuplicateCluster{}
+	}
+	if err := app.printList(os.Stdout, clusters, []string{"CLUSTER", "SIMILARITY", "PATH"}, rows); err != nil {
+		return err
+	}
+	app.term.Status(StatusOK, "%d files, %d clusters of duplicates, %d files could be left out", len(analyses), len(clusters), dupes)
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultFixturesAddr is the address fixtures are served on by default.
+const DefaultFixturesAddr = "127.0.0.1:8080"
+
+// fixturePage is an instrument page in the fixture snapshot.
+type fixturePage struct {
+	Path  string   // Path of the page on the site.
+	Dir   string   // Directory the page's files are linked from, relative to the page.
+	Files []string // Names of the audio files.
+}
+
+// fixturePages is a snapshot of representative MIS pages: every post-2012
+// string page, with round robins on the cello, and the pre-2012 flute
+// page, whose files span ranges of notes.
+var fixturePages = []fixturePage{
+	{Path: "/MIS-Pitches-2012/MISViolin2012.html", Dir: "../sound files/MIS Pitches - 2014/Strings/Violin", Files: fixtureNames("Violin.arco.%s.sulG.%s.stereo.aif", "G3", "A3")},
+	{Path: "/MIS-Pitches-2012/MISViola2012.html", Dir: "../sound files/MIS Pitches - 2014/Strings/Viola", Files: fixtureNames("Viola.arco.%s.sulC.%s.stereo.aif", "C3", "D3")},
+	{Path: "/MIS-Pitches-2012/MISCello2012.html", Dir: "../sound files/MIS Pitches - 2014/Strings/Cello", Files: append(
+		fixtureNames("Cello.arco.%s.sulA.%s.stereo.aif", "A3", "B3", "C4"),
+		"Cello.arco.ff.sulD.C4.stereo.aif",
+	)},
+	{Path: "/MIS-Pitches-2012/MISDoubleBass2012.html", Dir: "../sound files/MIS Pitches - 2014/Strings/DoubleBass", Files: fixtureNames("DoubleBass.arco.%s.sulE.%s.mono.aif", "E1", "F1")},
+	{Path: "/MISflute.html", Dir: "sound files/MIS/Woodwinds/flute", Files: []string{
+		"Flute.vib.ff.B3B4.stereo.aif",
+		"Flute.vib.ff.C5B5.stereo.aif",
+		"Flute.nonvib.mf.B3B4.stereo.aif",
+	}},
+}
+
+// fixtureNames formats the name of a file for every dynamic (pp, mf and ff)
+// and note.
+func fixtureNames(format string, notes ...string) []string {
+	var names []string
+
+	for _, dyn := range []string{"pp", "mf", "ff"} {
+		for _, note := range notes {
+			names = append(names, fmt.Sprintf(format, dyn, note))
+		}
+	}
+	return names
+}
+
+// fixtures runs the fixtures subcommand: "iowa fixtures serve [addr]"
+// serves the fixture snapshot until it is interrupted.
+func (app *App) fixtures(ctx context.Context) error {
+	if len(app.Args) == 0 || app.Args[0] != "serve" || len(app.Args) > 2 {
+		return withKind(FailureConfig, errors.New("usage: iowa fixtures serve [addr]"))
+	}
+	addr := DefaultFixturesAddr
+	if len(app.Args) == 2 {
+		addr = app.Args[1]
+	}
+	srv := &http.Server{Addr: addr, Handler: NewFixtures()}
+
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(sctx) // Best effort.
+	}()
+	app.term.Status(StatusOK, "serving fixtures on http://%s, e.g. HTTP_PROXY=http://%s iowa -dl -e post-2012 -s strings", addr, addr)
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return errors.Wrap(err, "serving fixtures")
+	}
+	return nil
+}
+
+// NewFixtures returns a handler that serves the fixture snapshot at the
+// paths of the real site, with a short tone at the pitch of each file's
+// note for its audio. Requests are matched by path alone, so the handler
+// also works as an HTTP proxy for theremin.music.uiowa.edu.
+func NewFixtures() http.Handler {
+	var (
+		mux   = http.NewServeMux()
+		index bytes.Buffer
+	)
+	index.WriteString("<html><body>\n")
+
+	for _, page := range fixturePages {
+		var buf bytes.Buffer
+
+		buf.WriteString("<html><body>\n")
+		for _, name := range page.Files {
+			href := page.Dir + "/" + name
+			fmt.Fprintf(&buf, "<a href=\"%s\">%s</a><br>\n", html.EscapeString(href), html.EscapeString(name))
+
+			name, data := name, fixtureAudio(name)
+			mux.HandleFunc(path.Clean(path.Join(path.Dir(page.Path), href)), func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+			})
+		}
+		buf.WriteString("</body></html>\n")
+
+		body := buf.Bytes()
+		mux.HandleFunc(page.Path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write(body)
+		})
+		fmt.Fprintf(&index, "<a href=\"%s\">%s</a><br>\n", page.Path, strings.TrimPrefix(page.Path, "/"))
+	}
+	index.WriteString("</body></html>\n")
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(index.Bytes())
+	})
+	return mux
+}
+
+// fixtureAudio returns a 16-bit, 44.1 kHz AIFF file of a tenth of a second
+// of a sine at the pitch of the note in name, fading out.
+func fixtureAudio(name string) []byte {
+	var (
+		sample   = ParseSample(name)
+		channels = 2
+		rate     = 44100
+		frames   = rate / 10
+		freq     = Frequency(sample.MIDI, 440)
+		data     bytes.Buffer
+	)
+	if sample.Channels == "mono" {
+		channels = 1
+	}
+	for i := 0; i < frames; i++ {
+		var (
+			t = float64(i) / float64(rate)
+			s = int16(0.5 * (1 - float64(i)/float64(frames)) * math.Sin(2*math.Pi*freq*t) * math.MaxInt16)
+		)
+		for c := 0; c < channels; c++ {
+			_ = binary.Write(&data, binary.BigEndian, s)
+		}
+	}
+	var buf bytes.Buffer
+
+	buf.WriteString("FORM")
+	_ = binary.Write(&buf, binary.BigEndian, uint32(4+8+18+8+8+data.Len()))
+	buf.WriteString("AIFFCOMM")
+	_ = binary.Write(&buf, binary.BigEndian, uint32(18))
+	_ = binary.Write(&buf, binary.BigEndian, int16(channels))
+	_ = binary.Write(&buf, binary.BigEndian, uint32(frames))
+	_ = binary.Write(&buf, binary.BigEndian, int16(16))
+	rb := floatToExtended(float64(rate))
+	buf.Write(rb[:])
+	buf.WriteString("SSND")
+	_ = binary.Write(&buf, binary.BigEndian, uint32(8+data.Len()))
+	buf.Write(make([]byte, 8)) // Offset and block size.
+	buf.Write(data.Bytes())
+	return buf.Bytes()
+}
+
+// floatToExtended converts a positive number to an 80-bit IEEE 754
+// extended precision number, the inverse of extendedToFloat.
+func floatToExtended(f float64) [10]byte {
+	var b [10]byte
+	if f <= 0 {
+		return b
+	}
+	exp := int(math.Floor(math.Log2(f)))
+	binary.BigEndian.PutUint16(b[0:2], uint16(16383+exp))
+	binary.BigEndian.PutUint64(b[2:10], uint64(f*math.Pow(2, float64(63-exp))))
+	return b
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// stringsFlag is a flag that can be repeated.
+type stringsFlag []string
+
+func (sf *stringsFlag) String() string {
+	return strings.Join(*sf, ",")
+}
+
+func (sf *stringsFlag) Set(s string) error {
+	*sf = append(*sf, s)
+	return nil
+}
+
+// listFlag is a comma-separated list flag that can also be repeated.
+type listFlag []string
+
+func (lf *listFlag) String() string {
+	return strings.Join(*lf, ",")
+}
+
+func (lf *listFlag) Set(s string) error {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			*lf = append(*lf, item)
+		}
+	}
+	return nil
+}
+
+// optionalFloat is a flag for a float that may not be set.
+type optionalFloat struct {
+	v **float64
+}
+
+func (of optionalFloat) String() string {
+	if of.v == nil || *of.v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(**of.v, 'g', -1, 64)
+}
+
+func (of optionalFloat) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*of.v = &f
+	return nil
+}
+
+// modeFlag is a flag for octal permissions, e.g. 0644. Zero means unset.
+type modeFlag struct {
+	m *os.FileMode
+}
+
+func (mf modeFlag) String() string {
+	if mf.m == nil || *mf.m == 0 {
+		return ""
+	}
+	return "0" + strconv.FormatUint(uint64(*mf.m), 8)
+}
+
+func (mf modeFlag) Set(s string) error {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n == 0 || n > 0777 {
+		return errors.New("expected octal permissions such as 0644")
+	}
+	*mf.m = os.FileMode(n)
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// maxMatchGain is the most gain in dB that -match-gain applies to a file,
+// either way, so that a file that measures wrong, e.g. mostly noise, isn't
+// made unusable.
+const maxMatchGain = 12
+
+// GainMatch is the gain applied to a processed file to match the level of
+// the other files of its dynamic, recorded in its metadata.
+type GainMatch struct {
+	Measured float64 `json:"measured_lufs"` // Integrated loudness as processed, before the gain.
+	Target   float64 `json:"target_lufs"`   // Median of the files of its dynamic.
+	Gain     float64 `json:"gain_db"`
+
+	// Limited is whether the gain was lowered to the true peak headroom of
+	// the file, so that it doesn't clip.
+	Limited bool `json:"limited,omitempty"`
+}
+
+// MatchGains measures the loudness of files, decoded by engine, as the
+// pipeline processes them, and returns the gain that matches each file to
+// the median of the files of its layer (its articulation and dynamic), by
+// path, so that every dynamic has the same level across the range of the
+// instrument and the dynamics keep their levels relative to each other.
+// Gains are limited to the headroom under the true peak of each file.
+// Silent files are left out.
+func (p Pipeline) MatchGains(engine Engine, files []File) (map[string]GainMatch, error) {
+	gains := map[string]GainMatch{}
+
+	for _, layer := range Layers(files) {
+		var (
+			measured = map[string]float64{}
+			headroom = map[string]float64{} // In dB, under 0 dBTP.
+			levels   []float64
+		)
+		for _, takes := range layer.Takes {
+			for _, f := range takes {
+				a, err := engine.Decode(f.Path)
+				if err != nil {
+					return gains, errors.Wrapf(err, "decoding %s", f.Path)
+				}
+				a = p.Apply(a)
+				if lufs, ok := IntegratedLoudness(a); ok {
+					measured[f.Path] = lufs
+					levels = append(levels, lufs)
+				}
+				if peak, ok := TruePeak(a); ok {
+					headroom[f.Path] = math.Max(0, -peak)
+				}
+			}
+		}
+		target := median(levels)
+		for name, lufs := range measured {
+			g := GainMatch{Measured: lufs, Target: target, Gain: math.Max(-maxMatchGain, math.Min(maxMatchGain, target-lufs))}
+			if g.Gain > headroom[name] {
+				g.Gain, g.Limited = headroom[name], true
+			}
+			gains[name] = g
+		}
+	}
+	return gains, nil
+}
+
+// WithGains returns a copy of the pipeline that applies gains, by path, to
+// the files it processes.
+func (p Pipeline) WithGains(gains map[string]GainMatch) Pipeline {
+	p.gains = gains
+	return p
+}
@@ -5,6 +5,8 @@ go 1.12
 require (
 	github.com/pkg/errors v0.8.1
 	github.com/yhat/scrape v0.0.0-20161128144610-24b7890b0945
+	go.etcd.io/bbolt v1.3.4
 	golang.org/x/net v0.0.0-20191109021931-daa7c04131f5
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
+	golang.org/x/text v0.3.2
 )
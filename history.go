@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var runsBucket = []byte("runs")
+
+// Run is a run of iowa that downloaded or verified files, as recorded in
+// the state database.
+type Run struct {
+	ID       uint64    `json:"id"`
+	Command  string    `json:"command"` // e.g. download, sync or verify.
+	Args     []string  `json:"args"`    // The command line.
+	Dir      string    `json:"dir"`     // The working directory.
+	Started  time.Time `json:"started"`
+	Ended    time.Time `json:"ended"` // Zero while running, or if the run crashed.
+	Files    int       `json:"files"`
+	Finished int       `json:"finished"` // Downloaded, or skipped because they were already there.
+	Failed   int       `json:"failed"`
+	Bytes    int64     `json:"bytes"`
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error,omitempty"`
+	Failures []Failure `json:"failures,omitempty"`
+}
+
+// Result returns a short description of the outcome of the run.
+func (r Run) Result() string {
+	switch {
+	case r.Ended.IsZero():
+		return "running or crashed"
+	case r.ExitCode == ExitOK:
+		return "ok"
+	}
+	return "failed (exit " + strconv.Itoa(r.ExitCode) + ")"
+}
+
+func runKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// PutRun records a run, giving it an ID if it doesn't have one yet.
+func (s *State) PutRun(r *Run) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(runsBucket)
+		if err != nil {
+			return err
+		}
+		if r.ID == 0 {
+			if r.ID, err = b.NextSequence(); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return b.Put(runKey(r.ID), data)
+	})
+	return errors.Wrap(err, "recording run")
+}
+
+// Runs returns every run, oldest first.
+func (s *State) Runs() ([]Run, error) {
+	var runs []Run
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var r Run
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			runs = append(runs, r)
+			return nil
+		})
+	})
+	return runs, errors.Wrap(err, "reading runs")
+}
+
+// Run returns the run with an ID, and false if there is none.
+func (s *State) Run(id uint64) (Run, bool, error) {
+	var (
+		r  Run
+		ok bool
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+		if b == nil {
+			return nil
+		}
+		data := b.Get(runKey(id))
+		if data == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(data, &r)
+	})
+	return r, ok, errors.Wrap(err, "reading run")
+}
+
+// secretFlags are the flags whose values aren't recorded in runs.
+var secretFlags = map[string]bool{"header": true, "login-data": true, "user": true}
+
+// redactArgs returns command line arguments with the values of secret
+// flags replaced.
+func redactArgs(args []string) []string {
+	const redacted = "REDACTED"
+
+	out := append([]string(nil), args...)
+	for i := 0; i < len(out); i++ {
+		if !strings.HasPrefix(out[i], "-") {
+			continue
+		}
+		name := strings.TrimLeft(out[i], "-")
+		if j := strings.Index(name, "="); j >= 0 {
+			if secretFlags[name[:j]] {
+				out[i] = out[i][:strings.Index(out[i], "=")+1] + redacted
+			}
+			continue
+		}
+		if secretFlags[name] && i+1 < len(out) {
+			out[i+1] = redacted
+			i++
+		}
+	}
+	return out
+}
+
+// beginRun records the start of this run.
+func (app *App) beginRun() {
+	command := app.Command
+	if len(command) == 0 {
+		command = "download"
+	}
+	dir, _ := os.Getwd() // Informational.
+
+	app.run = &Run{
+		Command: command,
+		Args:    redactArgs(os.Args[1:]),
+		Dir:     dir,
+		Started: time.Now().UTC(),
+	}
+	if err := app.state.PutRun(app.run); err != nil {
+		app.term.Status(StatusWarn, "%s", err)
+	}
+}
+
+// endRun records the end of this run, which returned err.
+func (app *App) endRun(err error) {
+	if app.run == nil {
+		return
+	}
+	var (
+		r    = app.run
+		snap = app.progress.Snapshot()
+	)
+	r.Ended = time.Now().UTC()
+	r.Files, r.Finished, r.Failed, r.Bytes = snap.Files, snap.Finished, snap.Failed, snap.Bytes
+	r.ExitCode = ExitCode(err)
+	r.Failures = app.failures.List()
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if err := app.state.PutRun(r); err != nil {
+		log.Print(err)
+	}
+}
+
+// openHistory opens an existing state database for the history and show
+// commands.
+func (app *App) openHistory() error {
+	if _, err := os.Stat(StateFile); os.IsNotExist(err) {
+		return withKind(FailureConfig, errors.New("no runs have been recorded here ("+StateFile+")"))
+	}
+	state, err := OpenState(StateFile)
+	if err != nil {
+		return withKind(FailureConfig, err)
+	}
+	app.state = state
+	return nil
+}
+
+// history runs the history command, which lists the runs recorded in the
+// state database.
+func (app *App) history(ctx context.Context) error {
+	if err := app.openHistory(); err != nil {
+		return err
+	}
+	runs, err := app.state.Runs()
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, len(runs))
+	for i, r := range runs {
+		duration := "-"
+		if !r.Ended.IsZero() {
+			duration = r.Ended.Sub(r.Started).Round(time.Second).String()
+		}
+		rows[i] = []string{
+			fmt.Sprint(r.ID),
+			r.Started.Local().Format("2006-01-02 15:04:05"),
+			duration,
+			r.Command,
+			fmt.Sprint(r.Files),
+			fmt.Sprint(r.Failed),
+			FormatBytes(r.Bytes),
+			r.Result(),
+		}
+	}
+	if runs == nil {
+		runs = []Run{}
+	}
+	return app.printList(os.Stdout, runs, []string{"ID", "STARTED", "DURATION", "COMMAND", "FILES", "FAILED", "BYTES", "RESULT"}, rows)
+}
+
+// show runs the show command: "iowa show run-id" prints the details of a
+// run, including its failures.
+func (app *App) show(ctx context.Context) error {
+	if len(app.Args) != 1 {
+		return withKind(FailureConfig, errors.New("usage: iowa show run-id"))
+	}
+	id, err := strconv.ParseUint(app.Args[0], 10, 64)
+	if err != nil {
+		return withKind(FailureConfig, errors.New("invalid run id: "+app.Args[0]))
+	}
+	if err := app.openHistory(); err != nil {
+		return err
+	}
+	r, ok, err := app.state.Run(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return withKind(FailureConfig, errors.Errorf("there is no run %d", id))
+	}
+	if app.Format == FormatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	ended := "-"
+	if !r.Ended.IsZero() {
+		ended = r.Ended.Local().Format(time.RFC3339)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, field := range [][2]string{
+		{"run", fmt.Sprint(r.ID)},
+		{"command", "iowa " + strings.Join(r.Args, " ")},
+		{"directory", r.Dir},
+		{"started", r.Started.Local().Format(time.RFC3339)},
+		{"ended", ended},
+		{"files", fmt.Sprintf("%d (%d finished, %d failed)", r.Files, r.Finished, r.Failed)},
+		{"downloaded", FormatBytes(r.Bytes)},
+		{"result", r.Result()},
+		{"error", r.Error},
+	} {
+		if len(field[1]) > 0 {
+			_, _ = fmt.Fprintf(tw, "%s:\t%s\n", field[0], field[1])
+		}
+	}
+	for _, f := range r.Failures {
+		_, _ = fmt.Fprintf(tw, "failure:\t%s %s: %s\n", f.Kind, f.URL, f.Error)
+	}
+	return errors.Wrap(tw.Flush(), "writing run")
+}
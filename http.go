@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	stdurl "net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	retryWait    = 500 * time.Millisecond
+	maxRetryWait = 30 * time.Second
+)
+
+// get fetches url, retrying failed requests while the retry budget of the
+// url's host allows it. Responses with a status of 300 or above, other
+// than 304, are returned as errors.
+func (app *App) get(ctx context.Context, url string) (*http.Response, error) {
+	return app.getWith(ctx, url, nil)
+}
+
+// getFrom is like get but asks for the content from offset onwards. The
+// server may ignore the range and respond with the whole content, in which
+// case the status is not 206.
+func (app *App) getFrom(ctx context.Context, url string, offset int64) (*http.Response, error) {
+	if offset == 0 {
+		return app.get(ctx, url)
+	}
+	return app.getWith(ctx, url, http.Header{"Range": {"bytes=" + strconv.FormatInt(offset, 10) + "-"}})
+}
+
+// getWith is like get but adds header to the request. If the host of url
+// fails, the same path is tried on each of its -mirror's in turn.
+func (app *App) getWith(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	if err := app.session(ctx); err != nil {
+		return nil, err
+	}
+	urls := app.mirrorsOf(url)
+
+	for i, u := range urls {
+		resp, err := app.getRetrying(ctx, u, header)
+		if err == nil {
+			return resp, nil
+		}
+		if i < len(urls)-1 && ctx.Err() == nil && retryable(resp) {
+			app.term.Status(StatusWarn, "%s: %s, trying %s", u, err, urls[i+1])
+			continue
+		}
+		return nil, err
+	}
+	return nil, errors.New("no urls") // mirrorsOf returns at least url.
+}
+
+// getRetrying fetches url, retrying failed requests while the retry budget
+// of its host allows it. If it fails, resp is the last response, if the
+// server responded, with its body closed.
+func (app *App) getRetrying(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	u, err := stdurl.Parse(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing url")
+	}
+	wait := retryWait
+
+	for attempt := 0; ; attempt++ {
+		resp, err := app.try(ctx, u, header)
+		if err == nil {
+			return resp, nil
+		}
+		if errors.Cause(err) == ErrCircuitOpen || ctx.Err() != nil || !retryable(resp) {
+			return resp, err
+		}
+		if attempt >= app.Retries || !app.breaker.Retry(u.Host) {
+			return resp, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+	}
+}
+
+// try makes a single request, feeding the outcome to the circuit breaker.
+// If the request failed, resp is only non-nil if the server responded.
+func (app *App) try(ctx context.Context, u *stdurl.URL, header http.Header) (*http.Response, error) {
+	if err := app.rates.Wait(ctx, u.Host); err != nil {
+		return nil, err
+	}
+	if err := app.breaker.Allow(u.Host); err != nil {
+		return nil, errors.Wrap(err, u.Host)
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating request")
+	}
+	app.authorize(req)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := app.client.Do(req.WithContext(ctx))
+	if err != nil {
+		app.breaker.Failure(u.Host)
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices && resp.StatusCode != http.StatusNotModified {
+		_ = resp.Body.Close() // Best effort.
+
+		// Client errors don't mean the host is in trouble.
+		if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+			app.breaker.Failure(u.Host)
+		} else {
+			app.breaker.Success(u.Host)
+		}
+		return resp, errors.New(resp.Status)
+	}
+	app.breaker.Success(u.Host)
+	return resp, nil
+}
+
+// retryable returns true if a request that failed with resp should be retried.
+// resp is nil if the request failed before the server responded.
+func retryable(resp *http.Response) bool {
+	if resp == nil {
+		return true
+	}
+	return resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+}
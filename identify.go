@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// maxMatches is the most samples that identify lists for a file.
+const maxMatches = 5
+
+// Match is a downloaded sample that a file sounds like.
+type Match struct {
+	Path       string  `json:"path"`
+	URL        string  `json:"url,omitempty"`
+	Similarity float64 `json:"similarity"`
+}
+
+// Identification is the samples that a file sounds like, the most similar
+// first.
+type Identification struct {
+	File    string  `json:"file"`
+	Matches []Match `json:"matches"`
+}
+
+// Identify returns the analyses whose fingerprints are at least threshold
+// similar to fp, the most similar first, up to maxMatches.
+func Identify(fp Fingerprint, analyses []Analysis, threshold float64) []Match {
+	matches := []Match{}
+	for _, an := range analyses {
+		if s := Similarity(an.Fingerprint, fp); s >= threshold {
+			matches = append(matches, Match{Path: an.Path, Similarity: s})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Similarity > matches[j].Similarity })
+	if len(matches) > maxMatches {
+		matches = matches[:maxMatches]
+	}
+	return matches
+}
+
+// identify runs the identify command: "iowa identify [flags] file..." tells
+// which of the samples downloaded to the current directory each file came
+// from, if any, by their fingerprints, e.g. to audit the samples of an old
+// project that were renamed, trimmed or converted. The downloaded samples
+// are fingerprinted first if they haven't been, see analyze.
+func (app *App) identify(ctx context.Context) error {
+	if len(app.Args) == 0 {
+		return withKind(FailureConfig, errors.New("usage: iowa identify [flags] file..."))
+	}
+	analyses, err := app.analyzeFiles(ctx, ".")
+	if err != nil {
+		return err
+	}
+	urls, err := stateURLs(".")
+	if err != nil {
+		return err
+	}
+	var (
+		ids  = make([]Identification, len(app.Args))
+		rows [][]string
+	)
+	for i, name := range app.Args {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		a, err := app.engine.Decode(name)
+		if err != nil {
+			return withKind(FailureConfig, errors.Wrap(err, name))
+		}
+		var (
+			self    = filepath.ToSlash(filepath.Clean(name))
+			library = make([]Analysis, 0, len(analyses))
+		)
+		for _, an := range analyses {
+			if an.Path != self {
+				library = append(library, an)
+			}
+		}
+		ids[i] = Identification{File: name, Matches: Identify(NewFingerprint(a), library, app.Similarity)}
+
+		if len(ids[i].Matches) == 0 {
+			app.term.Status(StatusWarn, "%s: no downloaded sample sounds like it", name)
+			continue
+		}
+		for j := range ids[i].Matches {
+			m := &ids[i].Matches[j]
+			m.URL = urls[m.Path]
+			rows = append(rows, []string{name, fmt.Sprintf("%.2f", m.Similarity), m.Path, m.URL})
+		}
+	}
+	return app.printList(os.Stdout, ids, []string{"FILE", "SIMILARITY", "SAMPLE", "URL"}, rows)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// JobsAuto is the value of Config.Jobs when concurrency is tuned automatically.
+const JobsAuto = -1
+
+// Bounds for automatically tuned concurrency.
+const (
+	minAutoJobs   = 1
+	startAutoJobs = 2
+	maxAutoJobs   = 32
+)
+
+// ParseJobs parses the value of the -jobs flag: a number of concurrent
+// transfers (0 is unlimited) or "auto".
+func ParseJobs(s string) (int, error) {
+	if s == "auto" {
+		return JobsAuto, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errors.New("jobs must be a non-negative number or auto: " + s)
+	}
+	return n, nil
+}
+
+// Limiter limits the number of concurrent transfers.
+// The limit can be changed while transfers are running.
+type Limiter struct {
+	mu      sync.Mutex
+	limit   int // Zero or less is unlimited.
+	active  int
+	waiters []chan struct{}
+}
+
+// NewLimiter creates a new limiter.
+func NewLimiter(limit int) *Limiter {
+	return &Limiter{limit: limit}
+}
+
+// Acquire blocks until a transfer may start or ctx is done.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.limit <= 0 || l.active < l.limit {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	l.waiters = append(l.waiters, ch)
+	l.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		for i, w := range l.waiters {
+			if w == ch {
+				l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+				l.mu.Unlock()
+				return ctx.Err()
+			}
+		}
+		l.mu.Unlock()
+
+		// We were granted a slot at the same time ctx was done.
+		l.Release()
+		return ctx.Err()
+	}
+}
+
+// Release ends a transfer started with Acquire.
+func (l *Limiter) Release() {
+	l.mu.Lock()
+	l.active--
+	l.grant()
+	l.mu.Unlock()
+}
+
+// Limit returns the current limit.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// SetLimit changes the limit.
+func (l *Limiter) SetLimit(n int) {
+	l.mu.Lock()
+	l.limit = n
+	l.grant()
+	l.mu.Unlock()
+}
+
+// grant must be called with l.mu held.
+func (l *Limiter) grant() {
+	for len(l.waiters) > 0 && (l.limit <= 0 || l.active < l.limit) {
+		ch := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		l.active++
+		close(ch)
+	}
+}
+
+// Tuner adjusts a limiter based on the aggregate throughput and error rate
+// of a job. It starts conservatively and keeps adding transfers while that
+// improves throughput, backing off when throughput drops and halving the
+// limit when too many transfers fail.
+type Tuner struct {
+	limiter  *Limiter
+	progress *Progress
+
+	lastBytes int64
+	lastDone  int
+	lastFail  int
+	lastRate  int64
+	direction int
+}
+
+// NewTuner creates a tuner and sets the limiter to its starting limit.
+func NewTuner(l *Limiter, p *Progress) *Tuner {
+	l.SetLimit(startAutoJobs)
+	return &Tuner{limiter: l, progress: p, direction: 1}
+}
+
+// Run adjusts the limit every interval until ctx is done.
+func (t *Tuner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.adjust(interval)
+		}
+	}
+}
+
+func (t *Tuner) adjust(interval time.Duration) {
+	var (
+		snap  = t.progress.Snapshot()
+		rate  = rate(snap.Bytes-t.lastBytes, interval)
+		done  = snap.Finished - t.lastDone
+		fail  = snap.Failed - t.lastFail
+		limit = t.limiter.Limit()
+	)
+	t.lastBytes, t.lastDone, t.lastFail = snap.Bytes, snap.Finished, snap.Failed
+
+	switch {
+	case fail > 0 && fail*10 >= done+fail:
+		// More than 10% of the transfers are failing.
+		limit /= 2
+		t.direction = 1
+	case rate == 0 || t.lastRate == 0:
+		// Idle, paused or scraping a page: there is nothing to compare.
+	case rate*100 >= t.lastRate*105:
+		limit += t.direction
+	case rate*100 <= t.lastRate*95:
+		t.direction = -t.direction
+		limit += t.direction
+	}
+	t.lastRate = rate
+
+	if limit < minAutoJobs {
+		limit = minAutoJobs
+	}
+	if limit > maxAutoJobs {
+		limit = maxAutoJobs
+	}
+	t.limiter.SetLimit(limit)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LayerLevel is the loudness of a dynamic of a note, the mean of its files'
+// if it has several, e.g. one for each string.
+type LayerLevel struct {
+	Dynamic string   `json:"dynamic"`
+	LUFS    float64  `json:"lufs"`
+	Files   []string `json:"files"`
+}
+
+// NoteLayers are the levels of the dynamics of a note of an instrument, by
+// articulation, softest first.
+type NoteLayers struct {
+	Articulation string       `json:"articulation"`
+	Note         string       `json:"note"`
+	MIDI         int          `json:"midi"`
+	Levels       []LayerLevel `json:"levels"`
+
+	// Inversions are the dynamics that aren't -layer-margin LU louder
+	// than the dynamic below them, e.g. "ff is 1.2 LU quieter than mf".
+	Inversions []string `json:"inversions,omitempty"`
+}
+
+// LayerReport is the consistency of the levels of the dynamics of the notes
+// of an instrument.
+type LayerReport struct {
+	Page
+	Notes      []NoteLayers `json:"notes"`
+	Inversions int          `json:"inversions"`
+}
+
+// NewLayerReport compares the levels of the dynamics of each note of an
+// instrument, by articulation, flagging the dynamics that are less than
+// margin LU louder than the softer dynamic below them. Files whose dynamic
+// isn't one of Dynamics, or that are silent, are left out.
+func NewLayerReport(page Page, files []File, measured []FileLoudness, margin float64) LayerReport {
+	type key struct {
+		articulation, note string
+	}
+	var (
+		r       = LayerReport{Page: page, Notes: []NoteLayers{}}
+		notes   = map[key]*NoteLayers{}
+		sums    = map[key]map[string]float64{}
+		levels  = map[key]map[string]int{} // Indexes of the levels of each note by dynamic.
+		ordered []key
+	)
+	for i, f := range files {
+		if f.MIDI == 0 || DynamicIndex(f.Dynamic) < 0 || measured[i].Silent {
+			continue
+		}
+		note := f.Note
+		if f.HighMIDI > f.MIDI {
+			note += "-" + f.HighNote
+		}
+		k := key{f.Articulation, note}
+		nl, ok := notes[k]
+		if !ok {
+			nl = &NoteLayers{Articulation: f.Articulation, Note: note, MIDI: f.MIDI}
+			notes[k], sums[k], levels[k] = nl, map[string]float64{}, map[string]int{}
+			ordered = append(ordered, k)
+		}
+		j, ok := levels[k][f.Dynamic]
+		if !ok {
+			j = len(nl.Levels)
+			levels[k][f.Dynamic] = j
+			nl.Levels = append(nl.Levels, LayerLevel{Dynamic: f.Dynamic})
+		}
+		nl.Levels[j].Files = append(nl.Levels[j].Files, f.Path)
+		sums[k][f.Dynamic] += measured[i].LUFS
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		a, b := notes[ordered[i]], notes[ordered[j]]
+		if a.Articulation != b.Articulation {
+			return a.Articulation < b.Articulation
+		}
+		if a.MIDI != b.MIDI {
+			return a.MIDI < b.MIDI
+		}
+		return a.Note < b.Note
+	})
+	for _, k := range ordered {
+		nl := notes[k]
+		for j, l := range nl.Levels {
+			nl.Levels[j].LUFS = sums[k][l.Dynamic] / float64(len(l.Files))
+		}
+		sort.Slice(nl.Levels, func(i, j int) bool {
+			return DynamicIndex(nl.Levels[i].Dynamic) < DynamicIndex(nl.Levels[j].Dynamic)
+		})
+		for j := 1; j < len(nl.Levels); j++ {
+			softer, louder := nl.Levels[j-1], nl.Levels[j]
+			if diff := louder.LUFS - softer.LUFS; diff < margin {
+				desc := fmt.Sprintf("%s is %.1f LU quieter than %s", louder.Dynamic, -diff, softer.Dynamic)
+				if diff >= 0 {
+					desc = fmt.Sprintf("%s is only %.1f LU louder than %s", louder.Dynamic, diff, softer.Dynamic)
+				}
+				nl.Inversions = append(nl.Inversions, desc)
+			}
+		}
+		r.Inversions += len(nl.Inversions)
+		r.Notes = append(r.Notes, *nl)
+	}
+	return r
+}
+
+// layers runs the layers command: "iowa layers [flags] [dir]" measures the
+// loudness of every audio file under dir (the current directory by default)
+// and compares the dynamics of each note of each instrument, reporting the
+// ones that are quieter than the dynamic below them, or less than
+// -layer-margin LU louder, which would make mapped instruments respond to
+// velocity unevenly.
+func (app *App) layers(ctx context.Context) error {
+	if len(app.Args) > 1 {
+		return withKind(FailureConfig, errors.New("usage: iowa layers [flags] [dir]"))
+	}
+	root := "."
+	if len(app.Args) == 1 {
+		root = app.Args[0]
+	}
+	insts, err := app.localInstruments(root)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(insts, func(i, j int) bool { return insts[i].Page.String() < insts[j].Page.String() })
+
+	var (
+		reports    = make([]LayerReport, len(insts))
+		rows       [][]string
+		notes      = 0
+		inversions = 0
+	)
+	for i, inst := range insts {
+		measured, err := app.measureLoudness(ctx, inst.Files)
+		if err != nil {
+			return err
+		}
+		reports[i] = NewLayerReport(inst.Page, inst.Files, measured, app.LayerMargin)
+
+		for _, nl := range reports[i].Notes {
+			levels := make([]string, len(nl.Levels))
+			for j, l := range nl.Levels {
+				levels[j] = fmt.Sprintf("%s %.1f", l.Dynamic, l.LUFS)
+			}
+			rows = append(rows, []string{
+				inst.Page.Era, inst.Page.Section, inst.Page.Instrument, nl.Articulation, nl.Note,
+				strings.Join(levels, ", "), strconv.Itoa(len(nl.Inversions)),
+			})
+			for _, inv := range nl.Inversions {
+				app.term.Status(StatusWarn, "%s %s %s: %s", inst.Page.Instrument, nl.Articulation, nl.Note, inv)
+			}
+		}
+		notes += len(reports[i].Notes)
+		inversions += reports[i].Inversions
+	}
+	if err := app.printList(os.Stdout, reports, []string{"ERA", "SECTION", "INSTRUMENT", "ARTICULATION", "NOTE", "LEVELS", "INVERSIONS"}, rows); err != nil {
+		return err
+	}
+	status := StatusOK
+	if inversions > 0 {
+		status = StatusWarn
+	}
+	app.term.Status(status, "%d notes, %d inconsistent dynamics", notes, inversions)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ParseBytes parses a byte count such as 500M, 50GiB or 1024, with binary
+// multiples as FormatBytes prints them.
+func ParseBytes(s string) (int64, error) {
+	const units = "KMGTPE"
+
+	var (
+		t    = strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+		mult = int64(1)
+	)
+	if n := len(t); n > 0 {
+		if i := strings.IndexByte(units, t[n-1]); i >= 0 {
+			mult, t = 1<<(10*uint(i+1)), t[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+	if err != nil || f < 0 {
+		return 0, errors.New("expected a size such as 500M or 50G: " + s)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// Quota stops downloads from starting once a number of files or bytes
+// have been downloaded. Files already downloading when the quota is
+// reached are finished, so it can be exceeded by the files in flight.
+type Quota struct {
+	mu       sync.Mutex
+	maxFiles int   // Zero or less is unlimited.
+	maxBytes int64 // Zero or less is unlimited.
+	files    int
+	bytes    int64
+}
+
+// NewQuota creates a quota.
+func NewQuota(maxFiles int, maxBytes int64) *Quota {
+	return &Quota{maxFiles: maxFiles, maxBytes: maxBytes}
+}
+
+// Take counts a download of size bytes (-1 if unknown) and returns true,
+// or returns false if the quota has been reached.
+func (q *Quota) Take(size int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.reached() {
+		return false
+	}
+	q.files++
+	if size > 0 {
+		q.bytes += size
+	}
+	return true
+}
+
+// Reached returns true if no more downloads may start.
+func (q *Quota) Reached() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.reached()
+}
+
+func (q *Quota) reached() bool {
+	return (q.maxFiles > 0 && q.files >= q.maxFiles) || (q.maxBytes > 0 && q.bytes >= q.maxBytes)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// Output formats of the commands that list things.
+const (
+	FormatTable   = "table" // Aligned columns with a header.
+	FormatJSON    = "json"
+	FormatPlain   = "plain"   // Tab-separated values, without a header.
+	FormatParquet = "parquet" // A Parquet file of the records, see WriteParquet.
+)
+
+// SectionInfo is a section of the catalog, as listed by the sections command.
+type SectionInfo struct {
+	Era         string `json:"era"`
+	Section     string `json:"section"`
+	Instruments int    `json:"instruments"`
+}
+
+// InstrumentInfo is an instrument page, as listed by the instruments command.
+type InstrumentInfo struct {
+	Page
+	Files *int `json:"files,omitempty"` // Number of audio files, with -live.
+}
+
+// sections runs the sections command, which lists the sections of the
+// catalog selected by -e, -s and -exclude-section.
+func (app *App) sections(ctx context.Context) error {
+	pages, err := app.pages()
+	if err != nil {
+		return err
+	}
+	var (
+		infos []SectionInfo
+		index = map[string]int{}
+	)
+	for _, page := range pages {
+		key := page.Era + "/" + page.Section
+		i, ok := index[key]
+		if !ok {
+			i = len(infos)
+			index[key] = i
+			infos = append(infos, SectionInfo{Era: page.Era, Section: page.Section})
+		}
+		infos[i].Instruments++
+	}
+	rows := make([][]string, len(infos))
+	for i, info := range infos {
+		rows[i] = []string{info.Era, info.Section, fmt.Sprint(info.Instruments)}
+	}
+	return app.printList(os.Stdout, infos, []string{"ERA", "SECTION", "INSTRUMENTS"}, rows)
+}
+
+// instruments runs the instruments command, which lists the instrument
+// pages selected by -e, -s and -exclude-section. With -live it scrapes
+// every page to count its audio files.
+func (app *App) instruments(ctx context.Context) error {
+	pages, err := app.pages()
+	if err != nil {
+		return err
+	}
+	var (
+		infos  = make([]InstrumentInfo, len(pages))
+		rows   = make([][]string, len(pages))
+		header = []string{"ERA", "SECTION", "INSTRUMENT", "URL"}
+	)
+	if app.Live {
+		header = append(header, "FILES")
+	}
+	for i, page := range pages {
+		infos[i] = InstrumentInfo{Page: page}
+		rows[i] = []string{page.Era, page.Section, page.Instrument, page.URL}
+
+		if app.Live {
+			links, err := app.scrape(ctx, page.URL)
+			if err != nil {
+				return withKind(FailureScrape, errors.Wrap(err, "scraping "+page.URL))
+			}
+			n := len(links)
+			infos[i].Files = &n
+			rows[i] = append(rows[i], fmt.Sprint(n))
+		}
+	}
+	return app.printList(os.Stdout, infos, header, rows)
+}
+
+// printList prints a list in the -format: v as JSON, or the rows of a
+// table with the header.
+func (app *App) printList(w io.Writer, v interface{}, header []string, rows [][]string) error {
+	switch app.Format {
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(v)
+	case FormatParquet:
+		return WriteParquet(w, v)
+	case FormatPlain:
+		for _, row := range rows {
+			if _, err := fmt.Fprintln(w, strings.Join(row, "\t")); err != nil {
+				return errors.Wrap(err, "writing list")
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "writing list")
+	}
+	return nil
+}
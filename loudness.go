@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// Gates of integrated loudness, see ITU-R BS.1770.
+const (
+	absoluteGate = -70 // LUFS
+	relativeGate = -10 // LU below the loudness of the blocks above the absolute gate.
+)
+
+// truePeakTaps is the number of taps of each phase of the interpolation
+// filter that true peaks are measured with.
+const truePeakTaps = 12
+
+// biquad is a second order IIR filter.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+}
+
+// kWeighting returns the filters of the K-weighting of BS.1770 at a sample
+// rate: a high shelf modelling the head, then a high pass. At 48 kHz they
+// are the filters of the standard.
+func kWeighting(rate float64) [2]biquad {
+	var f [2]biquad
+
+	// Shelf of +4 dB above 1.7 kHz.
+	var (
+		k  = math.Tan(math.Pi * 1681.974450955533 / rate)
+		q  = 0.7071752369554196
+		vh = math.Pow(10, 3.999843853973347/20)
+		vb = math.Pow(vh, 0.4996667741545416)
+		a0 = 1 + k/q + k*k
+	)
+	f[0] = biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	// High pass at 38 Hz.
+	k, q = math.Tan(math.Pi*38.13547087602444/rate), 0.5003270373238773
+	a0 = 1 + k/q + k*k
+	f[1] = biquad{b0: 1, b1: -2, b2: 1, a1: 2 * (k*k - 1) / a0, a2: (1 - k/q + k*k) / a0}
+	return f
+}
+
+// filter filters x in place.
+func (f biquad) filter(x []float64) {
+	var x1, x2, y1, y2 float64
+
+	for i, v := range x {
+		y := f.b0*v + f.b1*x1 + f.b2*x2 - f.a1*y1 - f.a2*y2
+		x2, x1 = x1, v
+		y2, y1 = y1, y
+		x[i] = y
+	}
+}
+
+// channel returns a channel of a.
+func (a *Audio) channel(c int) []float64 {
+	x := make([]float64, a.Frames())
+	for i := range x {
+		x[i] = a.Data[i*a.Channels+c]
+	}
+	return x
+}
+
+// IntegratedLoudness returns the integrated loudness of a in LUFS, of
+// gated 400 ms blocks overlapping by 75% as BS.1770 measures it, or of the
+// whole of a if it is shorter than a block. Every channel is weighted the
+// same. It returns false if a is silent, below the absolute gate.
+func IntegratedLoudness(a *Audio) (float64, bool) {
+	frames := a.Frames()
+	if frames == 0 || a.SampleRate <= 0 {
+		return 0, false
+	}
+	var (
+		block = int(0.4 * float64(a.SampleRate))
+		step  = block / 4
+		k     = kWeighting(float64(a.SampleRate))
+	)
+	if block > frames || step == 0 {
+		block, step = frames, frames
+	}
+	powers := make([]float64, (frames-block)/step+1) // Of each block, over the channels.
+
+	for c := 0; c < a.Channels; c++ {
+		x := a.channel(c)
+		k[0].filter(x)
+		k[1].filter(x)
+
+		for i := range powers {
+			var sum float64
+			for _, v := range x[i*step : i*step+block] {
+				sum += v * v
+			}
+			powers[i] += sum / float64(block)
+		}
+	}
+	loudness := func(power float64) float64 { return -0.691 + 10*math.Log10(power) }
+
+	gated := func(gate float64) (float64, int) {
+		var sum float64
+		n := 0
+		for _, p := range powers {
+			if p > 0 && loudness(p) > gate {
+				sum += p
+				n++
+			}
+		}
+		if n == 0 {
+			return 0, 0
+		}
+		return sum / float64(n), n
+	}
+	power, n := gated(absoluteGate)
+	if n == 0 {
+		return 0, false
+	}
+	power, n = gated(loudness(power) + relativeGate)
+	if n == 0 {
+		return 0, false
+	}
+	return loudness(power), true
+}
+
+// TruePeak returns the true peak of a in dBTP: its peak oversampled, four
+// times below 96 kHz and twice below 192 kHz, so that peaks between samples
+// count, as BS.1770 measures it. It returns false if a is silent.
+func TruePeak(a *Audio) (float64, bool) {
+	factor := 1
+	switch {
+	case a.SampleRate < 96000:
+		factor = 4
+	case a.SampleRate < 192000:
+		factor = 2
+	}
+	// Hann windowed sinc interpolation filter, by phase.
+	var (
+		half   = truePeakTaps / 2
+		phases = make([][]float64, factor)
+	)
+	for p := range phases {
+		phases[p] = make([]float64, truePeakTaps)
+		for t := range phases[p] {
+			x := float64(t-half+1) - float64(p)/float64(factor)
+			w := 0.5 + 0.5*math.Cos(math.Pi*x/float64(half))
+			if x == 0 {
+				phases[p][t] = 1
+			} else {
+				phases[p][t] = w * math.Sin(math.Pi*x) / (math.Pi * x)
+			}
+		}
+	}
+	var peak float64
+
+	frames := a.Frames()
+	for c := 0; c < a.Channels; c++ {
+		x := a.channel(c)
+		for i := range x {
+			peak = math.Max(peak, math.Abs(x[i]))
+			for _, taps := range phases[1:] {
+				var sum float64
+				for t, h := range taps {
+					if j := i + t - half + 1; j >= 0 && j < frames {
+						sum += h * x[j]
+					}
+				}
+				peak = math.Max(peak, math.Abs(sum))
+			}
+		}
+	}
+	if peak == 0 {
+		return 0, false
+	}
+	return 20 * math.Log10(peak), true
+}
+
+// FileLoudness is the loudness of a file.
+type FileLoudness struct {
+	Path     string  `json:"path"`
+	LUFS     float64 `json:"lufs"`
+	TruePeak float64 `json:"true_peak"` // In dBTP.
+	Silent   bool    `json:"silent,omitempty"`
+
+	// Deviation is how far the file is from the median of its dynamic of
+	// its instrument, in LU, and Outlier is true if that is over
+	// -loudness-tolerance.
+	Deviation float64 `json:"deviation"`
+	Outlier   bool    `json:"outlier,omitempty"`
+}
+
+// LoudnessGroup is the loudness of the files of a dynamic of an instrument.
+type LoudnessGroup struct {
+	Page
+	Dynamic  string         `json:"dynamic"`
+	Median   float64        `json:"median"` // In LUFS, of the files that aren't silent.
+	Min      float64        `json:"min"`
+	Max      float64        `json:"max"`
+	TruePeak float64        `json:"true_peak"` // The highest of its files'.
+	Outliers int            `json:"outliers"`
+	Files    []FileLoudness `json:"files"`
+}
+
+// median returns the median of xs, which it sorts.
+func median(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	sort.Float64s(xs)
+	if n := len(xs); n%2 == 0 {
+		return (xs[n/2-1] + xs[n/2]) / 2
+	}
+	return xs[len(xs)/2]
+}
+
+// NewLoudnessGroup summarizes the loudness of files, finding the ones that
+// are more than tolerance LU from their median.
+func NewLoudnessGroup(page Page, dynamic string, files []FileLoudness, tolerance float64) LoudnessGroup {
+	g := LoudnessGroup{Page: page, Dynamic: dynamic, Files: files, Min: math.Inf(1), Max: math.Inf(-1), TruePeak: math.Inf(-1)}
+
+	var levels []float64
+	for _, f := range files {
+		if f.Silent {
+			continue
+		}
+		levels = append(levels, f.LUFS)
+		g.Min, g.Max, g.TruePeak = math.Min(g.Min, f.LUFS), math.Max(g.Max, f.LUFS), math.Max(g.TruePeak, f.TruePeak)
+	}
+	if len(levels) == 0 {
+		g.Min, g.Max, g.TruePeak = 0, 0, 0
+		return g
+	}
+	g.Median = median(levels)
+
+	for i, f := range g.Files {
+		if f.Silent {
+			continue
+		}
+		g.Files[i].Deviation = f.LUFS - g.Median
+		if math.Abs(g.Files[i].Deviation) > tolerance {
+			g.Files[i].Outlier = true
+			g.Outliers++
+		}
+	}
+	return g
+}
+
+// measureLoudness measures the loudness of files, as many at once as -jobs
+// allows.
+func (app *App) measureLoudness(ctx context.Context, files []File) ([]FileLoudness, error) {
+	var (
+		measured = make([]FileLoudness, len(files))
+		g        errgroup.Group
+	)
+	for i, f := range files {
+		i, f := i, f
+		g.Go(func() error {
+			if err := app.limiter.Acquire(ctx); err != nil {
+				return err
+			}
+			defer app.limiter.Release()
+
+			fl := FileLoudness{Path: f.Path, Silent: true}
+			a, err := app.engine.Decode(f.Path)
+			if err != nil {
+				app.term.Status(StatusWarn, "%s: %s", f.Path, err)
+				measured[i] = fl
+				return nil
+			}
+			lufs, ok := IntegratedLoudness(a)
+			peak, _ := TruePeak(a)
+			if ok {
+				fl.LUFS, fl.TruePeak, fl.Silent = lufs, peak, false
+			}
+			measured[i] = fl
+			return nil
+		})
+	}
+	return measured, g.Wait()
+}
+
+// loudness runs the loudness command: "iowa loudness [flags] [dir]"
+// measures the integrated loudness and true peak of every audio file under
+// dir (the current directory by default) and reports them by dynamic of
+// each instrument, with the files more than -loudness-tolerance LU from the
+// median of theirs, e.g. to choose a -normalize level by.
+func (app *App) loudness(ctx context.Context) error {
+	if len(app.Args) > 1 {
+		return withKind(FailureConfig, errors.New("usage: iowa loudness [flags] [dir]"))
+	}
+	root := "."
+	if len(app.Args) == 1 {
+		root = app.Args[0]
+	}
+	insts, err := app.localInstruments(root)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(insts, func(i, j int) bool { return insts[i].Page.String() < insts[j].Page.String() })
+
+	var (
+		groups = []LoudnessGroup{}
+		rows   [][]string
+		levels []float64
+		peak   = math.Inf(-1)
+	)
+	for _, inst := range insts {
+		measured, err := app.measureLoudness(ctx, inst.Files)
+		if err != nil {
+			return err
+		}
+		var (
+			byDynamic = map[string][]FileLoudness{}
+			dynamics  []string
+		)
+		for i, f := range inst.Files {
+			if _, ok := byDynamic[f.Dynamic]; !ok {
+				dynamics = append(dynamics, f.Dynamic)
+			}
+			byDynamic[f.Dynamic] = append(byDynamic[f.Dynamic], measured[i])
+		}
+		sort.Slice(dynamics, func(i, j int) bool { return DynamicIndex(dynamics[i]) < DynamicIndex(dynamics[j]) })
+
+		for _, dynamic := range dynamics {
+			g := NewLoudnessGroup(inst.Page, dynamic, byDynamic[dynamic], app.LoudnessTolerance)
+			groups = append(groups, g)
+
+			name := dynamic
+			if len(name) == 0 {
+				name = "none"
+			}
+			rows = append(rows, []string{
+				g.Era, g.Section, g.Instrument, name, strconv.Itoa(len(g.Files)),
+				fmt.Sprintf("%.1f", g.Median), fmt.Sprintf("%.1f", g.Min), fmt.Sprintf("%.1f", g.Max),
+				fmt.Sprintf("%.1f", g.TruePeak), strconv.Itoa(g.Outliers),
+			})
+			for _, f := range g.Files {
+				if f.Silent {
+					continue
+				}
+				levels = append(levels, f.LUFS)
+				peak = math.Max(peak, f.TruePeak)
+			}
+		}
+	}
+	if err := app.printList(os.Stdout, groups, []string{"ERA", "SECTION", "INSTRUMENT", "DYNAMIC", "FILES", "MEDIAN", "MIN", "MAX", "PEAK", "OUTLIERS"}, rows); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		for _, f := range g.Files {
+			if f.Outlier {
+				app.term.Status(StatusWarn, "%s: %.1f LUFS, %+.1f LU from the median of %s %s", f.Path, f.LUFS, f.Deviation, g.Instrument, g.Dynamic)
+			}
+		}
+	}
+	if len(levels) > 0 {
+		app.term.Status(StatusOK, "%d files: median %.1f LUFS, highest true peak %.1f dBTP", len(levels), median(levels), peak)
+	}
+	return nil
+}
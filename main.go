@@ -1,16 +1,23 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"flag"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	stdurl "net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/yhat/scrape"
@@ -23,32 +30,243 @@ import (
 func main() {
 	config, err := NewConfig()
 	if err != nil {
-		log.Fatal(errors.Wrap(err, "parsing config"))
+		log.Print(errors.Wrap(err, "parsing config"))
+		os.Exit(ExitConfig)
 	}
 	app, err := NewApp(config)
 	if err != nil {
-		log.Fatal(errors.Wrap(err, "initializing app"))
+		log.Print(errors.Wrap(err, "initializing app"))
+		os.Exit(ExitConfig)
 	}
-	if err := app.Run(context.Background()); err != nil {
-		log.Fatal(err)
+	err = app.Run(context.Background())
+	app.endRun(err)
+
+	if cerr := app.Close(); cerr != nil {
+		log.Print(cerr)
+	}
+
+	if len(app.ErrorsJSON) > 0 {
+		switch kind := errorKind(err); {
+		case err == nil:
+		case kind == FailureScrape || kind == FailureDownload || kind == FailureVerify:
+			// Already recorded with the url that failed.
+		case len(kind) == 0:
+			app.failures.Add(FailureOther, "", err)
+		default:
+			app.failures.Add(kind, "", err)
+		}
+		if werr := app.failures.WriteFile(app.ErrorsJSON); werr != nil {
+			log.Print(errors.Wrap(werr, "writing errors json"))
+		}
+	}
+	if err != nil {
+		log.Print(err)
+		os.Exit(ExitCode(err))
 	}
 }
 
 // App defines the application's behavior.
 type App struct {
 	Config
+
+	analyses     *Analyses
+	breaker      *Breaker
+	buffers      *BufferPool
+	claims       *Claims
+	client       *http.Client
+	destinations []Destination // Of -tee.
+	engine       Engine
+	existing     map[string]bool // Intact files found by -check-existing.
+	expected     *LinkCounts     // Baseline of -expected, nil if there is none.
+	exporters    []Exporter
+	failures     Failures
+	header       http.Header // Of -header.
+	limiter      *Limiter
+	mirrors      []Mirror
+	links        *LinkCounts
+	loginOnce    sync.Once
+	loginErr     error // Of the -login request.
+	run          *Run  // Recorded in the state database, nil if it isn't open.
+	scrapes      *ScrapeCache
+	state        *State              // Opened by commands that download files.
+	synced       map[string][]string // Paths of the files of each page, while syncing.
+	tags         *Tags
+	validators   *Validators
+	progress     *Progress
+	quota        *Quota
+	rates        *RateLimiter // Of -max-rps.
+	term         *Term
+	paused       *Gate // Closed by SIGUSR1, see watchPause.
+	window       *Gate // Open during the -active-hours.
 }
 
 // NewApp initializes the application.
 func NewApp(conf Config) (*App, error) {
+	client, err := NewClient(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating http client")
+	}
+	engine, err := NewEngine(conf)
+	if err != nil {
+		return nil, err
+	}
+	exporters, err := NewExporters(conf, engine)
+	if err != nil {
+		return nil, err
+	}
+	links, err := LoadLinkCounts(LinkCountsFile)
+	if err != nil {
+		return nil, err
+	}
+	scrapes, err := LoadScrapeCache(ScrapeCacheFile)
+	if err != nil {
+		return nil, err
+	}
+	validators, err := LoadValidators(ValidatorsFile)
+	if err != nil {
+		return nil, err
+	}
+	analyses, err := LoadAnalyses(AnalysisFile)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := LoadTags(TagsFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tags.Collections[conf.Collection]; len(conf.Collection) > 0 && !ok {
+		return nil, withKind(FailureConfig, errors.New("there is no collection "+conf.Collection+" in "+TagsFile))
+	}
+	destinations, err := NewDestinations(conf)
+	if err != nil {
+		return nil, err
+	}
+	mirrors, err := ParseMirrors(conf.Mirrors)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing mirror")
+	}
+	header, err := ParseHeaders(conf.Headers)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing header")
+	}
+	var expected *LinkCounts
+	if len(conf.Expected) > 0 {
+		if expected, err = LoadLinkCounts(conf.Expected); err != nil {
+			return nil, err
+		}
+	}
 	app := &App{
-		Config: conf,
+		Config:       conf,
+		analyses:     analyses,
+		breaker:      NewBreaker(conf.BreakerThreshold, conf.BreakerCooldown, conf.RetryBudget),
+		buffers:      NewBufferPool(conf.BufferSize),
+		claims:       NewClaims(conf.OnConflict),
+		client:       client,
+		destinations: destinations,
+		engine:       engine,
+		expected:     expected,
+		exporters:    exporters,
+		header:       header,
+		limiter:      NewLimiter(conf.Jobs),
+		mirrors:      mirrors,
+		links:        links,
+		scrapes:      scrapes,
+		tags:         tags,
+		validators:   validators,
+		progress:     NewProgress(),
+		quota:        NewQuota(conf.MaxFiles, conf.MaxBytes),
+		rates:        NewRateLimiter(conf.MaxRPS),
+		paused:       NewGate(),
+		window:       NewGate(),
+		term:         NewTerm(os.Stderr, conf.NoColor),
+	}
+	if conf.TUI {
+		// The dashboard owns the screen.
+		app.term = NewTerm(ioutil.Discard, true)
 	}
 	return app, nil
 }
 
+// Close closes the state database and finishes the cassette being
+// recorded, if any.
+func (app *App) Close() error {
+	if app.state != nil {
+		if err := app.state.Close(); err != nil {
+			return err
+		}
+	}
+	if rec, ok := app.client.Transport.(*Recorder); ok {
+		return rec.Close()
+	}
+	return nil
+}
+
+// commandActions are the subcommands that take an action, e.g. site build.
+var commandActions = map[string]bool{"collection": true, "pack": true, "site": true, "tag": true}
+
 // Run runs the application.
 func (app *App) Run(ctx context.Context) error {
+	switch app.Command {
+	case "":
+	case "analyze":
+		return app.analyze(ctx)
+	case "bundle":
+		return app.bundle(ctx)
+	case "cat":
+		return app.cat(ctx)
+	case "catalog":
+		return app.catalog(ctx)
+	case "channels":
+		return app.channels(ctx)
+	case "collection":
+		return app.collection(ctx)
+	case "convert":
+		return app.convert(ctx)
+	case "download":
+		return app.downloadArgs(ctx)
+	case "duplicates":
+		return app.duplicates(ctx)
+	case "fixtures":
+		return app.fixtures(ctx)
+	case "history":
+		return app.history(ctx)
+	case "identify":
+		return app.identify(ctx)
+	case "instruments":
+		return app.instruments(ctx)
+	case "layers":
+		return app.layers(ctx)
+	case "loudness":
+		return app.loudness(ctx)
+	case "metadata":
+		return app.metadata()
+	case "pack":
+		return app.pack(ctx)
+	case "pick":
+		return app.pick(ctx)
+	case "publish":
+		return app.publish(ctx)
+	case "schema":
+		return app.schema()
+	case "range":
+		return app.ranges(ctx)
+	case "search":
+		return app.search(ctx)
+	case "sections":
+		return app.sections(ctx)
+	case "show":
+		return app.show(ctx)
+	case "site":
+		return app.site(ctx)
+	case "sync":
+		return app.syncTree(ctx)
+	case "tag":
+		return app.tag(ctx)
+	case "verify":
+		return app.verify(ctx)
+	default:
+		return withKind(FailureConfig, errors.New("unknown command: "+app.Command))
+	}
 	if app.Download {
 		return app.download(ctx)
 	} else if app.Validate {
@@ -57,91 +275,523 @@ func (app *App) Run(ctx context.Context) error {
 	return app.list(ctx)
 }
 
-func (app *App) contentFetcher(ctx context.Context, download string, dc chan Download) func() error {
+func (app *App) contentFetcher(ctx context.Context, page Page, download string, dc chan Download, files *fileList, t *turn) func() error {
 	return func() error {
+		defer t.Pass()
+
 		if _, err := stdurl.Parse(download); err != nil {
-			log.Printf("invalid url: %s", download)
+			app.term.Status(StatusSkip, "invalid url: %s", download)
 			return nil
 		}
-		resp, err := http.Get(download)
+		local, err := LocalPath(download)
 		if err != nil {
-			panic(err)
+			app.fail(download, err)
+			return nil
 		}
-		if resp.StatusCode >= http.StatusMultipleChoices {
-			return errors.New(download + ": " + resp.Status)
+		sample := ParseSample(local)
+
+		name, part, err := app.claims.Claim(NormalizeName(app.Layout.Path(page, local, sample), app.Names), download)
+		if err != nil {
+			app.fail(download, err)
+			return nil
+		}
+		if len(name) == 0 {
+			app.progress.Skip(download)
+			app.term.Status(StatusSkip, "%s: already downloaded to the same path", download)
+			return nil
+		}
+		if app.existing[name] {
+			app.progress.Skip(download)
+			app.term.Status(StatusSkip, "%s: already downloaded", name)
+			app.recordDone(download, name, &sample, nil)
+			files.add(File{URL: download, Path: name, Sample: sample})
+			return nil
+		}
+		if err := t.Wait(ctx); err != nil {
+			return nil
+		}
+		if err := app.acquire(ctx); err != nil {
+			return nil
+		}
+		t.Pass()
+
+		app.record(download, func(fs *FileState) {
+			fs.Path, fs.Status = name, FileDownloading
+			fs.Attempts++
+			fs.Started = time.Now().UTC()
+		})
+		var (
+			offset int64
+			resp   *http.Response
+		)
+		if part == name+partSuffix { // Not another download of the same path's, see Claims.
+			offset = app.partialSize(name)
+		}
+		if offset > 0 {
+			resp, err = app.getFrom(ctx, download, offset)
+			if err != nil {
+				// The partial file may be stale, start again.
+				offset = 0
+			}
+		}
+		if offset == 0 {
+			resp, err = app.getWith(ctx, download, app.condition(name).Header())
+		}
+		if err != nil {
+			app.limiter.Release()
+			app.fail(download, err)
+			return nil
+		}
+		if resp.StatusCode == http.StatusNotModified {
+			_ = resp.Body.Close() // Best effort.
+			app.limiter.Release()
+			app.progress.Skip(download)
+			app.term.Status(StatusSkip, "%s: not modified", name)
+			app.recordDone(download, name, &sample, nil)
+			files.add(File{URL: download, Path: name, Sample: sample})
+			return nil
+		}
+		if !app.quota.Take(resp.ContentLength) {
+			_ = resp.Body.Close() // Best effort.
+			app.limiter.Release()
+			app.progress.Skip(download)
+			return nil
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			offset = 0
+		}
+		dl := Download{
+			Content:   resp.Body,
+			Location:  download,
+			Size:      resp.ContentLength,
+			File:      File{URL: download, Path: name, Sample: sample},
+			Part:      part,
+			Offset:    offset,
+			Validator: validatorOf(resp),
 		}
 		select {
 		case <-ctx.Done():
+			app.limiter.Release()
+			_ = resp.Body.Close() // Best effort.
 			return nil
-		case dc <- Download{Content: resp.Body, Location: download}:
+		case dc <- dl:
 		}
 		return nil
 	}
 }
 
-func (app *App) contentWriter(ctx context.Context, dc chan Download) func() error {
+// convertDownload runs the processing pipeline over a download as it
+// arrives, without writing the original file.
+func (app *App) convertDownload(download Download, files *fileList) {
+	var (
+		f = download.File
+		t = app.progress.Start(download.Location, download.Size)
+	)
+
+	a, err := Decode(bufio.NewReader(t.Reader(download.Content)))
+	if err != nil {
+		app.fail(download.Location, errors.Wrap(err, "decoding"))
+		return
+	}
+	out, err := app.Pipeline.ProcessAudio(a, f)
+	if err != nil {
+		app.fail(download.Location, errors.Wrap(err, "processing"))
+		return
+	}
+	app.progress.Finish(t)
+	app.term.Status(StatusOK, "%s", out)
+
+	f.Path = filepath.ToSlash(out)
+	files.add(f)
+}
+
+func (app *App) contentWriter(ctx context.Context, dc chan Download, files *fileList) func() error {
 	return func() error {
 		select {
 		case <-ctx.Done():
 			return nil
-		case download := <-dc:
+		case download, ok := <-dc:
+			if !ok {
+				return nil
+			}
 			defer func() { _ = download.Content.Close() }() // Best effort.
+			defer app.limiter.Release()
 
-			u, err := stdurl.Parse(download.Location)
-			if err != nil {
-				return errors.Wrap(err, "parsing url")
-			}
-			if err := os.MkdirAll(path.Dir(u.Path[1:]), os.ModePerm); err != nil {
-				return errors.Wrap(err, "making directory")
+			if app.Stream {
+				app.convertDownload(download, files)
+				return nil
 			}
-			f, err := os.Create(u.Path[1:])
-			if err != nil {
-				return errors.Wrap(err, "creating file")
+			var (
+				name = download.File.Path
+				part = download.Part
+			)
+			if err := app.mkdirAll(path.Dir(name)); err != nil {
+				return err
 			}
-			defer func() { _ = f.Close() }() // Best effort.
+			var (
+				t   *Transfer
+				ups uploads
+			)
+			for attempt := 0; ; attempt++ {
+				mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+				if download.Offset > 0 {
+					mode = os.O_WRONLY | os.O_APPEND
+				}
+				h, err := app.hashPart(part, download.Offset)
+				if err != nil {
+					app.fail(download.Location, err)
+					return nil
+				}
+				if ups, err = app.createUploads(ctx, name, part, download.Offset); err != nil {
+					app.fail(download.Location, err)
+					return nil
+				}
+				f, err := os.OpenFile(part, mode, 0644)
+				if err != nil {
+					ups.Abort()
+					return errors.Wrap(err, "creating file")
+				}
+				t = app.progress.Start(download.Location, download.Size)
+
+				content := gateReader{ctx: ctx, gates: []*Gate{app.window, app.paused}, r: download.Content}
+				err = app.writePart(f, ups.Writer(), t, io.TeeReader(content, h))
+				if err == nil {
+					download.File.Checksums = h.Sum()
+					break
+				}
+				ups.Abort()
+
+				u, _ := stdurl.Parse(download.Location) // Parsed by the fetcher.
+				if _, ok := err.(corruptError); !ok || attempt >= app.ValidationRetries || !app.breaker.Retry(u.Host) {
+					app.fail(download.Location, err)
+					return nil
+				}
+				app.term.Status(StatusSkip, "%s: %s, downloading it again", download.Location, err)
 
-			if _, err := io.Copy(f, download.Content); err != nil {
-				return errors.Wrap(err, "writing file")
+				resp, err := app.get(ctx, download.Location)
+				if err != nil {
+					app.fail(download.Location, err)
+					return nil
+				}
+				_ = download.Content.Close() // Best effort.
+				download.Content, download.Size, download.Offset = resp.Body, resp.ContentLength, 0
+				download.Validator = validatorOf(resp)
 			}
+			if err := ups.Commit(); err != nil {
+				app.fail(download.Location, err)
+				return nil
+			}
+			if err := os.Rename(part, name); err != nil {
+				app.fail(download.Location, errors.Wrap(err, "renaming partial file"))
+				return nil
+			}
+			if err := app.own(name, app.FileMode); err != nil {
+				app.fail(download.Location, err)
+				return nil
+			}
+			if app.Fsync {
+				if err := syncDir(path.Dir(name)); err != nil {
+					app.fail(download.Location, err)
+					return nil
+				}
+			}
+			app.progress.Finish(t)
+			app.term.Status(StatusOK, "%s", name)
+
+			app.validators.Put(name, download.Validator)
+			app.recordDone(download.Location, name, &download.File.Sample, download.File.Checksums)
+			files.add(download.File)
 		}
 		return nil
 	}
 }
 
+// writePart copies the content of a download to its partial file f, and
+// to tee if it isn't nil, closing f, and validates the result. The partial
+// file is kept if the copy fails, to be resumed, and removed if it is
+// corrupt.
+func (app *App) writePart(f *os.File, tee io.Writer, t *Transfer, content io.Reader) error {
+	var w io.Writer = f
+	if tee != nil {
+		w = io.MultiWriter(f, tee)
+	}
+	if _, err := app.buffers.Copy(w, t.Reader(content)); err != nil {
+		_ = f.Close() // Best effort.
+		return errors.Wrap(err, "writing file")
+	}
+	if app.Fsync {
+		if err := f.Sync(); err != nil {
+			_ = f.Close() // Best effort.
+			return errors.Wrap(err, "syncing file")
+		}
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "writing file")
+	}
+	if err := ValidateAudioFile(f.Name()); err != nil {
+		_ = os.Remove(f.Name()) // Best effort.
+		return corruptError{err}
+	}
+	return nil
+}
+
 func (app *App) download(ctx context.Context) error {
-	urls, err := app.urls()
+	if len(app.Manifest) > 0 {
+		return app.downloadManifest(ctx)
+	}
+	pages, err := app.pages()
 	if err != nil {
 		return errors.Wrap(err, "getting urls")
 	}
-	for _, url := range urls {
+	return app.downloadPages(ctx, pages)
+}
+
+// downloadArgs runs the download subcommand: "iowa download [page-url...]"
+// downloads the instrument pages given, whether they are in the catalog
+// or not, or the pages selected by -e and -s if there are none.
+func (app *App) downloadArgs(ctx context.Context) error {
+	if len(app.Args) == 0 {
+		return app.download(ctx)
+	}
+	if len(app.Manifest) > 0 {
+		return withKind(FailureConfig, errors.New("page URLs can't be used with -manifest"))
+	}
+	catalog := map[string]Page{}
+	for _, page := range app.Pages() {
+		catalog[page.URL] = page
+	}
+	pages := make([]Page, len(app.Args))
+
+	for i, arg := range app.Args {
+		u, err := stdurl.Parse(arg)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return withKind(FailureConfig, errors.New("not an instrument page URL: "+arg))
+		}
+		page, ok := catalog[arg]
+		if !ok {
+			page = Page{Instrument: InstrumentName(arg), URL: arg}
+		}
+		pages[i] = page
+	}
+	return app.downloadPages(ctx, pages)
+}
+
+// downloadPages downloads the audio files linked from each of the provided instrument pages.
+func (app *App) downloadPages(ctx context.Context, pages []Page) error {
+	if app.TUI {
+		pctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		defer func() { cancel(); <-done }()
+		go func() {
+			NewDashboard(app.progress, os.Stderr, app.NoColor).Run(pctx, time.Second)
+			close(done)
+		}()
+	} else if app.ProgressInterval > 0 {
+		pctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go app.progress.Report(pctx, os.Stderr, app.ProgressInterval)
+	}
+	if app.Jobs == JobsAuto {
+		tctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go NewTuner(app.limiter, app.progress).Run(tctx, 2*time.Second)
+	}
+	if len(app.ActiveHours) > 0 {
+		sctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		app.schedule(sctx)
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go app.watchPause(wctx)
+
+	if err := app.openState(); err != nil {
+		return err
+	}
+	if err := app.cleanPartials("."); err != nil {
+		return err
+	}
+	if app.CheckExisting {
+		existing, err := app.checkExisting(".")
+		if err != nil {
+			return err
+		}
+		app.existing = existing
+	}
+	if app.Preflight {
+		if err := app.preflight(ctx, pages); err != nil {
+			return err
+		}
+	}
+	var all []File
+	for _, page := range pages {
+		if app.quota.Reached() {
+			app.term.Status(StatusWarn, "download limit reached (-max-files or -max-bytes), skipping the remaining pages")
+			break
+		}
 		// Get the URL's of the actual audio files.
-		downloads, err := app.scrape(ctx, url)
+		downloads, err := app.scrape(ctx, page.URL)
 		if err != nil {
-			return errors.Wrap(err, "scraping audio file URL's")
+			app.failures.Add(FailureScrape, page.URL, err)
+			return withKind(FailureScrape, errors.Wrap(err, "scraping audio file URL's"))
 		}
+		downloads = app.orderDownloads(ctx, app.applyPreset(downloads))
+		app.progress.Add(downloads...)
+
 		// Run the downloads in parallel.
-		if err := app.fetch(ctx, downloads); err != nil {
+		files, err := app.fetch(ctx, page, downloads)
+		if err != nil {
 			return errors.Wrap(err, "fetching audio files")
 		}
+		if err := app.validators.Save(); err != nil {
+			return err
+		}
+		if app.synced != nil {
+			paths := make([]string, len(files))
+			for i, f := range files {
+				paths[i] = f.Path
+			}
+			app.synced[page.URL] = paths
+		}
+		app.process(ctx, files)
+		all = append(all, files...)
+
+		if err := app.export(Instrument{Page: page, Files: files}); err != nil {
+			return errors.Wrap(err, "exporting "+page.Instrument)
+		}
+	}
+	if n := app.failures.Count(FailureDownload); n > 0 {
+		return withKind(FailureDownload, errors.Errorf("%d files could not be downloaded", n))
+	}
+	if n := app.failures.Count(FailureProcess); n > 0 {
+		return withKind(FailureProcess, errors.Errorf("%d files could not be processed", n))
+	}
+	if len(app.Snapshot) > 0 {
+		name, err := app.writeSnapshot(pages, all)
+		if err != nil {
+			return err
+		}
+		app.term.Status(StatusOK, "snapshot of %d files written to %s", len(all), name)
+
+		if app.Sign {
+			sig, err := Sign(ctx, name, app.SignKey)
+			if err != nil {
+				return err
+			}
+			app.term.Status(StatusOK, "signed %s in %s", name, sig)
+		}
 	}
 	return nil
 }
 
-func (app *App) fetch(ctx context.Context, downloads []string) error {
+// process runs the external pipeline and the processing pipeline over
+// downloaded files, unless the latter already ran while streaming or is
+// being exported as a sox script instead, and renders any blends between
+// their dynamics. With -match-gain the files are measured first.
+func (app *App) process(ctx context.Context, files []File) {
+	pipeline := app.Pipeline
+	if pipeline.MatchGain {
+		gains, err := pipeline.MatchGains(app.engine, files)
+		if err != nil {
+			app.term.Status(StatusFail, "matching gain: %s", err)
+			app.failures.Add(FailureProcess, "", err)
+		}
+		for _, f := range files {
+			if g, ok := gains[f.Path]; ok && g.Limited {
+				app.term.Status(StatusWarn, "%s: gain limited to %+.1f dB of the %+.1f dB to match, as it would clip", f.Path, g.Gain, g.Target-g.Measured)
+			}
+		}
+		pipeline = pipeline.WithGains(gains)
+	}
+	if pipeline.Blend > 0 {
+		blends, err := pipeline.BlendFiles(app.engine, files)
+		for _, out := range blends {
+			app.term.Status(StatusOK, "%s", out)
+		}
+		if err != nil {
+			app.term.Status(StatusFail, "%s", err)
+			app.failures.Add(FailureProcess, "", err)
+		}
+	}
+	if len(app.External.Steps) > 0 {
+		app.External.Run(ctx, files, app.Pipeline.Dir, func(f File, out string, err error) {
+			if err != nil {
+				app.term.Status(StatusFail, "processing %s: %s", f.Path, err)
+				app.failures.Add(FailureProcess, f.URL, err)
+				return
+			}
+			app.term.Status(StatusOK, "%s", out)
+		})
+	}
+	if !pipeline.Enabled() || app.Stream || contains(app.Export, "sox") {
+		return
+	}
+	for _, f := range files {
+		out, err := pipeline.ProcessFile(app.engine, f)
+		if err != nil {
+			app.term.Status(StatusFail, "processing %s: %s", f.Path, err)
+			app.failures.Add(FailureProcess, f.URL, err)
+			continue
+		}
+		app.term.Status(StatusOK, "%s", out)
+	}
+}
+
+// fail records a file that could not be downloaded.
+func (app *App) fail(location string, err error) {
+	app.term.Status(StatusFail, "%s: %s", location, err)
+	app.progress.Fail(location, err)
+	app.failures.Add(FailureDownload, location, err)
+	app.record(location, func(fs *FileState) {
+		fs.Status, fs.Error = FileFailed, err.Error()
+	})
+}
+
+// export runs the configured exporters for an instrument.
+func (app *App) export(inst Instrument) error {
+	if len(inst.Files) == 0 {
+		return nil
+	}
+	for _, exporter := range app.exporters {
+		if err := exporter.Export(inst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetch downloads the files of an instrument page in parallel and returns
+// the ones that were written.
+func (app *App) fetch(ctx context.Context, page Page, downloads []string) ([]File, error) {
 	var (
-		dc      = make(chan Download)
-		g, gctx = errgroup.WithContext(ctx)
+		files    fileList
+		dc       = make(chan Download)
+		g, gctx  = errgroup.WithContext(ctx)
+		fetchers sync.WaitGroup
 	)
-	defer close(dc)
+	turns := newTurns(len(downloads))
 
-	for _, dl := range downloads {
+	for i, dl := range downloads {
 		// Spawn goroutines that will fetch each file.
-		g.Go(app.contentFetcher(gctx, dl, dc))
-
+		fetch := app.contentFetcher(gctx, page, dl, dc, &files, turns[i])
+		fetchers.Add(1)
+		g.Go(func() error {
+			defer fetchers.Done()
+			return fetch()
+		})
 		// Spawn goroutines that will write the data to local disk.
-		g.Go(app.contentWriter(gctx, dc))
+		g.Go(app.contentWriter(gctx, dc, &files))
 	}
-	return g.Wait()
+	// Fetchers that fail don't send anything, so the writers that are
+	// left over need to know when there is nothing else coming.
+	go func() {
+		fetchers.Wait()
+		close(dc)
+	}()
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return files.sorted(), nil
 }
 
 func (app *App) list(ctx context.Context) error {
@@ -149,39 +799,103 @@ func (app *App) list(ctx context.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "getting urls")
 	}
-	return json.NewEncoder(os.Stdout).Encode(urls)
+	if len(app.Format) == 0 || app.Format == FormatJSON {
+		return json.NewEncoder(os.Stdout).Encode(urls)
+	}
+	rows := make([][]string, len(urls))
+	for i, url := range urls {
+		rows[i] = []string{url}
+	}
+	return app.printList(os.Stdout, urls, []string{"URL"}, rows)
 }
 
+// scrape returns the audio links of an instrument page. They come from
+// the scrape cache if they were cached within -cache-ttl, with -offline,
+// or if the server can't be reached.
 func (app *App) scrape(ctx context.Context, url string) ([]string, error) {
+	if app.Offline {
+		return app.cachedLinks(url)
+	}
+	if app.CacheTTL > 0 && !app.Refresh {
+		if links, ok := app.scrapes.Fresh(url, app.Extensions, app.CacheTTL); ok {
+			return links, nil
+		}
+	}
+	links, err := app.scrapePage(ctx, url)
+	if err != nil && isNetworkError(err) {
+		if cached, cerr := app.cachedLinks(url); cerr == nil {
+			return cached, nil
+		}
+	}
+	return links, err
+}
+
+// scrapePage scrapes the audio links of an instrument page and caches them.
+func (app *App) scrapePage(ctx context.Context, url string) ([]string, error) {
 	u, err := stdurl.Parse(url)
 	if err != nil {
 		return nil, errors.Wrap(err, "parsing url")
 	}
-	resp, err := http.Get(url)
+	resp, err := app.get(ctx, url)
 	if err != nil {
 		return nil, errors.Wrap(err, "fetching "+url)
 	}
 	defer func() { _ = resp.Body.Close() }() // Best effort.
 
+	if to := resp.Request.URL; app.Strict && to.String() != u.String() {
+		return nil, errors.Errorf("%s redirected to %s", url, to)
+	}
 	root, err := html.Parse(resp.Body)
 	if err != nil {
 		return nil, errors.Wrap(err, "parsing html")
 	}
+	links := AudioLinks(u, root, app.Extensions)
+
+	if app.Strict {
+		if err := app.links.Check(url, len(links)); err != nil {
+			return nil, errors.Wrap(err, url)
+		}
+	}
+	if err := app.links.Record(url, len(links)); err != nil {
+		return nil, err
+	}
+	if app.expected != nil && app.UpdateExpected {
+		if err := app.expected.Record(url, len(links)); err != nil {
+			return nil, err
+		}
+	} else if app.expected != nil {
+		if err := app.expected.Expect(url, len(links)); err != nil {
+			if app.Strict {
+				return nil, errors.Wrap(err, url)
+			}
+			app.term.Status(StatusWarn, "%s: %s", url, err)
+		}
+	}
+	if err := app.scrapes.Put(url, app.Extensions, links); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// AudioLinks returns the links of a page to files with one of the
+// extensions, resolved against the page's URL, without duplicates.
+func AudioLinks(page *stdurl.URL, root *html.Node, exts []string) []string {
 	var (
 		dm    = map[string]struct{}{}
 		links = scrape.FindAll(root, scrape.ByTag(atom.A))
 	)
 	for _, link := range links {
 		for _, attr := range link.Attr {
-			if attr.Key != "href" || !IsAudioFile(attr.Val) {
+			if attr.Key != "href" {
 				continue
 			}
-			val := attr.Val
-
-			if strings.HasPrefix(attr.Val, "../") {
-				val = attr.Val[3:]
+			ref, err := parseHref(attr.Val)
+			if err != nil || !IsAudioFile(ref.Path, exts) {
+				continue
 			}
-			dm["http://"+u.Host+"/"+val] = struct{}{}
+			u := page.ResolveReference(ref)
+			u.Fragment = ""
+			dm[u.String()] = struct{}{}
 		}
 	}
 	var downloads []string
@@ -189,34 +903,45 @@ func (app *App) scrape(ctx context.Context, url string) ([]string, error) {
 	for u := range dm {
 		downloads = append(downloads, u)
 	}
-	return downloads, nil
+	sort.Strings(downloads)
+	return downloads
 }
 
 func (app *App) urls() ([]string, error) {
-	var out []string
+	pages, err := app.pages()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(pages))
+	for i, page := range pages {
+		out[i] = page.URL
+	}
+	return out, nil
+}
 
+// pages returns the instrument pages selected by the era and section flags.
+func (app *App) pages() ([]Page, error) {
 	if app.Era != "all" {
-		sections, ok := app.Samples[app.Era]
-		if !ok {
-			return nil, errors.New("unsupported era: " + app.Era)
+		if _, ok := app.Samples[app.Era]; !ok {
+			return nil, withKind(FailureConfig, errors.New("unsupported era: "+app.Era))
 		}
-		if len(app.Section) > 0 {
-			urls, ok := sections[app.Section]
-			if !ok {
-				return nil, errors.New("unsupported section: " + app.Section)
-			}
-			out = append(out, urls...)
-		} else {
-			for _, urls := range sections {
-				out = append(out, urls...)
-			}
+	}
+	if len(app.Section) > 0 && !app.hasSection(app.Era, app.Section) {
+		return nil, withKind(FailureConfig, errors.New("unsupported section: "+app.Section))
+	}
+	var out []Page
+
+	for _, page := range app.Pages() {
+		if app.Era != "all" && page.Era != app.Era {
+			continue
 		}
-	} else {
-		for _, sections := range app.Samples {
-			for _, urls := range sections {
-				out = append(out, urls...)
-			}
+		if len(app.Section) > 0 && !SectionMatches(page.Section, app.Section) {
+			continue
+		}
+		if app.excluded(page.Section) {
+			continue
 		}
+		out = append(out, page)
 	}
 	return out, nil
 }
@@ -230,29 +955,317 @@ func (app *App) validate(ctx context.Context) error {
 		// Get the URL's of the actual audio files.
 		downloads, err := app.scrape(ctx, url)
 		if err != nil {
-			return errors.Wrap(err, "scraping audio file URL's")
+			app.failures.Add(FailureScrape, url, err)
+			return withKind(FailureScrape, errors.Wrap(err, "scraping audio file URL's"))
 		}
 		for _, dl := range downloads {
 			if _, err := stdurl.Parse(dl); err != nil {
-				log.Printf("download url '%s' is invalid", dl)
+				app.term.Status(StatusFail, "download url '%s' is invalid", dl)
+				app.failures.Add(FailureVerify, dl, err)
+				continue
 			}
+			if app.Format == FormatPlain {
+				_, _ = os.Stdout.WriteString(dl + "\n")
+				continue
+			}
+			app.term.Status(StatusOK, "%s", dl)
 		}
 	}
+	if n := app.failures.Count(FailureVerify); n > 0 {
+		return withKind(FailureVerify, errors.Errorf("%d download urls are invalid", n))
+	}
 	return nil
 }
 
 // Config defines the application's configuration.
 type Config struct {
+	// ActiveHours are the daily windows that downloads transfer in, any
+	// time if there are none.
+	ActiveHours []Window `json:"active_hours"`
+
+	// BreakerThreshold is the number of consecutive failed requests to a
+	// host that trips its circuit breaker. Zero disables the breaker.
+	BreakerThreshold int `json:"breaker_threshold"`
+
+	// BreakerCooldown is how long a tripped circuit breaker stays open.
+	BreakerCooldown time.Duration `json:"breaker_cooldown"`
+
+	// BundleDir is the directory the bundle command writes BundleName.zip,
+	// its manifest and checksums to.
+	BundleDir  string `json:"bundle_dir"`
+	BundleName string `json:"bundle_name"`
+
+	// BufferSize is the size in bytes of the buffers downloads are copied with.
+	BufferSize int `json:"buffer_size"`
+
+	// CACert is a PEM file of extra certificate authorities to trust.
+	CACert string `json:"cacert"`
+
+	// CacheTTL is how long the links of an instrument page in the scrape
+	// cache are used instead of scraping it again. Zero always scrapes.
+	CacheTTL time.Duration `json:"cache_ttl"`
+
+	// Cert and Key are a PEM client certificate and its private key.
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+
+	// CheckExisting checks the files already in the output directory before
+	// downloading, so that intact ones are kept and broken ones replaced.
+	CheckExisting bool `json:"check_existing"`
+
+	// Cookies keeps the cookies servers set, e.g. for mirrors behind
+	// session auth. It is implied by Login.
+	Cookies bool `json:"cookies"`
+
+	// Collection limits downloads and conversions to the files of a
+	// collection, see Tags.
+	Collection string `json:"collection"`
+
+	// Command is the subcommand (e.g. pick), if one was given.
+	Command string `json:"command"`
+
+	// Action is what a subcommand that does several things does, e.g. the
+	// build of site build.
+	Action string `json:"action,omitempty"`
+
+	// DetectPitch has the range command also detect the pitch of files.
+	DetectPitch bool `json:"detect_pitch"`
+
+	// Delete removes files that pages no longer link to when syncing.
+	Delete bool `json:"delete"`
+
+	// Args are the positional arguments following the flags.
+	Args []string `json:"args"`
+
 	Download bool   `json:"download"`
 	Era      string `json:"era"`
-	Section  string `json:"section"`
+
+	// ExcludeSections are sections to leave out, e.g. of a whole era.
+	ExcludeSections []string `json:"exclude_sections"`
+
+	// External is run over every downloaded file, see -pipeline.
+	External ExternalPipeline `json:"external"`
+
+	// Expected is a JSON file of the number of audio links each instrument
+	// page is expected to have, by URL.
+	Expected string `json:"expected"`
+
+	// Extensions are the extensions of the audio files to download, e.g. .aif.
+	Extensions []string `json:"extensions"`
+
+	// Fill is how exported instruments map unsampled notes to samples.
+	Fill Fill `json:"fill"`
+
+	// GitHub is the repository, owner/repo, that publish uploads to the
+	// release of ReleaseTag of.
+	GitHub     string `json:"github"`
+	ReleaseTag string `json:"release_tag"`
+
+	// Fuzzy has the search command match misspelled terms too.
+	Fuzzy bool `json:"fuzzy"`
+
+	// Engine decodes downloaded files for processing (auto, native or ffmpeg).
+	Engine string `json:"engine"`
+
+	// IfChanged downloads files that were already downloaded only if the
+	// server says they have changed, see Validators.
+	IfChanged bool `json:"if_changed"`
+
+	// FileMode and DirMode are the permissions of downloaded files and the
+	// directories created for them, zero for the defaults less the umask.
+	FileMode os.FileMode `json:"mode"`
+	DirMode  os.FileMode `json:"dir_mode"`
+
+	// Format is the output format of the commands that list things
+	// (table, json, plain or parquet), empty for the command's default.
+	Format string `json:"format"`
+
+	// Fsync flushes every downloaded file and its directory to disk.
+	Fsync bool `json:"fsync"`
+
+	// Headers are "Name: value" headers added to every request.
+	Headers []string `json:"headers"`
+
+	// Insecure skips verification of server certificates.
+	Insecure bool `json:"insecure"`
+
+	// IPVersion restricts connections to IPv4 or IPv6, e.g. on networks
+	// with broken IPv6 routes, see IPAuto.
+	IPVersion string `json:"ip_version"`
+
+	// Jobs is the maximum number of concurrent transfers.
+	// Zero is unlimited and JobsAuto tunes it based on throughput.
+	Jobs int `json:"jobs"`
+
+	// Export lists the formats (e.g. scd) to export each downloaded instrument to.
+	Export []string `json:"export"`
+
+	// ErrorsJSON is a file that every failure is written to as JSON.
+	ErrorsJSON string `json:"errors_json"`
+
+	// LayerMargin is how many LU louder than the dynamic below it the layers
+	// command expects each dynamic of a note to be.
+	LayerMargin float64 `json:"layer_margin"`
+
+	// Layout is how downloaded files are arranged, see Layout.
+	Layout Layout `json:"layout"`
+
+	// Live has the instruments command scrape every page to count its files.
+	Live bool `json:"live"`
+
+	// Login is a URL to request before anything else, e.g. to start a
+	// session, POSTing LoginData if it is set.
+	Login     string `json:"login"`
+	LoginData string `json:"login_data"`
+
+	// LoudnessTolerance is how many LU from the median of their dynamic of
+	// their instrument the loudness command reports files as outliers at.
+	LoudnessTolerance float64 `json:"loudness_tolerance"`
+
+	// Manifest is a manifest of the files to download instead of scraping
+	// instrument pages, see Manifest.
+	Manifest string `json:"manifest"`
+
+	// MaxFiles and MaxBytes stop a run from starting downloads once that
+	// many files or bytes have been downloaded. Zero is unlimited.
+	MaxFiles int   `json:"max_files"`
+	MaxBytes int64 `json:"max_bytes"`
+
+	// MaxRPS is the most requests a second made to each host, however
+	// many transfers run at once. Zero is unlimited.
+	MaxRPS float64 `json:"max_rps"`
+
+	// MD5 also records the MD5 checksum of every file in manifests.
+	MD5 bool `json:"md5"`
+
+	// Mirrors are base=mirror pairs of URL's, e.g. an institutional copy of
+	// the site, that files are fetched from when the base's host fails.
+	Mirrors []string `json:"mirrors"`
+
+	// Names is the Unicode normalization form of the names of downloaded
+	// files (nfc, nfd or none).
+	Names string `json:"names"`
+
+	NoColor bool `json:"no_color"`
+
+	// Offline uses the links of instrument pages in the scrape cache
+	// instead of scraping them.
+	Offline bool `json:"offline"`
+
+	// OnConflict is what to do when two downloads map to the same path.
+	OnConflict Conflict `json:"on_conflict"`
+
+	Section string `json:"section"`
+
+	// Sign signs snapshots with gpg, with SignKey or the default key.
+	Sign    bool   `json:"sign"`
+	SignKey string `json:"sign_key"`
+
+	// Similarity is how alike the fingerprints of files must be for the
+	// duplicates and identify commands to match them, see Similarity.
+	Similarity float64 `json:"similarity"`
+
+	// Snapshot is a directory to write a dated manifest of the files that
+	// were downloaded to, see Manifest.
+	Snapshot string `json:"snapshot"`
+
+	// SiteDir is the directory site build renders the static site to.
+	SiteDir string `json:"site_dir"`
+
+	// Order is the order the files of each page are downloaded in, e.g.
+	// OrderSmallest.
+	Order string `json:"order"`
+
+	// Partial is what to do with the partial files of crashed runs
+	// (resume or remove).
+	Partial string `json:"partial"`
+
+	// Preflight estimates the size and duration of a download before
+	// starting it.
+	Preflight bool `json:"preflight"`
+
+	// PackDir is the directory pack merge merges packs into.
+	PackDir string `json:"pack_dir"`
+
+	// Preset selects a subset of the files of each page, e.g.
+	// PresetOneDynamic.
+	Preset string `json:"preset"`
+
+	// Pipeline is the processing applied to downloaded files.
+	Pipeline Pipeline `json:"pipeline"`
+
+	// ProgressInterval is how often the speed and ETA of the active
+	// transfers is printed while downloading. Zero disables it.
+	ProgressInterval time.Duration `json:"progress_interval"`
+
+	// Record is a cassette (a tar file) to record all HTTP traffic in, and
+	// Replay one to replay it from instead of using the network.
+	Record string `json:"record"`
+	Replay string `json:"replay"`
+
+	// Refresh scrapes every instrument page, ignoring CacheTTL.
+	Refresh bool `json:"refresh"`
+
+	// Reindex rebuilds the search index rather than searching the one
+	// built the first time the search command ran.
+	Reindex bool `json:"reindex"`
+
+	// Repair downloads files that fail verification again.
+	Repair bool `json:"repair"`
+
+	// Resolve pins host:port pairs to addresses, curl-style (host:port:addr).
+	Resolve []string `json:"resolve"`
+
+	// Retries is the number of times a failed request is retried.
+	Retries int `json:"retries"`
+
+	// RetryBudget is the number of retries allowed per host for the whole
+	// run. Negative means unlimited.
+	RetryBudget int `json:"retry_budget"`
 
 	// Samples is a map from "era" (i.e. pre-2012, post-2012) to "section"
 	// (e.g. brass, percussion, woodwind) to the list of URL's that
 	// contain the sample download links.
 	Samples map[string]map[string][]string `json:"samples"`
 
+	// Stream runs the processing pipeline over downloads as they arrive
+	// instead of writing the original files.
+	Stream bool `json:"stream"`
+
+	// Tee lists destinations downloaded files are also written to, see
+	// NewDestinations.
+	Tee []string `json:"tee"`
+
+	// Strict treats anomalies while scraping as errors: pages without
+	// audio links, redirects and link counts that changed a lot since the
+	// last run.
+	Strict bool `json:"strict"`
+
+	// TUI shows a full-screen dashboard while downloading.
+	TUI bool `json:"tui"`
+
+	// UID and GID are the owner of downloaded files and directories, -1 to
+	// leave it to the OS. Setting them needs root.
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+
 	Validate bool `json:"validate"`
+
+	// UpdateExpected records the number of links of every page scraped in
+	// Expected instead of checking them.
+	UpdateExpected bool `json:"update_expected"`
+
+	// User is the user:password to authenticate every request with, using
+	// basic auth.
+	User string `json:"user"`
+
+	// ValidationRetries is the number of times a file that fails validation
+	// is downloaded again, within the retry budget of its host.
+	ValidationRetries int `json:"validation_retries"`
+
+	// Velocity maps the dynamics of each articulation to MIDI velocities
+	// in exported instruments.
+	Velocity Velocity `json:"velocity"`
 }
 
 // NewConfig parses the application's configuration from env/flags.
@@ -355,22 +1368,247 @@ func NewConfig() (Config, error) {
 			},
 		},
 	}
+	flag.IntVar(&config.BreakerThreshold, "breaker-threshold", 5, "Consecutive failures that trip a host's circuit breaker (0 disables).")
+	flag.DurationVar(&config.BreakerCooldown, "breaker-cooldown", 30*time.Second, "How long a tripped circuit breaker waits before letting a request through.")
+	flag.StringVar(&config.BundleDir, "bundle-dir", "dist", "Directory the bundle command writes the zip, manifest, attribution and SHA256SUMS of a bundle to.")
+	flag.StringVar(&config.BundleName, "bundle-name", "iowa", "Name of bundles, e.g. cello for cello.zip and cello.json.")
+	flag.IntVar(&config.BufferSize, "buffer-size", DefaultBufferSize, "Size in bytes of the buffers downloads are copied with.")
+	flag.StringVar(&config.CACert, "cacert", "", "PEM file of extra certificate authorities to trust.")
+	flag.DurationVar(&config.CacheTTL, "cache-ttl", 0, "Use the audio links of instrument pages cached in "+ScrapeCacheFile+" for this long instead of scraping them again, e.g. 24h (0 always scrapes).")
+	flag.BoolVar(&config.CheckExisting, "check-existing", false, "Check the audio files already downloaded, keeping intact ones and downloading empty, truncated or unparsable ones again.")
+	flag.BoolVar(&config.Cookies, "cookies", false, "Keep the cookies servers set for the rest of the run (implied by -login).")
+	flag.StringVar(&config.Cert, "cert", "", "PEM client certificate (requires -key).")
+	flag.BoolVar(&config.Delete, "delete", false, "With the sync command, remove files that instrument pages no longer link to.")
+	flag.Var(modeFlag{&config.DirMode}, "dir-mode", "Octal permissions of the directories created for downloaded files, e.g. 0755 (default 0777 less the umask).")
 	flag.BoolVar(&config.Download, "dl", false, "Download samples (default is to just print a JSON list to stdout).")
 	flag.StringVar(&config.Era, "e", "all", "Filter by era ('all', 'pre-2012', 'post-2012').")
+	flag.StringVar(&config.Engine, "engine", EngineAuto, "Engine that decodes files for processing: native, ffmpeg or auto (native, falling back to ffmpeg if it is on the PATH).")
+	flag.Var((*listFlag)(&config.ExcludeSections), "exclude-section", "Comma-separated sections to leave out, e.g. percussion (repeatable).")
+	flag.StringVar(&config.Expected, "expected", "", "JSON file of the number of audio links expected on each instrument page (by URL), warning about pages with over 10% fewer or failing with -strict.")
+	flag.Var((*listFlag)(&config.Extensions), "ext", "Comma-separated extensions of the audio files to download, matched ignoring case (default "+strings.Join(DefaultExtensions, ",")+").")
+	fill := flag.String("fill", string(FillNearest), "How exported instruments play unsampled notes: nearest (split the difference between samples), up (retune samples up only) or down (retune samples down only).")
+	flag.Var((*listFlag)(&config.Export), "export", "Comma-separated formats to export downloaded instruments to ("+strings.Join(ExportFormats(), ", ")+").")
+	flag.StringVar(&config.ErrorsJSON, "errors-json", "", "Write every failure to this file as JSON.")
+	flag.BoolVar(&config.Fuzzy, "fuzzy", false, "Have search match terms that are misspelled by a letter or two, e.g. celo or pizz.")
+	flag.StringVar(&config.Collection, "collection", "", "Only download and convert the files of this collection, see the tag and collection commands.")
+	flag.StringVar(&config.GitHub, "github", "", "GitHub repository, owner/repo, that publish uploads to, with a token in GITHUB_TOKEN.")
+	flag.BoolVar(&config.DetectPitch, "detect-pitch", false, "Have the range command also report the range by the detected pitch of the files, and the files that aren't the note their name says.")
+	flag.StringVar(&config.Format, "format", "", "Output format of listings: table, json, plain (one item per line, tab-separated, for pipelines, which also has -validate print the URL of every audio file to stdout) or parquet (e.g. iowa search -format parquet > catalog.parquet for pandas or DuckDB). The default is json for the page list and table for the sections and instruments commands.")
+	flag.BoolVar(&config.Fsync, "fsync", false, "Flush every downloaded file, snapshot manifest and their directories to disk, for output that survives crashes and unplugged drives.")
+	flag.BoolVar(&config.IfChanged, "if-changed", false, "Send If-None-Match and If-Modified-Since for files already downloaded, skipping the ones that haven't changed ("+ValidatorsFile+").")
+	flag.Var((*stringsFlag)(&config.Headers), "header", "Header to add to every request, e.g. 'Authorization: Bearer token' (repeatable).")
+	flag.BoolVar(&config.Insecure, "insecure", false, "Skip verification of server certificates.")
+	jobs := flag.String("jobs", "0", "Maximum concurrent transfers, 0 for unlimited or 'auto' to tune based on throughput.")
+	flag.StringVar(&config.IPVersion, "ip-version", IPAuto, "IP version to connect with: 4, 6 or auto (either, e.g. -ip-version 4 on networks with broken IPv6 routes).")
+	flag.StringVar(&config.Key, "key", "", "PEM private key for -cert.")
+	flag.Float64Var(&config.LayerMargin, "layer-margin", 0, "How many LU louder than the dynamic below it the layers command expects each dynamic of a note to be, e.g. 3 to also flag dynamics that are barely louder.")
+	layout := flag.String("layout", string(LayoutMirror), "How downloaded files are arranged: mirror (the server's paths), flat (one directory), by-instrument (a directory per instrument page) or by-note (instrument/dynamic/note).")
+	flag.BoolVar(&config.Live, "live", false, "With the instruments command, scrape every page to count its audio files.")
+	flag.StringVar(&config.Login, "login", "", "URL to request before anything else, keeping the session cookies it sets, e.g. for a mirror behind a login form.")
+	flag.StringVar(&config.LoginData, "login-data", "", "Form to POST to the -login URL, e.g. user=me&password=secret, or @file to read it from a file.")
+	flag.Float64Var(&config.LoudnessTolerance, "loudness-tolerance", 3, "How many LU from the median of their dynamic of their instrument the loudness command reports files as outliers at.")
+	flag.StringVar(&config.Manifest, "manifest", "", "Download exactly the files in this manifest (e.g. a -snapshot filtered by hand) to the paths it records, checking their sizes and checksums.")
+	activeHours := flag.String("active-hours", "", "Comma-separated daily windows of local time to transfer files in, e.g. 01:00-07:00, pausing outside them (default is any time).")
+	maxBytes := flag.String("max-bytes", "0", "Stop starting downloads once this many bytes have been downloaded, e.g. 500M or 2G (0 is unlimited).")
+	flag.IntVar(&config.MaxFiles, "max-files", 0, "Stop starting downloads once this many files have been downloaded (0 is unlimited).")
+	flag.Float64Var(&config.MaxRPS, "max-rps", 0, "Most requests a second to each host, including scraping and size checks, e.g. 2 or 0.5 (0 is unlimited).")
+	flag.Var(modeFlag{&config.FileMode}, "mode", "Octal permissions of downloaded files, e.g. 0644 (default 0644 less the umask).")
+	flag.BoolVar(&config.MD5, "md5", false, "Also record the MD5 checksum of every file, computed while downloading, in -snapshot manifests.")
+	flag.Var((*stringsFlag)(&config.Mirrors), "mirror", "Fetch files from a mirror when the host of a base URL fails, e.g. http://theremin.music.uiowa.edu=https://mirror.example.edu/iowa (repeatable, mirrors are tried in order).")
+	flag.StringVar(&config.Names, "names", NamesNFC, "Unicode normalization of the names of downloaded files: nfc, nfd or none.")
+	flag.BoolVar(&config.NoColor, "no-color", false, "Disable colorized output (it is also disabled when stderr is not a terminal).")
+	flag.BoolVar(&config.Offline, "offline", false, "Use the audio links cached in "+ScrapeCacheFile+" instead of scraping instrument pages (they are also used when the site can't be reached).")
+	conflict := flag.String("on-conflict", string(ConflictError), "What to do when two downloads map to the same path: skip, overwrite, rename or error.")
+	flag.StringVar(&config.Order, "order", OrderPage, "Order the files of each page are downloaded in: page (as linked), smallest-first, largest-first, alpha or random.")
+	flag.StringVar(&config.Partial, "partial", PartialResume, "What to do with the partial files of crashed runs: resume (when the file is downloaded again) or remove.")
+	flag.BoolVar(&config.Preflight, "preflight", false, "Before downloading, print the total size of the selected files and an estimate of how long they take to download, from a short bandwidth probe.")
+	flag.StringVar(&config.PackDir, "pack-dir", "merged", "Directory pack merge merges the files of packs into.")
+	flag.StringVar(&config.Preset, "preset", PresetAll, "Files of each page to download: all, or one-dynamic for one dynamic of each note (mf or the closest to it), about a third of the size.")
+	external := flag.String("pipeline", "", "JSON file of external commands to run over every downloaded file, writing into -processed-dir.")
+	flag.StringVar(&config.Pipeline.Dir, "processed-dir", "processed", "Directory processed files are written to.")
+	flag.IntVar(&config.Pipeline.Rate, "rate", 0, "Resample processed files to this sample rate (0 keeps the original).")
+	flag.IntVar(&config.Pipeline.Bits, "bits", 0, "Bit depth of processed files, 16, 24 or 32 (0 keeps the original).")
+	flag.BoolVar(&config.Pipeline.FoldDualMono, "fold-dual-mono", false, "Make processed files that are dual mono (every channel the same, see the channels command) mono, leaving true stereo files alone.")
+	flag.BoolVar(&config.Pipeline.Mono, "mono", false, "Mix processed files down to mono.")
+	flag.Float64Var(&config.Pipeline.Trim, "trim", 0, "Trim leading and trailing audio below this level in dBFS, e.g. -60 (0 disables).")
+	flag.Var(optionalFloat{&config.Pipeline.Normalize}, "normalize", "Normalize the peak of processed files to this level in dBFS, e.g. -1.")
+	flag.BoolVar(&config.Pipeline.MatchGain, "match-gain", false, "Match the loudness of the processed files of each dynamic of an instrument across its range, keeping the dynamics' levels relative to each other, recording the gain in a JSON file next to each.")
+	flag.IntVar(&config.Pipeline.Blend, "blend", 0, "Render this many crossfaded samples between adjacent dynamics of each note into -processed-dir, for samplers with one layer.")
+	flag.BoolVar(&config.Pipeline.Segment, "segment", false, "Also split sustained processed files into attack, loopable sustain and release files, recording the loop in a JSON file next to each.")
+	flag.BoolVar(&config.Pipeline.Retune, "retune", false, "Correct the tuning of processed files that are up to 50 cents out, recording the correction in a JSON file next to each.")
+	flag.Float64Var(&config.Pipeline.A4, "a4", 440, "Reference pitch of A4 in Hz for -retune.")
+	flag.DurationVar(&config.ProgressInterval, "progress", 5*time.Second, "How often to print transfer speed and ETA while downloading (0 disables).")
+	flag.StringVar(&config.Record, "record", "", "Record all HTTP traffic in this cassette (a tar file) for -replay.")
+	flag.StringVar(&config.Replay, "replay", "", "Replay HTTP traffic from a cassette recorded with -record instead of using the network.")
+	flag.StringVar(&config.ReleaseTag, "release-tag", "", "Tag of the GitHub release that publish uploads to, e.g. v1.0.0, which is created if it doesn't exist.")
+	flag.BoolVar(&config.Reindex, "reindex", false, "Rebuild the search index ("+SearchIndexFile+") of the downloaded files before searching, e.g. after downloading more.")
+	flag.BoolVar(&config.Refresh, "refresh", false, "Scrape every instrument page again, even if its links are cached for -cache-ttl.")
+	flag.BoolVar(&config.Repair, "repair", false, "With the verify command, download files that fail verification again.")
+	flag.Var((*stringsFlag)(&config.Resolve), "resolve", "Pin host:port to an address, e.g. theremin.music.uiowa.edu:80:127.0.0.1 (repeatable).")
+	flag.IntVar(&config.Retries, "retries", 3, "Number of times a failed request is retried.")
+	flag.IntVar(&config.RetryBudget, "retry-budget", 100, "Retries allowed per host for the whole run (negative is unlimited).")
 	flag.StringVar(&config.Section, "s", "", "(REQUIRED) Section (e.g. brass, woodwind, percussion")
-	flag.BoolVar(&config.Validate, "validate", false, "Validate the URL of every audio file on the site.")
-	flag.Parse()
+	flag.BoolVar(&config.Sign, "sign", false, "Write a detached gpg signature (.asc) of every -snapshot manifest, and of bundles.")
+	flag.StringVar(&config.SignKey, "sign-key", "", "Key to -sign with, e.g. an email address or key ID (default is gpg's default key).")
+	flag.Float64Var(&config.Similarity, "similarity", 0.9, "Fraction of the bits of their fingerprints that the duplicates and identify commands need files to share, from 0.5 (unrelated) to 1 (identical).")
+	flag.StringVar(&config.SiteDir, "site-dir", "site", "Directory site build renders the static HTML site to.")
+	flag.StringVar(&config.Snapshot, "snapshot", "", "Write a dated, read-only manifest of the files downloaded (with their sizes, SHA-256 checksums and URL's) to this directory when every download succeeds.")
+	flag.BoolVar(&config.Stream, "stream", false, "Process downloads as they arrive instead of keeping the original files (needs processing flags such as -rate).")
+	flag.BoolVar(&config.Strict, "strict", false, "Fail on instrument pages that have no audio links, redirect, or have more than twice or under half as many links as last time ("+LinkCountsFile+").")
+	flag.Var((*listFlag)(&config.Tee), "tee", "Comma-separated destinations to also write downloaded files to as they arrive: directories or s3://bucket/prefix URL's, uploaded with the aws command (repeatable).")
+	flag.BoolVar(&config.TUI, "tui", false, "Show a full-screen dashboard while downloading.")
+	flag.BoolVar(&config.UpdateExpected, "update-expected", false, "Record the number of audio links of every page scraped in the -expected file instead of checking them.")
+	flag.IntVar(&config.UID, "uid", -1, "User ID to own downloaded files and directories (needs root).")
+	flag.IntVar(&config.GID, "gid", -1, "Group ID to own downloaded files and directories (needs root).")
+	flag.StringVar(&config.User, "user", "", "user:password to authenticate every request with (basic auth), e.g. for an access-controlled mirror.")
+	flag.IntVar(&config.ValidationRetries, "validation-retries", 2, "Number of times a downloaded file that is corrupt is downloaded again.")
+	flag.BoolVar(&config.Validate, "validate", false, "Validate the URL of every audio file on the site, or with the schema command a file against a schema.")
+	splits := flag.String("velocity-splits", "", "Comma-separated velocities at which each dynamic after the softest starts, e.g. 50,100 for pp,mf,ff (the default spreads dynamics evenly).")
+	flag.IntVar(&config.Velocity.Crossfade, "velocity-xfade", 0, "Width in velocity steps of the crossfade between dynamics (0 disables).")
+
+	// An optional subcommand, and the action of one that has actions, come
+	// before the flags.
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		config.Command, args = args[0], args[1:]
+	}
+	if commandActions[config.Command] && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		config.Action, args = args[0], args[1:]
+	}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return config, err
+	}
+	config.Args = flag.Args()
+
+	var err error
+	if config.Jobs, err = ParseJobs(*jobs); err != nil {
+		return config, err
+	}
+	if config.MaxBytes, err = ParseBytes(*maxBytes); err != nil {
+		return config, errors.Wrap(err, "max-bytes")
+	}
+	if config.ActiveHours, err = ParseWindows(*activeHours); err != nil {
+		return config, errors.Wrap(err, "active-hours")
+	}
+	if config.Refresh && config.Offline {
+		return config, errors.New("refresh can't be used with -offline")
+	}
+	if (config.UID >= 0 || config.GID >= 0) && os.Geteuid() != 0 {
+		return config, errors.New("uid and gid need root")
+	}
+	if len(config.User) > 0 && !strings.Contains(config.User, ":") {
+		return config, errors.New("user must be user:password")
+	}
+	if len(config.LoginData) > 0 && len(config.Login) == 0 {
+		return config, errors.New("login-data needs -login")
+	}
+	if config.Sign && len(config.Snapshot) == 0 && config.Command != "bundle" {
+		return config, errors.New("sign needs -snapshot or the bundle command")
+	}
+	if len(config.SignKey) > 0 && !config.Sign {
+		return config, errors.New("sign-key needs -sign")
+	}
+	if len(config.Tee) > 0 && config.Stream {
+		return config, errors.New("tee can't be used with -stream")
+	}
+	if config.UpdateExpected && len(config.Expected) == 0 {
+		return config, errors.New("update-expected needs -expected")
+	}
+	if config.Partial != PartialResume && config.Partial != PartialRemove {
+		return config, errors.New("partial must be resume or remove")
+	}
+	switch config.Format {
+	case "", FormatTable, FormatJSON, FormatPlain, FormatParquet:
+	default:
+		return config, errors.New("format must be table, json, plain or parquet")
+	}
+	switch config.IPVersion {
+	case IPAuto, IPv4, IPv6:
+	default:
+		return config, errors.New("ip-version must be 4, 6 or auto")
+	}
+	if config.Preset != PresetAll && config.Preset != PresetOneDynamic {
+		return config, errors.New("preset must be all or one-dynamic")
+	}
+	switch config.Order {
+	case OrderPage, OrderSmallest, OrderLargest, OrderAlpha, OrderRandom:
+	default:
+		return config, errors.New("order must be page, smallest-first, largest-first, alpha or random")
+	}
+	switch config.Names {
+	case NamesNFC, NamesNFD, NamesNone:
+	default:
+		return config, errors.New("names must be nfc, nfd or none")
+	}
+	if len(config.Extensions) == 0 {
+		config.Extensions = DefaultExtensions
+	}
+	config.Extensions = ParseExtensions(config.Extensions)
+
+	if len(*external) > 0 {
+		if config.External, err = LoadExternalPipeline(*external); err != nil {
+			return config, err
+		}
+	}
+	if config.Layout, err = ParseLayout(*layout); err != nil {
+		return config, err
+	}
+	if config.OnConflict, err = ParseConflict(*conflict); err != nil {
+		return config, err
+	}
+	if config.Fill, err = ParseFill(*fill); err != nil {
+		return config, err
+	}
+	if config.Velocity.Splits, err = ParseVelocitySplits(*splits); err != nil {
+		return config, err
+	}
+	if config.Velocity.Crossfade < 0 {
+		return config, errors.New("velocity-xfade must not be negative")
+	}
+	if config.LayerMargin < 0 {
+		return config, errors.New("layer-margin must not be negative")
+	}
+	if config.LoudnessTolerance < 0 {
+		return config, errors.New("loudness-tolerance must not be negative")
+	}
+	if config.Similarity < 0 || config.Similarity > 1 {
+		return config, errors.New("similarity must be between 0 and 1")
+	}
+	if config.MaxRPS < 0 {
+		return config, errors.New("max-rps must not be negative")
+	}
+	if config.Stream && (!config.Pipeline.Enabled() || contains(config.Export, "sox")) {
+		return config, errors.New("stream needs processing flags and can't be used with -export sox")
+	}
+	if config.Pipeline.MatchGain && (config.Pipeline.Normalize != nil || config.Stream || contains(config.Export, "sox")) {
+		return config, errors.New("match-gain needs every file of an instrument and can't be used with -normalize, -stream or -export sox")
+	}
+	if contains(config.Export, "sox") && (config.Pipeline.Retune || config.Pipeline.Segment || config.Pipeline.FoldDualMono) {
+		// The script only does what sox can, see SoX.
+		return config, withKind(FailureConfig, errors.New("export sox can't be used with -retune, -segment or -fold-dual-mono"))
+	}
+	if config.Pipeline.Trim > 0 {
+		return config, withKind(FailureConfig, errors.New("trim must be a negative level in dBFS, e.g. -60"))
+	}
+	if config.Pipeline.A4 <= 0 {
+		return config, errors.New("a4 must be positive")
+	}
+	if b := config.Pipeline.Bits; b != 0 && b != 16 && b != 24 && b != 32 {
+		return config, errors.New("bits must be 16, 24 or 32")
+	}
 
 	if config.Era != "all" {
-		sections, ok := config.Samples[config.Era]
-		if !ok {
+		if _, ok := config.Samples[config.Era]; !ok {
 			return config, errors.New("unsupported era: " + config.Era)
 		}
-		// Validate -s if it was provided.
-		if len(config.Section) > 0 {
-			if _, ok := sections[config.Section]; !ok {
-				return config, errors.New("unsupported section: " + config.Section)
-			}
+	}
+	// Validate -s if it was provided.
+	if len(config.Section) > 0 && !config.hasSection(config.Era, config.Section) {
+		return config, errors.New("unsupported section: " + config.Section)
+	}
+	for _, section := range config.ExcludeSections {
+		if !config.hasSection("all", section) {
+			return config, errors.New("unsupported section: " + section)
 		}
 	}
 	return config, nil
@@ -380,12 +1618,50 @@ func NewConfig() (Config, error) {
 type Download struct {
 	Content  io.ReadCloser
 	Location string
+	Size     int64  // Content-Length, -1 if unknown.
+	File     File   // Where the download is written.
+	Part     string // Partial file it is written to first, see Claims.
+	Offset   int64  // Size of the partial file Content resumes, 0 if it is the whole file.
+
+	// Validator identifies the version of the file, if the server sent one.
+	// The range response of a resumed download has the validator of the
+	// whole file.
+	Validator Validator
 }
 
-// IsAudioFile returns true if the provided string ends with .aif or .aiff or .wav
-func IsAudioFile(s string) bool {
-	if strings.HasSuffix(s, ".aif") || strings.HasSuffix(s, ".aiff") || strings.HasSuffix(s, ".wav") {
-		return true
+// parseHref parses a link, escaping any percent signs that don't start an
+// escape (e.g. in 100%.aif) rather than dropping it.
+func parseHref(href string) (*stdurl.URL, error) {
+	href = strings.TrimSpace(href)
+	if u, err := stdurl.Parse(href); err == nil {
+		return u, nil
+	}
+	return stdurl.Parse(badEscape.ReplaceAllString(href, "%25${1}"))
+}
+
+var badEscape = regexp.MustCompile(`%([^0-9A-Fa-f]|[0-9A-Fa-f][^0-9A-Fa-f]|[0-9A-Fa-f]?$)`)
+
+// DefaultExtensions are the audio file extensions matched by default.
+var DefaultExtensions = []string{".aif", ".aiff", ".aifc", ".wav", ".mp3"}
+
+// IsAudioFile returns true if the provided string ends with one of the
+// extensions (e.g. .aif), ignoring case.
+func IsAudioFile(s string, exts []string) bool {
+	ext := strings.ToLower(path.Ext(s))
+	for _, e := range exts {
+		if ext == e {
+			return true
+		}
 	}
 	return false
 }
+
+// ParseExtensions normalizes a list of extensions to lower case with a
+// leading dot, e.g. WAV to .wav.
+func ParseExtensions(exts []string) []string {
+	out := make([]string, len(exts))
+	for i, ext := range exts {
+		out[i] = "." + strings.TrimPrefix(strings.ToLower(ext), ".")
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	stdurl "net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// misPitches2012 is an instrument page of the MIS-Pitches-2012 directory,
+// whose links to audio files are relative to the directory above it.
+const misPitches2012 = "http://theremin.music.uiowa.edu/MIS-Pitches-2012/MISCello2012.html"
+
+func TestAudioLinks(t *testing.T) {
+	page, err := stdurl.Parse(misPitches2012)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name string
+		href string
+		want []string
+	}{
+		{
+			name: "sound files directory",
+			href: "../sound files/MIS Pitches - 2014/Strings/Cello/Cello.arco.ff.sulA.A3.stereo.aif",
+			want: []string{"http://theremin.music.uiowa.edu/sound%20files/MIS%20Pitches%20-%202014/Strings/Cello/Cello.arco.ff.sulA.A3.stereo.aif"},
+		},
+		{
+			name: "escaped",
+			href: "../sound%20files/MIS%20Pitches%20-%202014/Strings/Cello/Cello.arco.ff.sulA.A3.stereo.aif",
+			want: []string{"http://theremin.music.uiowa.edu/sound%20files/MIS%20Pitches%20-%202014/Strings/Cello/Cello.arco.ff.sulA.A3.stereo.aif"},
+		},
+		{
+			name: "nested",
+			href: "../../sound files/MIS/Strings/cello/Cello.arco.pp.sulC.C2.stereo.aif",
+			want: []string{"http://theremin.music.uiowa.edu/sound%20files/MIS/Strings/cello/Cello.arco.pp.sulC.C2.stereo.aif"},
+		},
+		{
+			name: "same directory",
+			href: "Cello.arco.mf.sulG.G2.stereo.aif",
+			want: []string{"http://theremin.music.uiowa.edu/MIS-Pitches-2012/Cello.arco.mf.sulG.G2.stereo.aif"},
+		},
+		{
+			name: "absolute path",
+			href: "/sound files/MIS/Strings/cello/Cello.arco.ff.sulA.A3.stereo.aif",
+			want: []string{"http://theremin.music.uiowa.edu/sound%20files/MIS/Strings/cello/Cello.arco.ff.sulA.A3.stereo.aif"},
+		},
+		{
+			name: "absolute url",
+			href: "https://example.com/samples/Cello.arco.ff.sulA.A3.stereo.AIF",
+			want: []string{"https://example.com/samples/Cello.arco.ff.sulA.A3.stereo.AIF"},
+		},
+		{
+			name: "query",
+			href: "../sound files/MIS/Strings/cello/Cello.arco.ff.sulA.A3.stereo.aif?download=1",
+			want: []string{"http://theremin.music.uiowa.edu/sound%20files/MIS/Strings/cello/Cello.arco.ff.sulA.A3.stereo.aif?download=1"},
+		},
+		{
+			name: "fragment",
+			href: "../sound files/MIS/Strings/cello/Cello.arco.ff.sulA.A3.stereo.aif#top",
+			want: []string{"http://theremin.music.uiowa.edu/sound%20files/MIS/Strings/cello/Cello.arco.ff.sulA.A3.stereo.aif"},
+		},
+		{
+			name: "percent sign",
+			href: "../sound files/MIS/Strings/cello/Cello.arco.ff.100%.A3.aif",
+			want: []string{"http://theremin.music.uiowa.edu/sound%20files/MIS/Strings/cello/Cello.arco.ff.100%25.A3.aif"},
+		},
+		{
+			name: "not audio",
+			href: "../MIS-Pitches-2012/MISViola2012.html",
+		},
+		{
+			name: "audio in the query",
+			href: "../player.html?file=Cello.arco.ff.sulA.A3.stereo.aif",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root, err := html.Parse(strings.NewReader(`<html><body><a href="` + html.EscapeString(test.href) + `">sample</a></body></html>`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := AudioLinks(page, root, DefaultExtensions); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("AudioLinks(%q) = %q, want %q", test.href, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseHref(t *testing.T) {
+	for _, test := range []struct {
+		href string
+		path string
+	}{
+		{"../sound files/MIS/Strings/cello/Cello.arco.ff.sulA.A3.stereo.aif", "../sound files/MIS/Strings/cello/Cello.arco.ff.sulA.A3.stereo.aif"},
+		{" ../../sound%20files/Cello.aif ", "../../sound files/Cello.aif"},
+		{"/sound files/Cello.aif?x=1#y", "/sound files/Cello.aif"},
+		{"Cello.100%.aif", "Cello.100%.aif"},
+		{"Cello.%zz.aif", "Cello.%zz.aif"},
+		{"Cello.%4.aif", "Cello.%4.aif"},
+	} {
+		u, err := parseHref(test.href)
+		if err != nil {
+			t.Errorf("parseHref(%q): %v", test.href, err)
+			continue
+		}
+		if u.Path != test.path {
+			t.Errorf("parseHref(%q).Path = %q, want %q", test.href, u.Path, test.path)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// downloadManifest downloads exactly the files in the -manifest, e.g. a
+// snapshot filtered by hand, to the paths it records. Files that are
+// already there and match the manifest are skipped, and downloads that
+// don't match it are rejected.
+func (app *App) downloadManifest(ctx context.Context) error {
+	m, err := LoadManifest(app.Manifest)
+	if err != nil {
+		return withKind(FailureConfig, err)
+	}
+	for _, mf := range m.Files {
+		if len(mf.URL) == 0 || len(mf.Path) == 0 {
+			return withKind(FailureConfig, errors.New(app.Manifest+": every file needs a url and a path"))
+		}
+		app.progress.Add(mf.URL)
+	}
+	if err := app.openState(); err != nil {
+		return err
+	}
+	if err := app.cleanPartials("."); err != nil {
+		return err
+	}
+	var g errgroup.Group
+
+	for _, mf := range m.Files {
+		mf := mf
+		if _, err := os.Stat(mf.Path); err == nil && verifyFile(mf) == nil {
+			app.progress.Skip(mf.URL)
+			app.term.Status(StatusSkip, "%s: already downloaded", mf.Path)
+			continue
+		}
+		g.Go(func() error {
+			if err := app.limiter.Acquire(ctx); err != nil {
+				return nil
+			}
+			defer app.limiter.Release()
+
+			if err := app.fetchFile(ctx, mf); err != nil {
+				app.fail(mf.URL, err)
+				return nil
+			}
+			app.term.Status(StatusOK, "%s", mf.Path)
+			return nil
+		})
+	}
+	_ = g.Wait() // Failures are recorded instead.
+
+	if n := app.failures.Count(FailureDownload); n > 0 {
+		return withKind(FailureDownload, errors.Errorf("%d files could not be downloaded", n))
+	}
+	return nil
+}
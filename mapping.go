@@ -0,0 +1,226 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Layer is the set of files of an instrument that share an articulation
+// and dynamic, with one file per note. Further takes of a note (e.g. the
+// same note played on another string) are kept for round robins.
+type Layer struct {
+	Articulation string
+	Dynamic      string
+	Files        []File   // Sorted by note.
+	Takes        [][]File // Every take of each file's note, starting with the file.
+}
+
+// Name returns the articulation and dynamic of the layer, e.g. arco ff.
+func (l Layer) Name() string {
+	switch {
+	case len(l.Articulation) == 0:
+		return l.Dynamic
+	case len(l.Dynamic) == 0:
+		return l.Articulation
+	}
+	return l.Articulation + " " + l.Dynamic
+}
+
+// Layers groups files (sorted the way fileList sorts them) into layers.
+// Files without a note are left out, and the first file for each note is
+// the one played unless an exporter supports round robins.
+func Layers(files []File) []Layer {
+	var layers []Layer
+
+	for _, art := range groupFiles(files, func(f File) string { return f.Articulation }) {
+		for _, dyn := range groupFiles(art.files, func(f File) string { return f.Dynamic }) {
+			var (
+				layer = Layer{Articulation: art.key, Dynamic: dyn.key}
+				seen  = map[int]int{} // Index of each note in layer.Files.
+			)
+			for _, f := range dyn.files {
+				if f.MIDI == 0 {
+					continue
+				}
+				if i, ok := seen[f.MIDI]; ok {
+					layer.Takes[i] = append(layer.Takes[i], f)
+					continue
+				}
+				seen[f.MIDI] = len(layer.Files)
+				layer.Files = append(layer.Files, f)
+				layer.Takes = append(layer.Takes, []File{f})
+			}
+			if len(layer.Files) > 0 {
+				layers = append(layers, layer)
+			}
+		}
+	}
+	return layers
+}
+
+// Fill is a strategy for mapping unsampled notes to sampled ones.
+type Fill string
+
+// Fill strategies.
+const (
+	FillNearest Fill = "nearest" // Split the difference between neighboring samples.
+	FillUp      Fill = "up"      // Play each sample up to the next one, so samples are only retuned up.
+	FillDown    Fill = "down"    // Play each sample down to the previous one, so samples are only retuned down.
+)
+
+// ParseFill parses a fill strategy.
+func ParseFill(s string) (Fill, error) {
+	switch fill := Fill(s); fill {
+	case FillNearest, FillUp, FillDown:
+		return fill, nil
+	}
+	return "", errors.Errorf("unknown fill %q (nearest, up or down)", s)
+}
+
+// KeyMap returns, for every MIDI note, the index in l.Files of the file
+// that plays it. With FillNearest ties go to the lower note. Notes beyond
+// the lowest or highest sample play it whatever the strategy.
+func (l Layer) KeyMap(fill Fill) [128]int {
+	var (
+		m [128]int
+		j int
+	)
+	for n := range m {
+		for j+1 < len(l.Files) && l.nextPlays(fill, j, n) {
+			j++
+		}
+		m[n] = j
+	}
+	return m
+}
+
+// nextPlays returns true if note n is played by file j+1 rather than file j.
+func (l Layer) nextPlays(fill Fill, j, n int) bool {
+	next, cur := l.Files[j+1].MIDI, l.Files[j].MIDI
+
+	switch fill {
+	case FillUp:
+		return n >= next
+	case FillDown:
+		return n > cur
+	}
+	return abs(next-n) < abs(cur-n)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// KeyRanges returns the lowest and highest MIDI note KeyMap maps to each
+// file of the layer.
+func (l Layer) KeyRanges(fill Fill) [][2]int {
+	ranges := make([][2]int, len(l.Files))
+	for i := range ranges {
+		ranges[i] = [2]int{-1, -1}
+	}
+	for n, idx := range l.KeyMap(fill) {
+		if ranges[idx][0] < 0 {
+			ranges[idx][0] = n
+		}
+		ranges[idx][1] = n
+	}
+	return ranges
+}
+
+// Velocity configures how the dynamics of an articulation are mapped to
+// MIDI velocities.
+type Velocity struct {
+	// Splits are the velocities at which each layer after the first starts,
+	// e.g. 50,100 for pp/mf/ff. They are used for articulations with one
+	// more dynamic than there are splits, the others are spread evenly.
+	Splits []int `json:"splits,omitempty"`
+
+	// Crossfade is the width in velocity steps of the crossfade centered
+	// on each split. Zero switches layers without crossfading.
+	Crossfade int `json:"crossfade,omitempty"`
+}
+
+// VelocityRange is the range of velocities a layer plays at.
+type VelocityRange struct {
+	Low, High int // Including crossfades.
+
+	// FadeIn and FadeOut are the widths of the crossfades at Low and High.
+	FadeIn, FadeOut int
+}
+
+// Ranges returns the velocity ranges of n layers, ordered from soft to loud.
+func (v Velocity) Ranges(n int) []VelocityRange {
+	starts := v.Splits
+	if len(starts) != n-1 {
+		starts = make([]int, n-1)
+		for i := range starts {
+			starts[i] = 1 + (i+1)*127/n
+		}
+	}
+	var (
+		ranges = make([]VelocityRange, n)
+		half   = v.Crossfade / 2
+	)
+	for i := range ranges {
+		r := VelocityRange{Low: 1, High: 127}
+		if i > 0 {
+			r.Low, r.FadeIn = starts[i-1]-half, v.Crossfade
+		}
+		if i < n-1 {
+			r.High, r.FadeOut = starts[i]-half+v.Crossfade, v.Crossfade
+			if v.Crossfade == 0 {
+				r.High--
+			}
+		}
+		r.Low, r.High = clamp(r.Low, 1, 127), clamp(r.High, 1, 127)
+		ranges[i] = r
+	}
+	return ranges
+}
+
+// Starts returns the velocities at which each of n layers after the first
+// starts, ignoring crossfades.
+func (v Velocity) Starts(n int) []int {
+	var splits []int
+	for i, r := range v.Ranges(n) {
+		if i > 0 {
+			splits = append(splits, r.Low+r.FadeIn/2)
+		}
+	}
+	return splits
+}
+
+// ParseVelocitySplits parses a comma-separated list of increasing velocities.
+func ParseVelocitySplits(s string) ([]int, error) {
+	var splits []int
+
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); len(field) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, errors.Errorf("invalid velocity %q", field)
+		}
+		if n < 2 || n > 127 || (len(splits) > 0 && n <= splits[len(splits)-1]) {
+			return nil, errors.New("velocity splits must be increasing and between 2 and 127")
+		}
+		splits = append(splits, n)
+	}
+	return splits, nil
+}
+
+func clamp(n, lo, hi int) int {
+	switch {
+	case n < lo:
+		return lo
+	case n > hi:
+		return hi
+	}
+	return n
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// catalogFile is a row of the table of an instrument in the Markdown
+// catalog.
+type catalogFile struct {
+	File
+	Size     int64
+	Duration float64 // In seconds, negative if the file couldn't be decoded.
+}
+
+// catalog runs the catalog command: "iowa catalog [flags] [dir]" writes a
+// Markdown catalog of the audio files under dir (the current directory by
+// default) to stdout, with a table of the instruments and a table of the
+// notes, dynamics, durations and sizes of the files of each, by era and
+// section.
+func (app *App) catalog(ctx context.Context) error {
+	if len(app.Args) > 1 {
+		return withKind(FailureConfig, errors.New("usage: iowa catalog [flags] [dir]"))
+	}
+	root := "."
+	if len(app.Args) == 1 {
+		root = app.Args[0]
+	}
+	insts, err := app.localInstruments(root)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(insts, func(i, j int) bool { return insts[i].Page.String() < insts[j].Page.String() })
+
+	files := make([][]catalogFile, len(insts))
+	for i, inst := range insts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if files[i], err = app.catalogFiles(ctx, inst.Files); err != nil {
+			return errors.Wrap(err, "cataloging "+inst.Page.Instrument)
+		}
+	}
+	w := bufio.NewWriter(os.Stdout)
+
+	fmt.Fprintln(w, "# University of Iowa Musical Instrument Samples")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "| Era | Section | Instrument | Files | Size | Duration |")
+	fmt.Fprintln(w, "| --- | --- | --- | ---: | ---: | ---: |")
+	for i, inst := range insts {
+		size, duration := catalogTotals(files[i])
+		fmt.Fprintf(w, "| %s | %s | %s | %d | %s | %s |\n", markdownCell(inst.Page.Era), markdownCell(inst.Page.Section),
+			markdownCell(inst.Page.Instrument), len(files[i]), FormatBytes(size), formatSeconds(duration))
+	}
+	var era, section string
+
+	for i, inst := range insts {
+		if inst.Page.Era != era {
+			era, section = inst.Page.Era, ""
+			fmt.Fprintf(w, "\n## %s\n", era)
+		}
+		if inst.Page.Section != section {
+			section = inst.Page.Section
+			fmt.Fprintf(w, "\n### %s\n", section)
+		}
+		fmt.Fprintf(w, "\n#### %s\n\n", inst.Page.Instrument)
+		fmt.Fprintln(w, "| File | Note | Dynamic | Articulation | Channels | Duration | Size |")
+		fmt.Fprintln(w, "| --- | --- | --- | --- | --- | ---: | ---: |")
+		for _, f := range files[i] {
+			note := f.Note
+			if len(f.HighNote) > 0 && f.HighNote != f.Note {
+				note += "-" + f.HighNote
+			}
+			duration := "-"
+			if f.Duration >= 0 {
+				duration = formatSeconds(f.Duration)
+			}
+			fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s | %s |\n", markdownCell(f.Path), note, f.Dynamic,
+				markdownCell(f.Articulation), f.Channels, duration, FormatBytes(f.Size))
+		}
+	}
+	return errors.Wrap(w.Flush(), "writing catalog")
+}
+
+// catalogFiles returns the sizes and durations of files, decoding as many
+// at once as -jobs allows.
+func (app *App) catalogFiles(ctx context.Context, files []File) ([]catalogFile, error) {
+	var (
+		out = make([]catalogFile, len(files))
+		g   errgroup.Group
+	)
+	for i, f := range files {
+		i, f := i, f
+		g.Go(func() error {
+			if err := app.limiter.Acquire(ctx); err != nil {
+				return err
+			}
+			defer app.limiter.Release()
+
+			info, err := os.Stat(f.Path)
+			if err != nil {
+				return err
+			}
+			out[i] = catalogFile{File: f, Size: info.Size(), Duration: -1}
+
+			a, err := app.engine.Decode(f.Path)
+			if err != nil {
+				app.term.Status(StatusWarn, "%s: no duration: %s", f.Path, err)
+				return nil
+			}
+			if a.SampleRate > 0 {
+				out[i].Duration = float64(a.Frames()) / float64(a.SampleRate)
+			}
+			return nil
+		})
+	}
+	return out, g.Wait()
+}
+
+// catalogTotals returns the total size and duration of files, leaving out
+// the durations that aren't known.
+func catalogTotals(files []catalogFile) (size int64, duration float64) {
+	for _, f := range files {
+		size += f.Size
+		if f.Duration > 0 {
+			duration += f.Duration
+		}
+	}
+	return size, duration
+}
+
+// formatSeconds formats a duration in seconds, e.g. 2.35s or 1m12.0s.
+func formatSeconds(s float64) string {
+	if s < 60 {
+		return fmt.Sprintf("%.2fs", s)
+	}
+	return fmt.Sprintf("%dm%04.1fs", int(s/60), s-60*float64(int(s/60)))
+}
+
+// markdownCell escapes the characters of s that would end a table cell.
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// segmentParts are the suffixes of the files a processed file is segmented
+// into, e.g. Cello.arco.ff.A3.attack.wav, see writeSegments.
+var segmentParts = []string{".attack", ".sustain", ".release"}
+
+// MetadataName returns the name of the JSON file of metadata next to a
+// processed file, e.g. Cello.arco.ff.A3.json for Cello.arco.ff.A3.wav.
+func MetadataName(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".json"
+}
+
+// ReadMetadata reads the metadata that processing wrote next to a
+// processed file, or to the file it was segmented from if it is a segment.
+// The error satisfies os.IsNotExist if the file has none.
+func ReadMetadata(name string) (Processed, error) {
+	var meta Processed
+
+	data, err := ioutil.ReadFile(MetadataName(name))
+	if os.IsNotExist(err) {
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		for _, part := range segmentParts {
+			if strings.HasSuffix(base, part) {
+				data, err = ioutil.ReadFile(MetadataName(strings.TrimSuffix(base, part) + ".wav"))
+				break
+			}
+		}
+	}
+	if err != nil {
+		return meta, err
+	}
+	return meta, errors.Wrap(json.Unmarshal(data, &meta), "parsing metadata of "+name)
+}
+
+// FileMetadata is the metadata of a processed file.
+type FileMetadata struct {
+	File string `json:"file"`
+	Processed
+}
+
+// metadata runs the metadata command: "iowa metadata [flags] file..." reads
+// the metadata that processing wrote for processed files, e.g. with
+// -retune, -segment or -match-gain, and lists it, or with -format json
+// writes it as processing did, so that other tools can read it.
+func (app *App) metadata() error {
+	if len(app.Args) == 0 {
+		return withKind(FailureConfig, errors.New("usage: iowa metadata [flags] file..."))
+	}
+	var (
+		metas = []FileMetadata{}
+		rows  [][]string
+	)
+	for _, name := range app.Args {
+		meta, err := ReadMetadata(name)
+		if os.IsNotExist(err) {
+			app.term.Status(StatusWarn, "%s: no metadata, it wasn't retuned, segmented or gain matched", name)
+			continue
+		}
+		if err != nil {
+			return withKind(FailureConfig, err)
+		}
+		metas = append(metas, FileMetadata{File: name, Processed: meta})
+
+		retune, gain, loop := "-", "-", "-"
+		if r := meta.Retune; r != nil && r.Detected > 0 {
+			retune = fmt.Sprintf("%+.1f", r.Cents)
+			if !r.Applied {
+				retune += " (not applied)"
+			}
+		}
+		if g := meta.Gain; g != nil {
+			gain = fmt.Sprintf("%+.1f dB", g.Gain)
+			if g.Limited {
+				gain += " (limited)"
+			}
+		}
+		if s := meta.Segments; s != nil {
+			loop = fmt.Sprintf("%d-%d", s.LoopStart, s.LoopEnd)
+		}
+		rows = append(rows, []string{name, meta.Source, meta.Sample.Note, meta.Sample.Dynamic, retune, gain, loop})
+	}
+	return app.printList(os.Stdout, metas, []string{"FILE", "SOURCE", "NOTE", "DYNAMIC", "RETUNE", "GAIN", "LOOP"}, rows)
+}
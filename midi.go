@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// midiTicks is the resolution of audition files in ticks per quarter note.
+const midiTicks = 480
+
+// mappingFormats are the export formats that map samples to notes, which
+// get an audition file too.
+var mappingFormats = map[string]bool{
+	"bitwig": true,
+	"ck":     true,
+	"csd":    true,
+	"pd":     true,
+	"reaper": true,
+	"scd":    true,
+	"sfz":    true,
+	"tal":    true,
+}
+
+// Audition exports a standard MIDI file per instrument that plays every
+// sampled note of every layer in turn, one per beat at 120 BPM, with each
+// articulation on the channel the channel-based exporters use for it.
+type Audition struct {
+	velocity Velocity
+}
+
+// NewAudition creates an audition file exporter.
+func NewAudition(conf Config, engine Engine) Exporter {
+	return Audition{velocity: conf.Velocity}
+}
+
+// Export writes <instrument>.mid.
+func (au Audition) Export(inst Instrument) error {
+	layers := Layers(inst.Files)
+	if len(layers) == 0 {
+		return nil
+	}
+	var track midiTrack
+
+	track.meta(0, 0x03, []byte(inst.Page.Instrument))
+	track.meta(0, 0x51, []byte{0x07, 0xa1, 0x20}) // 500000 µs per quarter note.
+
+	for i, art := range groupLayers(layers) {
+		var (
+			ch     = byte(i % 16)
+			ranges = au.velocity.Ranges(len(art))
+		)
+		for j, layer := range art {
+			vel := byte((ranges[j].Low + ranges[j].High) / 2)
+			for _, f := range layer.Files {
+				track.event(0, 0x90|ch, byte(f.MIDI), vel)
+				track.event(midiTicks*3/4, 0x80|ch, byte(f.MIDI), 0)
+				track.rest(midiTicks / 4)
+			}
+		}
+	}
+	track.meta(0, 0x2f, nil)
+
+	if err := ioutil.WriteFile(inst.Name()+".mid", track.file(), 0644); err != nil {
+		return errors.Wrap(err, "writing midi file")
+	}
+	return nil
+}
+
+// midiTrack builds the events of a MIDI track.
+type midiTrack struct {
+	buf   bytes.Buffer
+	delta int // Ticks of rest before the next event.
+}
+
+func (t *midiTrack) rest(ticks int) {
+	t.delta += ticks
+}
+
+func (t *midiTrack) event(delta int, status, data1, data2 byte) {
+	t.writeDelta(delta)
+	t.buf.Write([]byte{status, data1, data2})
+}
+
+func (t *midiTrack) meta(delta int, kind byte, data []byte) {
+	t.writeDelta(delta)
+	t.buf.Write([]byte{0xff, kind})
+	writeVLQ(&t.buf, len(data))
+	t.buf.Write(data)
+}
+
+func (t *midiTrack) writeDelta(delta int) {
+	writeVLQ(&t.buf, t.delta+delta)
+	t.delta = 0
+}
+
+// file returns a format 0 MIDI file containing the track.
+func (t *midiTrack) file() []byte {
+	var out bytes.Buffer
+
+	out.WriteString("MThd")
+	_ = binary.Write(&out, binary.BigEndian, []uint32{6})               // Writing to a bytes.Buffer can't fail.
+	_ = binary.Write(&out, binary.BigEndian, []uint16{0, 1, midiTicks}) // Format, tracks, division.
+	out.WriteString("MTrk")
+	_ = binary.Write(&out, binary.BigEndian, uint32(t.buf.Len()))
+	out.Write(t.buf.Bytes())
+	return out.Bytes()
+}
+
+// writeVLQ writes n as a MIDI variable-length quantity.
+func writeVLQ(buf *bytes.Buffer, n int) {
+	var b [5]byte
+
+	i := len(b) - 1
+	b[i] = byte(n & 0x7f)
+	for n >>= 7; n > 0; n >>= 7 {
+		i--
+		b[i] = byte(n&0x7f) | 0x80
+	}
+	buf.Write(b[i:])
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	stdurl "net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Mirror is an alternate base URL for the files under a base URL of a
+// source, e.g. an institutional copy of the site.
+type Mirror struct {
+	Base      string // e.g. http://theremin.music.uiowa.edu
+	Alternate string // e.g. https://mirror.example.edu/uiowa
+}
+
+// ParseMirrors parses base=alternate entries, in the order they are tried.
+func ParseMirrors(entries []string) ([]Mirror, error) {
+	var mirrors []Mirror
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("expected base=mirror, got " + entry)
+		}
+		for _, s := range parts {
+			if u, err := stdurl.Parse(s); err != nil || !u.IsAbs() || len(u.Host) == 0 {
+				return nil, errors.New("invalid url in " + entry)
+			}
+		}
+		mirrors = append(mirrors, Mirror{
+			Base:      strings.TrimSuffix(parts[0], "/"),
+			Alternate: strings.TrimSuffix(parts[1], "/"),
+		})
+	}
+	return mirrors, nil
+}
+
+// mirrorsOf returns url followed by the same path on each of its mirrors.
+func (app *App) mirrorsOf(url string) []string {
+	urls := []string{url}
+
+	for _, m := range app.mirrors {
+		if url == m.Base || strings.HasPrefix(url, m.Base+"/") {
+			urls = append(urls, m.Alternate+strings.TrimPrefix(url, m.Base))
+		}
+	}
+	return urls
+}
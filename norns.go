@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Norns exports each instrument as a flat folder of 48 kHz WAV files with
+// short names, e.g. norns/cello12/arco-ff-a3.wav, ready to be copied to
+// dust/audio on a norns.
+type Norns struct {
+	engine Engine
+}
+
+// NewNorns creates a norns exporter.
+func NewNorns(conf Config, engine Engine) Exporter {
+	return Norns{engine: engine}
+}
+
+// Export converts the instrument's files into norns/<instrument>.
+func (n Norns) Export(inst Instrument) error {
+	var (
+		dir   = filepath.Join("norns", shortInstrumentName(inst.Page))
+		names = map[string]int{}
+	)
+	return convertFiles(n.engine, dir, inst.Files, 24, func(f File) string {
+		return uniqueName(names, shortSampleName(f))
+	}, func(a *Audio) *Audio {
+		return Resample(a, 48000)
+	})
+}
+
+// convertFiles decodes files with engine, converts them and writes them to
+// dir as WAV files of the given bit depth, named by name.
+func convertFiles(engine Engine, dir string, files []File, bits int, name func(File) string, convert func(*Audio) *Audio) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return errors.Wrap(err, "making directory")
+	}
+	for _, f := range files {
+		a, err := engine.Decode(f.Path)
+		if err != nil {
+			return errors.Wrap(err, "decoding "+f.Path)
+		}
+		out := name(f)
+		if err := WriteWAVFile(filepath.Join(dir, out+".wav"), convert(a), bits); err != nil {
+			return errors.Wrap(err, "writing "+out)
+		}
+	}
+	return nil
+}
+
+// shortInstrumentName returns a short folder name for an instrument page,
+// e.g. cello for the pre-2012 cello and cello12 for the post-2012 one. The
+// 2012 string pages of the pre-2012 site, e.g. MIScello2012.html, whose
+// instrument names are the same as the other pages', are e.g. cello2012.
+func shortInstrumentName(page Page) string {
+	name := page.Instrument
+	switch {
+	case page.Era == "post-2012":
+		name += "12"
+	case strings.HasSuffix(strings.ToLower(strings.TrimSuffix(path.Base(page.URL), ".html")), "2012"):
+		name += "2012"
+	}
+	return name
+}
+
+// shortSampleName returns a short lowercase name for a file from its
+// metadata, e.g. arco-ff-sula-a3.
+func shortSampleName(f File) string {
+	var parts []string
+
+	for _, part := range append([]string{f.Articulation, f.Dynamic}, f.Extra...) {
+		if len(part) > 0 {
+			parts = append(parts, strings.ToLower(strings.Replace(part, ".", "-", -1)))
+		}
+	}
+	if len(f.Note) > 0 {
+		parts = append(parts, strings.ToLower(f.Note))
+	}
+	if len(parts) == 0 {
+		return strings.ToLower(f.Instrument)
+	}
+	return strings.Join(parts, "-")
+}
+
+// uniqueName returns name, or name with a counter appended if it has
+// already been used.
+func uniqueName(used map[string]int, name string) string {
+	used[name]++
+	if n := used[name]; n > 1 {
+		return name + "-" + strconv.Itoa(n)
+	}
+	return name
+}
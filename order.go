@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Orders the files of each page can be downloaded in.
+const (
+	OrderPage     = "page"           // The order of the links on the page.
+	OrderSmallest = "smallest-first" // Smallest files first, e.g. on flaky connections.
+	OrderLargest  = "largest-first"  // Largest files first.
+	OrderAlpha    = "alpha"          // By file name.
+	OrderRandom   = "random"
+)
+
+// orderDownloads sorts the downloads of a page in the -order. Sizes are
+// found with a request for the first byte of each file. Files whose size
+// can't be found go last.
+func (app *App) orderDownloads(ctx context.Context, downloads []string) []string {
+	sorted := append([]string(nil), downloads...)
+
+	switch app.Order {
+	case OrderAlpha:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return strings.ToLower(path.Base(sorted[i])) < strings.ToLower(path.Base(sorted[j]))
+		})
+	case OrderRandom:
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		r.Shuffle(len(sorted), func(i, j int) { sorted[i], sorted[j] = sorted[j], sorted[i] })
+	case OrderSmallest, OrderLargest:
+		sizes := app.sizes(ctx, sorted)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			a, b := sizes[sorted[i]], sizes[sorted[j]]
+			switch {
+			case a < 0 || b < 0:
+				return b < 0 && a >= 0
+			case app.Order == OrderLargest:
+				return a > b
+			}
+			return a < b
+		})
+	}
+	return sorted
+}
+
+// sizes returns the size of every file, -1 if it isn't known.
+func (app *App) sizes(ctx context.Context, downloads []string) map[string]int64 {
+	var (
+		mu    sync.Mutex
+		sizes = make(map[string]int64, len(downloads))
+		g     errgroup.Group
+	)
+	for _, dl := range downloads {
+		dl := dl
+		g.Go(func() error {
+			size := app.size(ctx, dl)
+			mu.Lock()
+			sizes[dl] = size
+			mu.Unlock()
+			return nil
+		})
+	}
+	_ = g.Wait() // The goroutines don't fail.
+	return sizes
+}
+
+// size returns the size of a file, -1 if it isn't known.
+func (app *App) size(ctx context.Context, url string) int64 {
+	if err := app.limiter.Acquire(ctx); err != nil {
+		return -1
+	}
+	defer app.limiter.Release()
+
+	resp, err := app.getWith(ctx, url, http.Header{"Range": {"bytes=0-0"}})
+	if err != nil {
+		return -1
+	}
+	_ = resp.Body.Close() // Best effort.
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp.ContentLength
+	}
+	// e.g. bytes 0-0/1234
+	cr := resp.Header.Get("Content-Range")
+	n, err := strconv.ParseInt(cr[strings.LastIndex(cr, "/")+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// turn makes the fetchers of a page start their downloads in order: each
+// waits for its turn before acquiring a transfer slot and passes the turn
+// on once it has one, or when it doesn't need one.
+type turn struct {
+	wait <-chan struct{}
+	next chan struct{}
+	once sync.Once
+}
+
+// newTurns returns n turns, in order.
+func newTurns(n int) []*turn {
+	var (
+		turns = make([]*turn, n)
+		first = make(chan struct{})
+		wait  = first
+	)
+	close(first)
+
+	for i := range turns {
+		turns[i] = &turn{wait: wait, next: make(chan struct{})}
+		wait = turns[i].next
+	}
+	return turns
+}
+
+// Wait waits for the turn.
+func (t *turn) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.wait:
+		return nil
+	}
+}
+
+// Pass passes the turn on to the next fetcher, once the turn has come.
+func (t *turn) Pass() {
+	t.once.Do(func() {
+		<-t.wait
+		close(t.next)
+	})
+}
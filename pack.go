@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Changes between packs, see PackChange.
+const (
+	PackAdded    = "added"
+	PackRemoved  = "removed"
+	PackChanged  = "changed"  // Same path, different contents.
+	PackMoved    = "moved"    // Same contents, different path.
+	PackMetadata = "metadata" // Same path and contents, different URL.
+)
+
+// Pack is a built pack: a directory of files, e.g. an output directory or
+// exported instruments, or a manifest of one.
+type Pack struct {
+	Manifest
+	Dir string // Of the files, empty for a manifest.
+}
+
+// PackChange is a difference between two packs.
+type PackChange struct {
+	Change string `json:"change"`
+	Path   string `json:"path"`
+	From   string `json:"from,omitempty"` // The path in the first pack, if it was moved.
+	Size   int64  `json:"size"`
+	Was    int64  `json:"was,omitempty"` // The size in the first pack, if it changed.
+	URL    string `json:"url,omitempty"`
+}
+
+// LoadPack loads a pack from a directory, checksumming its files, or a
+// manifest. The URL's of the files of a directory come from its state
+// database, if it has one.
+func LoadPack(name string, withMD5 bool) (Pack, error) {
+	return loadPack(name, withMD5, nil)
+}
+
+// loadPack loads a pack like LoadPack, leaving out the files of a directory
+// that include, unless it is nil, returns false for, by path relative to it.
+func loadPack(name string, withMD5 bool, include func(rel string) bool) (Pack, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return Pack{}, errors.Wrap(err, "reading pack")
+	}
+	if !info.IsDir() {
+		m, err := LoadManifest(name)
+		return Pack{Manifest: m}, err
+	}
+	urls, err := stateURLs(name)
+	if err != nil {
+		return Pack{}, err
+	}
+	p := Pack{Manifest: Manifest{Created: info.ModTime().UTC()}, Dir: name}
+
+	err = filepath.Walk(name, func(file string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case !info.Mode().IsRegular():
+			return nil
+		case strings.HasPrefix(info.Name(), ".iowa-"), strings.HasSuffix(info.Name(), partSuffix):
+			return nil // Not part of the pack.
+		}
+		rel, err := filepath.Rel(name, file)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); include != nil && !include(rel) {
+			return nil
+		}
+		c, err := hashFile(file, withMD5)
+		if err != nil {
+			return err
+		}
+		p.Files = append(p.Files, ManifestFile{Path: rel, URL: urls[rel], Size: c.Size, SHA256: c.SHA256, MD5: c.MD5})
+		return nil
+	})
+	sort.Slice(p.Files, func(i, j int) bool { return p.Files[i].Path < p.Files[j].Path })
+	return p, errors.Wrap(err, "reading pack "+name)
+}
+
+// sameContents returns true if two files have the same contents, by
+// checksum if both have one, by size otherwise.
+func sameContents(a, b ManifestFile) bool {
+	if len(a.SHA256) > 0 && len(b.SHA256) > 0 {
+		return a.SHA256 == b.SHA256
+	}
+	return a.Size == b.Size
+}
+
+// DiffPacks returns the changes from pack a to pack b, by path. Files that
+// only one of them has but that are identical to each other are moved.
+func DiffPacks(a, b Pack) []PackChange {
+	var (
+		changes []PackChange
+		inA     = map[string]ManifestFile{}
+		inB     = map[string]ManifestFile{}
+		removed = map[string][]ManifestFile{} // Files only a has, by checksum.
+	)
+	for _, f := range a.Files {
+		inA[f.Path] = f
+	}
+	for _, f := range b.Files {
+		inB[f.Path] = f
+	}
+	for _, f := range a.Files {
+		if _, ok := inB[f.Path]; !ok && len(f.SHA256) > 0 {
+			removed[f.SHA256] = append(removed[f.SHA256], f)
+		}
+	}
+	moved := map[string]bool{} // Paths in a.
+
+	for _, f := range b.Files {
+		old, ok := inA[f.Path]
+		switch {
+		case !ok && len(removed[f.SHA256]) > 0:
+			from := removed[f.SHA256][0]
+			removed[f.SHA256] = removed[f.SHA256][1:]
+			moved[from.Path] = true
+			changes = append(changes, PackChange{Change: PackMoved, Path: f.Path, From: from.Path, Size: f.Size, URL: f.URL})
+		case !ok:
+			changes = append(changes, PackChange{Change: PackAdded, Path: f.Path, Size: f.Size, URL: f.URL})
+		case !sameContents(old, f):
+			changes = append(changes, PackChange{Change: PackChanged, Path: f.Path, Size: f.Size, Was: old.Size, URL: f.URL})
+		case old.URL != f.URL && len(old.URL) > 0 && len(f.URL) > 0:
+			changes = append(changes, PackChange{Change: PackMetadata, Path: f.Path, Size: f.Size, URL: f.URL})
+		}
+	}
+	for _, f := range a.Files {
+		if _, ok := inB[f.Path]; !ok && !moved[f.Path] {
+			changes = append(changes, PackChange{Change: PackRemoved, Path: f.Path, Size: f.Size, URL: f.URL})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// MergePacks merges packs into one, in order. Files identical to a file
+// already merged are left out, and files with the path of a different file
+// already merged get a new name, e.g. Cello.arco.ff.A3-2.aif. It returns
+// the merged pack and the file each of its files came from.
+func MergePacks(packs []Pack) (Manifest, []string) {
+	var (
+		m       = Manifest{Created: time.Now().UTC()}
+		sources []string
+		pages   = map[string]bool{}
+		paths   = map[string]ManifestFile{}
+		sums    = map[string]bool{}
+	)
+	for _, p := range packs {
+		for _, page := range p.Pages {
+			if !pages[page.URL] {
+				pages[page.URL] = true
+				m.Pages = append(m.Pages, page)
+			}
+		}
+	Files:
+		for _, f := range p.Files {
+			if len(f.SHA256) > 0 && sums[f.SHA256] {
+				continue // Identical.
+			}
+			name := f.Path
+			for n := 2; ; n++ {
+				existing, ok := paths[name]
+				if !ok {
+					break
+				}
+				if sameContents(existing, f) && len(f.SHA256) == 0 {
+					continue Files // As far as can be told without checksums.
+				}
+				ext := path.Ext(f.Path)
+				name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(f.Path, ext), n, ext)
+			}
+			sources = append(sources, filepath.Join(p.Dir, filepath.FromSlash(f.Path)))
+			f.Path = name
+			paths[name] = f
+			sums[f.SHA256] = true
+			m.Files = append(m.Files, f)
+		}
+	}
+	return m, sources
+}
+
+// pack runs the pack command:
+//
+//	iowa pack diff A B            lists the changes from pack A to pack B
+//	iowa pack merge A B...        merges packs into -pack-dir
+//
+// Packs are directories or manifests. Manifests are merged into a manifest
+// written to stdout instead.
+func (app *App) pack(ctx context.Context) error {
+	switch {
+	case app.Action == "diff" && len(app.Args) == 2:
+		return app.diffPacks(app.Args[0], app.Args[1])
+	case app.Action == "merge" && len(app.Args) >= 2:
+		return app.mergePacks(ctx, app.Args)
+	}
+	return withKind(FailureConfig, errors.New("usage: iowa pack diff A B | merge A B..."))
+}
+
+func (app *App) loadPacks(names []string) ([]Pack, error) {
+	packs := make([]Pack, len(names))
+	for i, name := range names {
+		p, err := LoadPack(name, app.MD5)
+		if err != nil {
+			return nil, withKind(FailureConfig, err)
+		}
+		packs[i] = p
+	}
+	return packs, nil
+}
+
+func (app *App) diffPacks(a, b string) error {
+	packs, err := app.loadPacks([]string{a, b})
+	if err != nil {
+		return err
+	}
+	var (
+		changes = DiffPacks(packs[0], packs[1])
+		rows    = make([][]string, len(changes))
+		counts  = map[string]int{}
+	)
+	for i, c := range changes {
+		detail := FormatBytes(c.Size)
+		switch c.Change {
+		case PackMoved:
+			detail = "from " + c.From
+		case PackChanged:
+			detail = FormatBytes(c.Was) + " to " + FormatBytes(c.Size)
+		case PackMetadata:
+			detail = "url " + c.URL
+		}
+		rows[i] = []string{c.Change, c.Path, detail}
+		counts[c.Change]++
+	}
+	if changes == nil {
+		changes = []PackChange{}
+	}
+	if err := app.printList(os.Stdout, changes, []string{"CHANGE", "PATH", "DETAIL"}, rows); err != nil {
+		return err
+	}
+	app.term.Status(StatusOK, "%d added, %d removed, %d changed, %d moved, %d with new metadata",
+		counts[PackAdded], counts[PackRemoved], counts[PackChanged], counts[PackMoved], counts[PackMetadata])
+	return nil
+}
+
+func (app *App) mergePacks(ctx context.Context, names []string) error {
+	packs, err := app.loadPacks(names)
+	if err != nil {
+		return err
+	}
+	dirs := 0
+	for _, p := range packs {
+		if len(p.Dir) > 0 {
+			dirs++
+		}
+	}
+	if dirs > 0 && dirs < len(packs) {
+		return withKind(FailureConfig, errors.New("packs to merge must be all directories or all manifests"))
+	}
+	m, sources := MergePacks(packs)
+
+	if dirs == 0 {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return errors.Wrap(enc.Encode(m), "writing manifest")
+	}
+	total := 0
+	for _, p := range packs {
+		total += len(p.Files)
+	}
+	for i, f := range m.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dst := filepath.Join(app.PackDir, filepath.FromSlash(f.Path))
+		if err := publishFile(sources[i], dst); err != nil {
+			return errors.Wrap(err, "merging "+sources[i])
+		}
+		if !strings.HasSuffix("/"+filepath.ToSlash(sources[i]), "/"+f.Path) {
+			app.term.Status(StatusWarn, "%s: another pack has a different file there, merged as %s", sources[i], f.Path)
+		}
+	}
+	app.term.Status(StatusOK, "%s: %d files, %d duplicates left out", app.PackDir, len(m.Files), total-len(m.Files))
+	return nil
+}
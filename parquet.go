@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// parquetMagic starts and ends Parquet files.
+const parquetMagic = "PAR1"
+
+// Parquet physical types, converted types, repetition and encodings, see
+// https://github.com/apache/parquet-format.
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetRequired = 0
+	parquetPlain    = 0
+	parquetRLE      = 3
+)
+
+// Thrift compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	fingerprintType = reflect.TypeOf(Fingerprint(nil))
+)
+
+// parquetColumn is a column of a Parquet file, written from a field of the
+// records, found by index through embedded and nested structs.
+type parquetColumn struct {
+	name      string
+	index     []int
+	typ       int32
+	converted int32 // -1 if there is none.
+	data      bytes.Buffer
+	bits      []bool // Of a boolean column, which are bit packed at the end.
+}
+
+// parquetColumns returns the columns for the exported fields of a struct
+// type, by their JSON names. Embedded structs are flattened, and nested
+// structs, or pointers to them, get their field's name as a prefix, e.g.
+// analysis_f0. Slices of strings and numbers are joined with commas, and
+// fields of other types, e.g. slices of structs, are left out.
+func parquetColumns(t reflect.Type, prefix string, index []int) []*parquetColumn {
+	var cols []*parquetColumn
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 && !f.Anonymous {
+			continue // Unexported.
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = strings.ToLower(f.Name)
+		}
+		var (
+			path = append(append([]int(nil), index...), i)
+			ft   = f.Type
+		)
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		col := &parquetColumn{name: prefix + name, index: path, converted: -1}
+
+		switch {
+		case ft == timeType:
+			col.typ, col.converted = parquetInt64, parquetTimestampMillis
+		case ft == fingerprintType:
+			col.typ = parquetByteArray
+		case ft.Kind() == reflect.Struct && f.Anonymous && len(f.Tag.Get("json")) == 0:
+			cols = append(cols, parquetColumns(ft, prefix, path)...)
+			continue
+		case ft.Kind() == reflect.Struct:
+			cols = append(cols, parquetColumns(ft, prefix+name+"_", path)...)
+			continue
+		case ft.Kind() == reflect.String:
+			col.typ, col.converted = parquetByteArray, parquetUTF8
+		case ft.Kind() == reflect.Bool:
+			col.typ = parquetBoolean
+		case ft.Kind() >= reflect.Int && ft.Kind() <= reflect.Uint64:
+			col.typ = parquetInt64
+		case ft.Kind() == reflect.Float32 || ft.Kind() == reflect.Float64:
+			col.typ = parquetDouble
+		case ft.Kind() == reflect.Slice && isScalar(ft.Elem().Kind()):
+			col.typ, col.converted = parquetByteArray, parquetUTF8
+		default:
+			continue
+		}
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+func isScalar(k reflect.Kind) bool {
+	return k == reflect.String || k == reflect.Bool || k >= reflect.Int && k <= reflect.Float64
+}
+
+// add adds the value of the column's field of a record, the zero value if
+// a pointer on the way to it is nil.
+func (col *parquetColumn) add(record reflect.Value) {
+	v := record
+	for _, i := range col.index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v = reflect.Value{}
+				break
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	if v.IsValid() && v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v = reflect.Value{}
+		} else {
+			v = v.Elem()
+		}
+	}
+	var b [8]byte
+
+	switch col.typ {
+	case parquetBoolean:
+		col.bits = append(col.bits, v.IsValid() && v.Bool())
+	case parquetInt64:
+		var n int64
+		switch {
+		case !v.IsValid():
+		case col.converted == parquetTimestampMillis:
+			if t := v.Interface().(time.Time); !t.IsZero() {
+				n = t.UnixNano() / int64(time.Millisecond)
+			}
+		case v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uint64:
+			n = int64(v.Uint())
+		default:
+			n = v.Int()
+		}
+		binary.LittleEndian.PutUint64(b[:], uint64(n))
+		col.data.Write(b[:])
+	case parquetDouble:
+		var f float64
+		if v.IsValid() {
+			f = v.Float()
+		}
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+		col.data.Write(b[:])
+	case parquetByteArray:
+		var s []byte
+		switch {
+		case !v.IsValid():
+		case v.Type() == fingerprintType:
+			for _, code := range v.Interface().(Fingerprint) {
+				binary.LittleEndian.PutUint32(b[:4], code)
+				s = append(s, b[:4]...)
+			}
+		case v.Kind() == reflect.Slice:
+			strs := make([]string, v.Len())
+			for i := range strs {
+				strs[i] = scalarString(v.Index(i))
+			}
+			s = []byte(strings.Join(strs, ","))
+		default:
+			s = []byte(v.String())
+		}
+		binary.LittleEndian.PutUint32(b[:4], uint32(len(s)))
+		col.data.Write(b[:4])
+		col.data.Write(s)
+	}
+}
+
+func scalarString(v reflect.Value) string {
+	switch k := v.Kind(); {
+	case k == reflect.String:
+		return v.String()
+	case k == reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case k >= reflect.Int && k <= reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case k >= reflect.Uint && k <= reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+	return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+}
+
+// page returns the PLAIN encoded values of the column.
+func (col *parquetColumn) page() []byte {
+	if col.typ != parquetBoolean {
+		return col.data.Bytes()
+	}
+	packed := make([]byte, (len(col.bits)+7)/8)
+	for i, bit := range col.bits {
+		if bit {
+			packed[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return packed
+}
+
+// WriteParquet writes a slice of structs, e.g. the analyses of files or the
+// documents of the search index, as a Parquet file of a row group with a
+// column for each field, see parquetColumns, that pandas, DuckDB and
+// others can load. Every column is required and uncompressed.
+func WriteParquet(w io.Writer, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return errors.New("parquet needs a list of records")
+	}
+	var (
+		et    = rv.Type().Elem()
+		plain = et.Kind() != reflect.Struct && !(et.Kind() == reflect.Ptr && et.Elem().Kind() == reflect.Struct)
+		cols  []*parquetColumn
+	)
+	if plain {
+		if et.Kind() != reflect.String {
+			return errors.New("parquet needs a list of records")
+		}
+		cols = []*parquetColumn{{name: "value", typ: parquetByteArray, converted: parquetUTF8}}
+	} else {
+		if et.Kind() == reflect.Ptr {
+			et = et.Elem()
+		}
+		cols = parquetColumns(et, "", nil)
+	}
+	if len(cols) == 0 {
+		return errors.New("parquet needs records with fields")
+	}
+	names := map[string]int{}
+	for _, col := range cols {
+		if names[col.name]++; names[col.name] > 1 {
+			col.name += "_" + strconv.Itoa(names[col.name]) // e.g. of embedded structs with the same fields.
+		}
+	}
+	for i := 0; i < rv.Len(); i++ {
+		for _, col := range cols {
+			col.add(rv.Index(i))
+		}
+	}
+	var (
+		buf     = bytes.NewBufferString(parquetMagic)
+		rows    = int64(rv.Len())
+		offsets = make([]int64, len(cols))
+		sizes   = make([]int64, len(cols))
+	)
+	if rows > 0 {
+		for i, col := range cols {
+			data := col.page()
+
+			var h thrift
+			h.i32(1, 0) // DATA_PAGE
+			h.i32(2, int32(len(data)))
+			h.i32(3, int32(len(data)))
+			h.begin(5)
+			h.i32(1, int32(rows))
+			h.i32(2, parquetPlain)
+			h.i32(3, parquetRLE)
+			h.i32(4, parquetRLE)
+			h.end()
+			h.stop()
+
+			offsets[i], sizes[i] = int64(buf.Len()), int64(h.buf.Len()+len(data))
+			buf.Write(h.buf.Bytes())
+			buf.Write(data)
+		}
+	}
+	var m thrift
+	m.i32(1, 1) // Version.
+	m.list(2, thriftStruct, len(cols)+1)
+	m.elem()
+	m.binary(4, "schema")
+	m.i32(5, int32(len(cols)))
+	m.end()
+	for _, col := range cols {
+		m.elem()
+		m.i32(1, col.typ)
+		m.i32(3, parquetRequired)
+		m.binary(4, col.name)
+		if col.converted >= 0 {
+			m.i32(6, col.converted)
+		}
+		m.end()
+	}
+	m.i64(3, rows)
+	if rows > 0 {
+		var total int64
+		for _, size := range sizes {
+			total += size
+		}
+		m.list(4, thriftStruct, 1)
+		m.elem()
+		m.list(1, thriftStruct, len(cols))
+		for i, col := range cols {
+			m.elem()
+			m.i64(2, offsets[i])
+			m.begin(3)
+			m.i32(1, col.typ)
+			m.list(2, thriftI32, 1)
+			m.varint(zigzag(parquetPlain))
+			m.list(3, thriftBinary, 1)
+			m.varint(uint64(len(col.name)))
+			m.buf.WriteString(col.name)
+			m.i32(4, 0) // UNCOMPRESSED
+			m.i64(5, rows)
+			m.i64(6, sizes[i])
+			m.i64(7, sizes[i])
+			m.i64(9, offsets[i])
+			m.end()
+			m.end()
+		}
+		m.i64(2, total)
+		m.i64(3, rows)
+		m.end()
+	} else {
+		m.list(4, thriftStruct, 0)
+	}
+	m.binary(6, "iowa")
+	m.stop()
+
+	buf.Write(m.buf.Bytes())
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(m.buf.Len()))
+	buf.Write(n[:])
+	buf.WriteString(parquetMagic)
+
+	_, err := w.Write(buf.Bytes())
+	return errors.Wrap(err, "writing parquet")
+}
+
+// thrift writes structs in the Thrift compact protocol, which Parquet's
+// metadata is in.
+type thrift struct {
+	buf   bytes.Buffer
+	last  int16   // ID of the last field of the struct being written.
+	stack []int16 // Of last, for the structs being written.
+}
+
+func zigzag(n int64) uint64 {
+	return uint64((n << 1) ^ (n >> 63))
+}
+
+func (t *thrift) varint(u uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutUvarint(b[:], u)])
+}
+
+func (t *thrift) field(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	t.last = id
+}
+
+func (t *thrift) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thrift) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thrift) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+// list starts a list field of n elements.
+func (t *thrift) list(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elem)
+	t.varint(uint64(n))
+}
+
+// begin starts a struct field, which end ends.
+func (t *thrift) begin(id int16) {
+	t.field(id, thriftStruct)
+	t.elem()
+}
+
+// elem starts a struct element of a list, which end ends.
+func (t *thrift) elem() {
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thrift) end() {
+	t.stop()
+	t.last, t.stack = t.stack[len(t.stack)-1], t.stack[:len(t.stack)-1]
+}
+
+// stop ends the struct being written.
+func (t *thrift) stop() {
+	t.buf.WriteByte(0)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// partSuffix is added to the name of a file while it is being downloaded,
+// so that a crashed run never leaves a truncated file behind under its
+// real name.
+const partSuffix = ".part"
+
+// overwritePart returns the partial file of the nth download of the same
+// path with ConflictOverwrite, e.g. Cello.arco.ff.A3.aif.2.part, which is
+// never resumed.
+func overwritePart(name string, n int) string {
+	return name + "." + strconv.Itoa(n) + partSuffix
+}
+
+// partialTarget returns the file a partial file is downloaded to, and
+// whether it can be resumed, i.e. isn't an overwritePart.
+func partialTarget(part string) (string, bool) {
+	name := strings.TrimSuffix(part, partSuffix)
+	if ext := filepath.Ext(name); len(ext) > 1 {
+		if _, err := strconv.Atoi(ext[1:]); err == nil {
+			return strings.TrimSuffix(name, ext), false
+		}
+	}
+	return name, true
+}
+
+// What to do with the partial files of earlier runs.
+const (
+	PartialResume = "resume" // Resume the download if the file is downloaded again.
+	PartialRemove = "remove" // Remove them before downloading anything.
+)
+
+// cleanPartials finds the partial files left under root by runs that
+// crashed and reports them, removing them unless they are to be resumed.
+// Those of overwritten downloads, see overwritePart, are always removed.
+func (app *App) cleanPartials(root string) error {
+	var (
+		parts   []string
+		skipDir = filepath.Clean(app.Pipeline.Dir)
+	)
+	err := filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case info.IsDir() && name == skipDir:
+			return filepath.SkipDir
+		case !info.IsDir() && strings.HasSuffix(name, partSuffix):
+			parts = append(parts, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "looking for partial files")
+	}
+	for _, part := range parts {
+		if _, ok := partialTarget(part); !ok {
+			if err := os.Remove(part); err != nil {
+				return errors.Wrap(err, "removing partial file")
+			}
+			app.term.Status(StatusSkip, "%s: removed stale partial file of an overwritten download", part)
+			continue
+		}
+		if app.Partial == PartialResume {
+			app.term.Status(StatusSkip, "%s: partial file from an earlier run, resumed if it is downloaded again", part)
+			continue
+		}
+		if err := os.Remove(part); err != nil {
+			return errors.Wrap(err, "removing partial file")
+		}
+		app.term.Status(StatusSkip, "%s: removed partial file from an earlier run", part)
+	}
+	return nil
+}
+
+// partialSize returns the size of the partial file of name left by an
+// earlier run, or zero if there is none to resume.
+func (app *App) partialSize(name string) int64 {
+	if app.Partial != PartialResume || app.Stream {
+		return 0
+	}
+	info, err := os.Stat(name + partSuffix)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
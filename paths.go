@@ -0,0 +1,231 @@
+package main
+
+import (
+	stdurl "net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxNameLength is the longest file name most file systems allow, in bytes.
+const maxNameLength = 255
+
+// windowsReserved are the device names Windows reserves, with or without
+// an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Layout is how downloaded files are arranged in the output directory.
+type Layout string
+
+// Layouts.
+const (
+	LayoutMirror       Layout = "mirror"        // The path of each file on the server.
+	LayoutFlat         Layout = "flat"          // Every file in the output directory.
+	LayoutByInstrument Layout = "by-instrument" // A directory per instrument page, e.g. MISCello2012.
+	LayoutByNote       Layout = "by-note"       // <instrument>/<dynamic>/<note> from the file name, e.g. Cello/ff/A3.arco.sulA.aif.
+)
+
+// ParseLayout parses a layout.
+func ParseLayout(s string) (Layout, error) {
+	switch layout := Layout(s); layout {
+	case LayoutMirror, LayoutFlat, LayoutByInstrument, LayoutByNote:
+		return layout, nil
+	}
+	return "", errors.Errorf("unknown layout %q (flat, mirror, by-instrument or by-note)", s)
+}
+
+// Path returns the path, relative to the output directory, that a file
+// downloaded from an instrument page is written to, given its LocalPath
+// and the metadata parsed from it.
+//
+// The by-note layout keeps the articulation and anything else in the name
+// after the note so that names stay unique, e.g. A3.arco.sulA.aif. Files
+// without a dynamic or note are written to the instrument's directory.
+func (l Layout) Path(page Page, name string, s Sample) string {
+	switch l {
+	case LayoutFlat:
+		return path.Base(name)
+	case LayoutByInstrument:
+		return path.Join(SanitizeName(Instrument{Page: page}.Name()), path.Base(name))
+	case LayoutByNote:
+		inst := SanitizeName(s.Instrument)
+		if len(s.Dynamic) == 0 || len(s.Note) == 0 {
+			return path.Join(inst, path.Base(name))
+		}
+		note := s.Note
+		if s.HighNote != s.Note {
+			note += s.HighNote
+		}
+		fields := append([]string{note}, nonEmpty(s.Articulation)...)
+		fields = append(fields, s.Extra...)
+		return path.Join(inst, s.Dynamic, SanitizeName(strings.Join(fields, ".")+path.Ext(name)))
+	}
+	return name
+}
+
+// LocalPath returns the path, relative to the output directory, that a
+// downloaded file is written to: the path of its URL with every segment
+// unescaped, e.g. sound files/MIS/Strings/cello/Cello.arco.ff.sulA.A3.stereo.aif
+// for .../sound%20files/MIS/..., and made safe for Windows. Segments that
+// would escape the output directory are rejected.
+func LocalPath(location string) (string, error) {
+	u, err := stdurl.Parse(location)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing url")
+	}
+	var segments []string
+
+	for _, seg := range strings.Split(strings.TrimPrefix(u.EscapedPath(), "/"), "/") {
+		name, err := stdurl.PathUnescape(seg)
+		if err != nil {
+			return "", errors.Wrap(err, "unescaping "+seg)
+		}
+		if name == "" || name == "." || name == ".." {
+			return "", errors.Errorf("unsafe path segment %q in %s", name, location)
+		}
+		segments = append(segments, SanitizeName(name))
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// SanitizeName makes a file name safe on every platform: characters that
+// are invalid on Windows (and path separators) are replaced with _,
+// trailing dots and spaces are dropped, reserved device names get a _
+// prefix and names are shortened to maxNameLength, keeping the extension.
+// Long paths are handled by the os package on Windows.
+func SanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	name = strings.TrimRight(name, ". ")
+	if len(name) == 0 {
+		return "_"
+	}
+	if base := strings.SplitN(name, ".", 2)[0]; windowsReserved[strings.ToUpper(strings.TrimSpace(base))] {
+		name = "_" + name
+	}
+	if len(name) > maxNameLength {
+		ext := path.Ext(name)
+		if len(ext) > maxNameLength/2 {
+			ext = ""
+		}
+		name = truncateUTF8(strings.TrimSuffix(name, ext), maxNameLength-len(ext)) + ext
+	}
+	return name
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Unicode normalization forms for file names.
+const (
+	NamesNFC  = "nfc"
+	NamesNFD  = "nfd"
+	NamesNone = "none"
+)
+
+// NormalizeName converts a file name to a Unicode normalization form, so
+// that names that look the same are the same bytes on every platform.
+func NormalizeName(name, form string) string {
+	switch form {
+	case NamesNFC:
+		return norm.NFC.String(name)
+	case NamesNFD:
+		return norm.NFD.String(name)
+	}
+	return name
+}
+
+// Conflict is what to do when two downloads map to the same local path.
+type Conflict string
+
+// Conflict strategies.
+const (
+	ConflictError     Conflict = "error"     // Fail the later download.
+	ConflictSkip      Conflict = "skip"      // Keep the earlier download and skip the later one.
+	ConflictOverwrite Conflict = "overwrite" // Keep whichever download is written last.
+	ConflictRename    Conflict = "rename"    // Add a number to the later name, e.g. Cello.arco.ff.A3.2.aif.
+)
+
+// ParseConflict parses a conflict strategy.
+func ParseConflict(s string) (Conflict, error) {
+	switch c := Conflict(s); c {
+	case ConflictError, ConflictSkip, ConflictOverwrite, ConflictRename:
+		return c, nil
+	}
+	return "", errors.Errorf("unknown conflict strategy %q (skip, overwrite, rename or error)", s)
+}
+
+// Claims records the local paths written by a run, ignoring case since
+// some file systems do, and resolves conflicts between them.
+type Claims struct {
+	mu       sync.Mutex
+	strategy Conflict
+	paths    map[string]int    // Claims of each path.
+	sources  map[string]string // URL of the first claim of each path.
+}
+
+// NewClaims creates a set of claims that resolves conflicts with strategy.
+func NewClaims(strategy Conflict) *Claims {
+	return &Claims{strategy: strategy, paths: map[string]int{}, sources: map[string]string{}}
+}
+
+// Claim claims a path for the download of a URL and returns the path to
+// write to, or an empty string if the download should be skipped, and the
+// partial file to write it to first. A path claimed again for the same URL,
+// e.g. of a page listed in two sections, is the same file and is skipped
+// whatever the strategy. With ConflictOverwrite every download of the same
+// path has its own partial file, see overwritePart, so that
+// they can be written at the same time and the last one renamed wins.
+func (c *Claims) Claim(name, url string) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := strings.ToLower(name)
+	if c.paths[key] == 0 {
+		c.paths[key], c.sources[key] = 1, url
+		return name, name + partSuffix, nil
+	}
+	if c.sources[key] == url {
+		return "", "", nil
+	}
+	ext := path.Ext(name)
+
+	switch c.strategy {
+	case ConflictSkip:
+		return "", "", nil
+	case ConflictOverwrite:
+		c.paths[key]++
+		return name, overwritePart(name, c.paths[key]), nil
+	case ConflictRename:
+		for n := 2; ; n++ {
+			renamed := strings.TrimSuffix(name, ext) + "." + strconv.Itoa(n) + ext
+			if rkey := strings.ToLower(renamed); c.paths[rkey] == 0 {
+				c.paths[rkey], c.sources[rkey] = 1, url
+				return renamed, renamed + partSuffix, nil
+			}
+		}
+	}
+	return "", "", errors.Errorf("%s conflicts with another download (see -on-conflict)", name)
+}
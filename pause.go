@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+)
+
+// watchPause pauses transfers on SIGUSR1 and resumes them on SIGUSR2 until
+// ctx is done. Unlike outside the -active-hours, transfers that are
+// running pause too.
+func (app *App) watchPause(ctx context.Context) {
+	var (
+		pause  = make(chan os.Signal, 1)
+		resume = make(chan os.Signal, 1)
+	)
+	if !notifyPause(pause, resume) {
+		return
+	}
+	defer signal.Stop(pause)
+	defer signal.Stop(resume)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pause:
+			if app.paused.Close() {
+				app.term.Status(StatusWarn, "paused, resume with kill -USR2 %d", os.Getpid())
+			}
+		case <-resume:
+			if app.paused.Open() {
+				app.term.Status(StatusOK, "resumed")
+			}
+		}
+	}
+}
+
+// gateReader is a reader that blocks while any of its gates is closed.
+type gateReader struct {
+	ctx   context.Context
+	gates []*Gate
+	r     io.Reader
+}
+
+func (gr gateReader) Read(p []byte) (int, error) {
+	for i := 0; i < len(gr.gates); {
+		if err := gr.gates[i].Wait(gr.ctx); err != nil {
+			return 0, err
+		}
+		i++
+		// Start over if an earlier gate closed while waiting on this one.
+		for j := 0; j < i; j++ {
+			if !gr.gates[j].IsOpen() {
+				i = 0
+			}
+		}
+	}
+	return gr.r.Read(p)
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"os"
+)
+
+// notifyPause returns false, Windows has no SIGUSR1 or SIGUSR2.
+func notifyPause(pause, resume chan<- os.Signal) bool {
+	return false
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PureData exports a Pd patch per instrument that loads every sample into
+// arrays and plays the sample nearest to each incoming MIDI note, retuned
+// to the note. The MIDI channel picks the articulation and the velocity
+// picks the dynamic.
+type PureData struct {
+	fill     Fill
+	velocity Velocity
+}
+
+// NewPureData creates a Pure Data exporter.
+func NewPureData(conf Config, engine Engine) Exporter {
+	return PureData{fill: conf.Fill, velocity: conf.Velocity}
+}
+
+// Export writes <instrument>.pd.
+func (pd PureData) Export(inst Instrument) error {
+	layers := Layers(inst.Files)
+	if len(layers) == 0 {
+		return nil
+	}
+	var (
+		p      pdPatch
+		prefix = strings.TrimPrefix(scVariable(shortInstrumentName(inst.Page)), "~")
+		table  = func(name string) string { return prefix + "_" + name }
+		k      int
+		sample []int // Sample number of each (layer, MIDI note).
+		base   []int // Sampled note of each (layer, MIDI note).
+	)
+	p.comment(10, 10, fmt.Sprintf("%s (%s/%s) generated by iowa from %s", inst.Page.Instrument, inst.Page.Era, inst.Page.Section, inst.Page.URL))
+	p.comment(10, 30, "MIDI channel picks the articulation and velocity picks the dynamic")
+
+	// Load every sample into a pair of arrays.
+	loadbang := p.object(10, 60, "loadbang")
+	for _, layer := range layers {
+		first := k
+		for _, f := range layer.Files {
+			var (
+				y     = 90 + 30*k
+				left  = table(fmt.Sprintf("%d_l", k))
+				right = table(fmt.Sprintf("%d_r", k))
+				read  = "read -resize " + pdEscape(f.Path) + " " + left + " " + right
+			)
+			if f.Channels == "mono" {
+				read = "read -resize " + pdEscape(f.Path) + " " + left + " \\, read -resize " + pdEscape(f.Path) + " " + right
+			}
+			p.object(600, y, "table "+left)
+			p.object(760, y, "table "+right)
+			msg := p.message(10, y, read)
+			sf := p.object(400, y, "soundfiler")
+			store := p.message(480, y, fmt.Sprintf("\\; %s %d \\$1", table("len"), k))
+			p.connect(loadbang, 0, msg, 0)
+			p.connect(msg, 0, sf, 0)
+			p.connect(sf, 0, store, 0)
+			k++
+		}
+		for _, idx := range layer.KeyMap(pd.fill) {
+			sample = append(sample, first+idx)
+			base = append(base, layer.Files[idx].MIDI)
+		}
+	}
+	y := 120 + 30*k
+
+	// Lookup tables.
+	p.object(600, y, fmt.Sprintf("table %s %d", table("len"), k))
+	p.object(600, y+30, fmt.Sprintf("table %s %d", table("map"), len(sample)))
+	p.object(600, y+60, fmt.Sprintf("table %s %d", table("base"), len(base)))
+	p.object(600, y+90, fmt.Sprintf("table %s %d", table("layer"), 16*128))
+	fill := p.message(10, y, strings.Join([]string{
+		fmt.Sprintf("\\; %s 0 %s", table("map"), pdInts(sample)),
+		fmt.Sprintf("\\; %s 0 %s", table("base"), pdInts(base)),
+		fmt.Sprintf("\\; %s 0 %s", table("layer"), pdInts(velocityLayers(layers, pd.velocity))),
+	}, " "))
+	p.connect(loadbang, 0, fill, 0)
+	y += 150
+
+	// Play the nearest sample for each note on.
+	var (
+		notein    = p.object(10, y, "notein")
+		stripnote = p.object(10, y+30, "stripnote")
+		pack      = p.object(10, y+60, "pack f f f")
+		lookup    = p.object(10, y+90, pdDollars(fmt.Sprintf("expr %s[%s[int($f3 - 1) %% 16 * 128 + $f2] * 128 + $f1] \\; pow(2 \\, ($f1 - %s[%s[int($f3 - 1) %% 16 * 128 + $f2] * 128 + $f1]) / 12) \\; $f2 / 127",
+			table("map"), table("layer"), table("base"), table("layer"))))
+		trigger  = p.object(10, y+120, "t f f f")
+		nameL    = p.object(200, y+150, "makefilename "+table("%d_l"))
+		nameR    = p.object(400, y+150, "makefilename "+table("%d_r"))
+		setL     = p.message(200, y+180, "set \\$1")
+		setR     = p.message(400, y+180, "set \\$1")
+		duration = p.object(10, y+150, pdDollars(fmt.Sprintf("expr %s[$f1] \\; %s[$f1] * 1000 / 44100 / $f2", table("len"), table("len"))))
+		ramp     = p.object(10, y+180, "pack f f")
+		start    = p.message(10, y+210, "0 \\, \\$1 \\$2")
+		vline    = p.object(10, y+240, "vline~")
+		readL    = p.object(200, y+270, "tabread4~")
+		readR    = p.object(400, y+270, "tabread4~")
+		ampL     = p.object(200, y+300, "*~")
+		ampR     = p.object(400, y+300, "*~")
+		dac      = p.object(200, y+330, "dac~")
+	)
+	p.connect(notein, 0, stripnote, 0)
+	p.connect(notein, 1, stripnote, 1)
+	p.connect(notein, 2, pack, 2)
+	p.connect(stripnote, 1, pack, 1)
+	p.connect(stripnote, 0, pack, 0)
+	p.connect(pack, 0, lookup, 0)
+	p.connect(lookup, 2, ampL, 1)
+	p.connect(lookup, 2, ampR, 1)
+	p.connect(lookup, 1, duration, 1)
+	p.connect(lookup, 0, trigger, 0)
+	p.connect(trigger, 2, nameL, 0)
+	p.connect(trigger, 1, nameR, 0)
+	p.connect(trigger, 0, duration, 0)
+	p.connect(nameL, 0, setL, 0)
+	p.connect(nameR, 0, setR, 0)
+	p.connect(setL, 0, readL, 0)
+	p.connect(setR, 0, readR, 0)
+	p.connect(duration, 1, ramp, 1)
+	p.connect(duration, 0, ramp, 0)
+	p.connect(ramp, 0, start, 0)
+	p.connect(start, 0, vline, 0)
+	p.connect(vline, 0, readL, 0)
+	p.connect(vline, 0, readR, 0)
+	p.connect(readL, 0, ampL, 0)
+	p.connect(readR, 0, ampR, 0)
+	p.connect(ampL, 0, dac, 0)
+	p.connect(ampR, 0, dac, 1)
+
+	if err := ioutil.WriteFile(inst.Name()+".pd", p.bytes(), 0644); err != nil {
+		return errors.Wrap(err, "writing pd patch")
+	}
+	return nil
+}
+
+// velocityLayers returns the layer to play for every MIDI channel and
+// velocity, indexed by (channel - 1) * 128 + velocity. Each channel plays
+// an articulation (wrapping around if there are fewer than 16), and the
+// dynamics of that articulation are split over the velocities by v.
+func velocityLayers(layers []Layer, v Velocity) []int {
+	var (
+		out  = make([]int, 16*128)
+		arts [][]int // Layer indices of each articulation.
+	)
+	for i, layer := range layers {
+		if i == 0 || layer.Articulation != layers[i-1].Articulation {
+			arts = append(arts, nil)
+		}
+		arts[len(arts)-1] = append(arts[len(arts)-1], i)
+	}
+	for ch := 0; ch < 16; ch++ {
+		var (
+			dyns   = arts[ch%len(arts)]
+			starts = v.Starts(len(dyns))
+			layer  int
+		)
+		for vel := 0; vel < 128; vel++ {
+			for layer < len(starts) && vel >= starts[layer] {
+				layer++
+			}
+			out[ch*128+vel] = dyns[layer]
+		}
+	}
+	return out
+}
+
+// pdPatch builds the text of a Pd patch.
+type pdPatch struct {
+	lines    []string
+	objects  int
+	connects []string
+}
+
+func (p *pdPatch) add(kind string, x, y int, text string) int {
+	p.lines = append(p.lines, fmt.Sprintf("#X %s %d %d %s;", kind, x, y, text))
+	p.objects++
+	return p.objects - 1
+}
+
+func (p *pdPatch) object(x, y int, text string) int  { return p.add("obj", x, y, text) }
+func (p *pdPatch) message(x, y int, text string) int { return p.add("msg", x, y, text) }
+func (p *pdPatch) comment(x, y int, text string) int {
+	return p.add("text", x, y, strings.NewReplacer(",", " \\,", ";", " \\;").Replace(text))
+}
+
+func (p *pdPatch) connect(from, outlet, to, inlet int) {
+	p.connects = append(p.connects, fmt.Sprintf("#X connect %d %d %d %d;", from, outlet, to, inlet))
+}
+
+func (p *pdPatch) bytes() []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("#N canvas 0 0 1000 800 12;\n")
+	for _, line := range append(p.lines, p.connects...) {
+		buf.WriteString(line + "\n")
+	}
+	return buf.Bytes()
+}
+
+func pdInts(ints []int) string {
+	return strings.Replace(csInts(ints), ",", "", -1)
+}
+
+// pdDollars escapes the dollar signs of expr arguments.
+func pdDollars(s string) string {
+	return strings.Replace(s, "$f", "\\$f", -1)
+}
+
+// pdEscape escapes spaces and special characters in a Pd atom.
+func pdEscape(s string) string {
+	return strings.NewReplacer(" ", "\\ ", ",", "\\,", ";", "\\;", "$", "\\$").Replace(s)
+}
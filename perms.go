@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// mkdirAll creates a directory of the downloaded tree and any parents,
+// setting the mode and owner of the ones it creates.
+func (app *App) mkdirAll(dir string) error {
+	var created []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || d == filepath.Dir(d) {
+			break
+		}
+		created = append(created, d)
+	}
+	perm := app.DirMode
+	if perm == 0 {
+		perm = os.ModePerm
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return errors.Wrap(err, "making directory")
+	}
+	for _, d := range created {
+		if err := app.own(d, app.DirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// own sets the mode of a file or directory of the downloaded tree, unless
+// it is zero, and its owner to -uid and -gid.
+func (app *App) own(name string, mode os.FileMode) error {
+	if mode != 0 {
+		if err := os.Chmod(name, mode); err != nil {
+			return errors.Wrap(err, "setting permissions")
+		}
+	}
+	if app.UID >= 0 || app.GID >= 0 {
+		if err := os.Lchown(name, app.UID, app.GID); err != nil {
+			return errors.Wrap(err, "setting owner")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pick lets the user choose instrument pages interactively, then downloads them.
+func (app *App) pick(ctx context.Context) error {
+	pages, err := app.pages()
+	if err != nil {
+		return err
+	}
+	items := make([]string, len(pages))
+	for i, page := range pages {
+		items[i] = page.String()
+	}
+	selected, err := NewPicker(items, os.Stdin, os.Stderr).Run()
+	if err != nil {
+		return errors.Wrap(err, "picking instruments")
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+	picked := make([]Page, len(selected))
+	for i, idx := range selected {
+		picked[i] = pages[idx]
+	}
+	return app.downloadPages(ctx, picked)
+}
+
+// Picker is an interactive multi-select prompt with fuzzy filtering.
+//
+// Typing text filters the list, "/" clears the filter, typing numbers
+// (e.g. "1 3 5-7") toggles the numbered items, "*" toggles every listed
+// item, and an empty line finishes. "q" cancels.
+type Picker struct {
+	items    []string
+	in       *bufio.Scanner
+	out      io.Writer
+	query    string
+	selected map[int]bool
+}
+
+// NewPicker creates a picker for items.
+func NewPicker(items []string, in io.Reader, out io.Writer) *Picker {
+	return &Picker{
+		items:    items,
+		in:       bufio.NewScanner(in),
+		out:      out,
+		selected: map[int]bool{},
+	}
+}
+
+// Run prompts until the user is done and returns the indices of the
+// selected items in order.
+func (p *Picker) Run() ([]int, error) {
+	for {
+		matches := p.matches()
+		p.print(matches)
+
+		if !p.in.Scan() {
+			if err := p.in.Err(); err != nil {
+				return nil, err
+			}
+			break
+		}
+		line := strings.TrimSpace(p.in.Text())
+
+		switch {
+		case line == "":
+			return p.selection(), nil
+		case line == "q":
+			return nil, nil
+		case line == "*":
+			for _, idx := range matches {
+				p.toggle(idx)
+			}
+		case line == "/":
+			p.query = ""
+		default:
+			if nums, ok := parseSelection(line, len(matches)); ok {
+				for _, n := range nums {
+					p.toggle(matches[n-1])
+				}
+				continue
+			}
+			p.query = line
+		}
+	}
+	return p.selection(), nil
+}
+
+func (p *Picker) matches() []int {
+	var matches []int
+
+	for i, item := range p.items {
+		if FuzzyMatch(p.query, item) {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
+
+// toggle selects an item, or deselects it if it was selected, so that
+// p.selected only has the selected items.
+func (p *Picker) toggle(idx int) {
+	if p.selected[idx] {
+		delete(p.selected, idx)
+	} else {
+		p.selected[idx] = true
+	}
+}
+
+func (p *Picker) print(matches []int) {
+	for n, idx := range matches {
+		mark := " "
+		if p.selected[idx] {
+			mark = "*"
+		}
+		_, _ = fmt.Fprintf(p.out, "%s %3d  %s\n", mark, n+1, p.items[idx])
+	}
+	_, _ = fmt.Fprintf(p.out, "%d/%d matches, %d selected (text filters, / clears the filter, numbers toggle, * toggles all, enter downloads, q quits)\n> ",
+		len(matches), len(p.items), len(p.selected))
+}
+
+func (p *Picker) selection() []int {
+	var out []int
+
+	for i := range p.items {
+		if p.selected[i] {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// FuzzyMatch returns true if the characters of query appear in s in order,
+// ignoring case.
+func FuzzyMatch(query, s string) bool {
+	s = strings.ToLower(s)
+
+	for _, r := range strings.ToLower(query) {
+		if r == ' ' {
+			continue
+		}
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+1:]
+	}
+	return true
+}
+
+// parseSelection parses a list of numbers and ranges like "1 3 5-7".
+// Every number must be between 1 and max.
+func parseSelection(line string, max int) ([]int, bool) {
+	var nums []int
+
+	for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == ',' }) {
+		lo, hi := field, field
+		if i := strings.Index(field, "-"); i > 0 {
+			lo, hi = field[:i], field[i+1:]
+		}
+		a, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, false
+		}
+		b, err := strconv.Atoi(hi)
+		if err != nil {
+			return nil, false
+		}
+		if a < 1 || b > max || a > b {
+			return nil, false
+		}
+		for n := a; n <= b; n++ {
+			nums = append(nums, n)
+		}
+	}
+	return nums, len(nums) > 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+)
+
+// maxRetune is the largest deviation in cents that -retune corrects.
+// Anything further out is more likely a bad pitch estimate than a
+// mistuned sample.
+const maxRetune = 50
+
+// Frequency returns the frequency of a MIDI note for a reference pitch of A4.
+func Frequency(midi int, a4 float64) float64 {
+	return a4 * math.Pow(2, float64(midi-69)/12)
+}
+
+// Cents returns the interval from want to got in cents.
+func Cents(got, want float64) float64 {
+	return 1200 * math.Log2(got/want)
+}
+
+// DetectPitch estimates the fundamental frequency of a, which is expected
+// to be within a semitone of expected, using the YIN difference function
+// over a window of the steady state part of the sample. It returns false
+// if there is no clear pitch.
+func DetectPitch(a *Audio, expected float64) (float64, bool) {
+	var (
+		mono   = *a
+		rate   = float64(a.SampleRate)
+		minTau = int(math.Floor(rate / (expected * math.Pow(2, 1.0/12))))
+		maxTau = int(math.Ceil(rate / (expected / math.Pow(2, 1.0/12))))
+		size   = 4 * maxTau
+	)
+	mono.Data = append([]float64(nil), a.Data...)
+	MixToMono(&mono)
+
+	// Skip the attack. The difference function reads up to x[size+maxTau].
+	start := len(mono.Data) / 5
+	if start+size+maxTau+1 > len(mono.Data) {
+		start = 0
+	}
+	if minTau < 1 || start+size+maxTau+1 > len(mono.Data) {
+		return 0, false
+	}
+	var (
+		x    = mono.Data[start:]
+		d    = make([]float64, maxTau+2)
+		sum  float64
+		best = -1
+	)
+	for tau := 1; tau < len(d); tau++ {
+		for i := 0; i < size; i++ {
+			diff := x[i] - x[i+tau]
+			d[tau] += diff * diff
+		}
+		// Cumulative mean normalized difference.
+		sum += d[tau]
+		if sum > 0 {
+			d[tau] *= float64(tau) / sum
+		}
+	}
+	for tau := minTau; tau <= maxTau; tau++ {
+		if best < 0 || d[tau] < d[best] {
+			best = tau
+		}
+	}
+	if d[best] > 0.3 {
+		return 0, false
+	}
+	// Parabolic interpolation around the minimum.
+	tau := float64(best)
+	if best > 1 && best+1 < len(d) {
+		if denom := d[best-1] - 2*d[best] + d[best+1]; denom != 0 {
+			tau += (d[best-1] - d[best+1]) / (2 * denom)
+		}
+	}
+	return rate / tau, true
+}
+
+// Retune returns a copy of a shifted in pitch by cents, by resampling it
+// and keeping its sample rate, which also changes its length.
+func Retune(a *Audio, cents float64) *Audio {
+	var (
+		ratio = math.Pow(2, -cents/1200)
+		out   = Resample(a, int(math.Round(float64(a.SampleRate)*ratio)))
+	)
+	out.SampleRate = a.SampleRate
+	return out
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// Bandwidth probe of -preflight.
+const (
+	probeTime  = 5 * time.Second // How long the probe downloads for.
+	probeFiles = 4               // The most files it downloads at once.
+)
+
+// preflight prints how many files the pages have, their total size and an
+// estimate of how long downloading them takes, measured by downloading the
+// largest of them for probeTime.
+func (app *App) preflight(ctx context.Context, pages []Page) error {
+	var downloads []string
+
+	for _, page := range pages {
+		links, err := app.scrape(ctx, page.URL)
+		if err != nil {
+			app.failures.Add(FailureScrape, page.URL, err)
+			return withKind(FailureScrape, errors.Wrap(err, "scraping audio file URL's"))
+		}
+		downloads = append(downloads, app.applyPreset(links)...)
+	}
+	var (
+		sizes   = app.sizes(ctx, downloads)
+		total   int64
+		unknown int
+	)
+	for _, dl := range downloads {
+		if sizes[dl] < 0 {
+			unknown++
+			continue
+		}
+		total += sizes[dl]
+	}
+	sort.SliceStable(downloads, func(i, j int) bool { return sizes[downloads[i]] > sizes[downloads[j]] })
+
+	bps := app.probe(ctx, downloads)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	eta := time.Duration(-1)
+	if bps > 0 {
+		eta = time.Duration(float64(total) / float64(bps) * float64(time.Second))
+	}
+	size := FormatBytes(total)
+	if unknown > 0 {
+		size += fmt.Sprintf(" and %d files of unknown size", unknown)
+	}
+	app.term.Status(StatusOK, "preflight: %d files, %s, about %s to download at %s/s",
+		len(downloads), size, FormatETA(eta), FormatBytes(bps))
+	return nil
+}
+
+// probe returns the download speed in bytes per second, measured by
+// downloading the first files for probeTime, as many at once as -jobs
+// allows up to probeFiles. It returns 0 if nothing could be downloaded.
+func (app *App) probe(ctx context.Context, downloads []string) int64 {
+	n := app.limiter.Limit()
+	if n <= 0 || n > probeFiles {
+		n = probeFiles
+	}
+	if n > len(downloads) {
+		n = len(downloads)
+	}
+	pctx, cancel := context.WithTimeout(ctx, probeTime)
+	defer cancel()
+
+	var (
+		copied int64
+		start  = time.Now()
+		g      errgroup.Group
+	)
+	for _, dl := range downloads[:n] {
+		dl := dl
+		g.Go(func() error {
+			resp, err := app.get(pctx, dl)
+			if err != nil {
+				return nil // The probe downloads what it can.
+			}
+			defer func() { _ = resp.Body.Close() }() // Best effort.
+
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := resp.Body.Read(buf)
+				atomic.AddInt64(&copied, int64(n))
+				if err != nil {
+					return nil
+				}
+			}
+		})
+	}
+	_ = g.Wait() // The goroutines don't fail.
+	return rate(copied, time.Since(start))
+}
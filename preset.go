@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+)
+
+// Presets select a subset of the files of each page.
+const (
+	PresetAll        = "all"         // Every file.
+	PresetOneDynamic = "one-dynamic" // One dynamic of each note, see oneDynamic.
+)
+
+// preferredDynamic is the dynamic PresetOneDynamic keeps when a note has it.
+const preferredDynamic = "mf"
+
+// applyPreset returns the downloads of a page, in the -collection if there
+// is one, that the -preset selects.
+func (app *App) applyPreset(downloads []string) []string {
+	downloads = app.scopeDownloads(downloads)
+	if app.Preset == PresetOneDynamic {
+		return oneDynamic(downloads)
+	}
+	return downloads
+}
+
+// oneDynamic keeps a single dynamic of every note of each articulation
+// (and string, round robin and so on): mf, or the dynamic closest to it,
+// the louder one on a tie. Files without a dynamic or note are kept. The
+// order of the downloads is kept.
+func oneDynamic(downloads []string) []string {
+	var (
+		keys = make([]string, len(downloads))
+		best = map[string]int{} // Key to the index of the best download.
+		pref = DynamicIndex(preferredDynamic)
+	)
+	distance := func(i int) (int, int) {
+		d := DynamicIndex(ParseSample(downloads[i]).Dynamic) - pref
+		if d < 0 {
+			return -d, 1 // Softer loses ties.
+		}
+		return d, 0
+	}
+	for i, dl := range downloads {
+		s := ParseSample(dl)
+		if len(s.Dynamic) == 0 || len(s.Note) == 0 {
+			continue
+		}
+		keys[i] = strings.ToLower(strings.Join(append([]string{
+			s.Instrument, s.Articulation, s.Note, s.HighNote, s.Channels,
+		}, s.Extra...), "."))
+
+		j, ok := best[keys[i]]
+		if !ok {
+			best[keys[i]] = i
+			continue
+		}
+		di, ti := distance(i)
+		dj, tj := distance(j)
+		if di < dj || (di == dj && ti < tj) {
+			best[keys[i]] = i
+		}
+	}
+	var kept []string
+	for i, dl := range downloads {
+		if len(keys[i]) == 0 || best[keys[i]] == i {
+			kept = append(kept, dl)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Pipeline is the processing applied to downloaded files. Processed files
+// are written as WAV files under Dir, mirroring the downloaded tree.
+type Pipeline struct {
+	Dir          string   `json:"dir"`
+	Rate         int      `json:"rate,omitempty"`           // Sample rate, 0 keeps the original.
+	Bits         int      `json:"bits,omitempty"`           // 16, 24 or 32, 0 keeps the original if possible.
+	Mono         bool     `json:"mono,omitempty"`           // Mix down to mono.
+	Trim         float64  `json:"trim,omitempty"`           // Trim leading and trailing audio below this dBFS level, 0 disables.
+	Normalize    *float64 `json:"normalize,omitempty"`      // Peak level in dBFS to normalize to.
+	Retune       bool     `json:"retune,omitempty"`         // Correct the tuning of samples with a note.
+	A4           float64  `json:"a4,omitempty"`             // Reference pitch for Retune in Hz.
+	Blend        int      `json:"blend,omitempty"`          // Number of blends to render between adjacent dynamics.
+	Segment      bool     `json:"segment,omitempty"`        // Split sustained samples into attack, loop and release.
+	MatchGain    bool     `json:"match_gain,omitempty"`     // Match the level of each dynamic across an instrument's range.
+	FoldDualMono bool     `json:"fold_dual_mono,omitempty"` // Make dual mono files mono.
+
+	gains map[string]GainMatch // By path, see WithGains.
+}
+
+// Retuning is the tuning correction recorded in the metadata of a
+// processed file.
+type Retuning struct {
+	Reference float64 `json:"reference"`   // A4 in Hz.
+	Detected  float64 `json:"detected_hz"` // Zero if no pitch was detected.
+	Cents     float64 `json:"cents"`       // Deviation of the detected pitch from the note.
+	Applied   bool    `json:"applied"`     // False if the deviation was too large to trust.
+}
+
+// Processed is the metadata written next to a processed file.
+type Processed struct {
+	Source string     `json:"source"`
+	Sample Sample     `json:"sample"`
+	Retune *Retuning  `json:"retune,omitempty"`
+	Gain   *GainMatch `json:"gain,omitempty"`
+
+	// Segments of the processed file, which was also written as attack,
+	// sustain (the loop) and release files.
+	Segments *Segments `json:"segments,omitempty"`
+}
+
+// Enabled returns true if the pipeline does anything.
+func (p Pipeline) Enabled() bool {
+	return p.Rate > 0 || p.Bits > 0 || p.Mono || p.Trim < 0 || p.Normalize != nil || p.Retune || p.Segment || p.MatchGain || p.FoldDualMono
+}
+
+// Output returns the path a downloaded file is processed to.
+func (p Pipeline) Output(name string) string {
+	return filepath.Join(p.Dir, strings.TrimSuffix(name, filepath.Ext(name))+".wav")
+}
+
+// OutputBits returns the bit depth to write a processed file with.
+func (p Pipeline) OutputBits(a *Audio) int {
+	switch {
+	case p.Bits > 0:
+		return p.Bits
+	case a.BitDepth == 16:
+		return 16
+	}
+	return 24
+}
+
+// Apply returns a processed copy of a.
+func (p Pipeline) Apply(a *Audio) *Audio {
+	out := *a
+	out.Data = append([]float64(nil), a.Data...)
+
+	if p.Trim < 0 {
+		TrimSilence(&out, dbToAmp(p.Trim))
+	}
+	if p.Mono {
+		MixToMono(&out)
+	} else if p.FoldDualMono {
+		FoldDualMono(&out)
+	}
+	if p.Rate > 0 {
+		out = *Resample(&out, p.Rate)
+	}
+	if p.Normalize != nil {
+		NormalizePeak(&out, dbToAmp(*p.Normalize))
+	}
+	return &out
+}
+
+// ProcessFile runs the pipeline over a downloaded file, decoded by engine,
+// and returns the path it was written to. Retuned, segmented and gain matched
+// files get a JSON file of metadata next to them recording the correction,
+// segments and gain.
+func (p Pipeline) ProcessFile(engine Engine, f File) (string, error) {
+	a, err := engine.Decode(f.Path)
+	if err != nil {
+		return "", errors.Wrap(err, "decoding")
+	}
+	return p.ProcessAudio(a, f)
+}
+
+// ProcessAudio runs the pipeline over the decoded audio of a downloaded
+// file, which need not have been written to disk, like ProcessFile.
+func (p Pipeline) ProcessAudio(a *Audio, f File) (string, error) {
+	var (
+		err  error
+		out  = p.Output(f.Path)
+		meta = Processed{Source: f.Path, Sample: f.Sample}
+	)
+	if p.Retune && meta.Sample.MIDI > 0 {
+		a, meta.Retune = p.retune(a, meta.Sample.MIDI)
+	}
+	if err := os.MkdirAll(filepath.Dir(out), os.ModePerm); err != nil {
+		return "", errors.Wrap(err, "making directory")
+	}
+	var (
+		processed = p.Apply(a)
+		bits      = p.OutputBits(a)
+	)
+	if g, ok := p.gains[f.Path]; ok {
+		Gain(processed, dbToAmp(g.Gain))
+		meta.Gain = &g
+	}
+	if err := WriteWAVFile(out, processed, bits); err != nil {
+		return "", errors.Wrap(err, "writing")
+	}
+	if p.Segment {
+		if meta.Segments, err = writeSegments(out, processed, bits); err != nil {
+			return "", err
+		}
+	}
+	if meta.Retune != nil || meta.Segments != nil || meta.Gain != nil {
+		data, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return "", errors.Wrap(err, "encoding metadata")
+		}
+		if err := ioutil.WriteFile(MetadataName(out), append(data, '\n'), 0644); err != nil {
+			return "", errors.Wrap(err, "writing metadata")
+		}
+	}
+	return out, nil
+}
+
+// retune corrects the tuning of a sample of a MIDI note, if it can be detected.
+func (p Pipeline) retune(a *Audio, midi int) (*Audio, *Retuning) {
+	var (
+		want = Frequency(midi, p.A4)
+		r    = &Retuning{Reference: p.A4}
+	)
+	got, ok := DetectPitch(a, want)
+	if !ok {
+		return a, r
+	}
+	r.Detected, r.Cents = got, Cents(got, want)
+	if math.Abs(r.Cents) > maxRetune {
+		return a, r
+	}
+	r.Applied = true
+	return Retune(a, -r.Cents), r
+}
+
+// TrimSilence removes the frames before the first and after the last
+// sample whose level is at least threshold.
+func TrimSilence(a *Audio, threshold float64) {
+	var (
+		frames      = a.Frames()
+		first, last = -1, -1
+	)
+	for i := 0; i < frames; i++ {
+		for c := 0; c < a.Channels; c++ {
+			if math.Abs(a.Data[i*a.Channels+c]) >= threshold {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+	}
+	if first < 0 {
+		a.Data = a.Data[:0]
+		return
+	}
+	a.Data = a.Data[first*a.Channels : (last+1)*a.Channels]
+}
+
+// MixToMono averages the channels of a.
+func MixToMono(a *Audio) {
+	if a.Channels <= 1 {
+		return
+	}
+	mono := make([]float64, a.Frames())
+	for i := range mono {
+		var sum float64
+		for c := 0; c < a.Channels; c++ {
+			sum += a.Data[i*a.Channels+c]
+		}
+		mono[i] = sum / float64(a.Channels)
+	}
+	a.Data, a.Channels = mono, 1
+}
+
+// NormalizePeak scales a so that its peak is level.
+func NormalizePeak(a *Audio, level float64) {
+	if peak := Peak(a); peak > 0 {
+		Gain(a, level/peak)
+	}
+}
+
+// Peak returns the highest absolute sample value of a.
+func Peak(a *Audio) float64 {
+	var peak float64
+
+	for _, s := range a.Data {
+		peak = math.Max(peak, math.Abs(s))
+	}
+	return peak
+}
+
+// Gain multiplies every sample of a by g.
+func Gain(a *Audio, g float64) {
+	for i := range a.Data {
+		a.Data[i] *= g
+	}
+}
+
+func dbToAmp(db float64) float64 {
+	return math.Pow(10, db/20)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRecentErrors is the number of errors a Progress remembers.
+const maxRecentErrors = 10
+
+// Progress tracks the state of every transfer in a job.
+type Progress struct {
+	mu        sync.Mutex
+	start     time.Time
+	queue     []string // Files that have not started yet, in order.
+	finished  int
+	failed    int
+	bytes     int64 // Bytes copied by finished transfers.
+	transfers map[string]*Transfer
+	errors    []string
+}
+
+// NewProgress creates a new progress tracker.
+func NewProgress() *Progress {
+	return &Progress{
+		start:     time.Now(),
+		transfers: map[string]*Transfer{},
+	}
+}
+
+// Add adds files to the job's queue.
+func (p *Progress) Add(locations ...string) {
+	p.mu.Lock()
+	p.queue = append(p.queue, locations...)
+	p.mu.Unlock()
+}
+
+// Start starts tracking a transfer.
+// size is the expected size of the transfer in bytes, or -1 if it is unknown.
+func (p *Progress) Start(location string, size int64) *Transfer {
+	t := &Transfer{
+		Location: location,
+		Size:     size,
+		Start:    time.Now(),
+	}
+	p.mu.Lock()
+	p.dequeue(location)
+	p.transfers[location] = t
+	p.mu.Unlock()
+	return t
+}
+
+// Finish stops tracking a transfer.
+func (p *Progress) Finish(t *Transfer) {
+	p.mu.Lock()
+	delete(p.transfers, t.Location)
+	p.finished++
+	p.bytes += t.Copied()
+	p.mu.Unlock()
+}
+
+// Skip records a file that does not need to be transferred.
+func (p *Progress) Skip(location string) {
+	p.mu.Lock()
+	p.dequeue(location)
+	p.finished++
+	p.mu.Unlock()
+}
+
+// Fail records a file that could not be transferred, and stops tracking
+// its transfer if it started.
+func (p *Progress) Fail(location string, err error) {
+	p.mu.Lock()
+	p.dequeue(location)
+	delete(p.transfers, location)
+	p.failed++
+	p.errors = append(p.errors, location+": "+err.Error())
+	if len(p.errors) > maxRecentErrors {
+		p.errors = p.errors[len(p.errors)-maxRecentErrors:]
+	}
+	p.mu.Unlock()
+}
+
+// dequeue must be called with p.mu held.
+func (p *Progress) dequeue(location string) {
+	for i, l := range p.queue {
+		if l == location {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// Report writes the state of the job to w every interval until ctx is done.
+func (p *Progress) Report(ctx context.Context, w io.Writer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Print(w)
+		}
+	}
+}
+
+// Print writes a line for every active transfer and a summary line for the whole job.
+func (p *Progress) Print(w io.Writer) {
+	snap := p.Snapshot()
+
+	for _, t := range snap.Active {
+		_, _ = fmt.Fprintf(w, "%s  %s/%s  %s/s  ETA %s\n",
+			path.Base(t.Location), FormatBytes(t.Copied), FormatBytes(t.Size), FormatBytes(t.Rate), FormatETA(t.ETA))
+	}
+	_, _ = fmt.Fprintf(w, "total  %d/%d files  %s  %s/s  ETA %s\n",
+		snap.Finished, snap.Files, FormatBytes(snap.Bytes), FormatBytes(snap.Rate), FormatETA(snap.ETA))
+}
+
+// Snapshot is the state of a job at a point in time.
+type Snapshot struct {
+	Queue    []string
+	Active   []TransferState
+	Errors   []string
+	Files    int
+	Finished int
+	Failed   int
+	Bytes    int64
+	Elapsed  time.Duration
+	Rate     int64
+	ETA      time.Duration // -1 if unknown.
+}
+
+// TransferState is the state of a single transfer at a point in time.
+type TransferState struct {
+	Location string
+	Size     int64
+	Copied   int64
+	Rate     int64
+	ETA      time.Duration // -1 if unknown.
+}
+
+// Snapshot returns the current state of the job.
+func (p *Progress) Snapshot() Snapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		now  = time.Now()
+		snap = Snapshot{
+			Queue:    append([]string(nil), p.queue...),
+			Active:   make([]TransferState, 0, len(p.transfers)),
+			Errors:   append([]string(nil), p.errors...),
+			Files:    len(p.queue) + len(p.transfers) + p.finished + p.failed,
+			Finished: p.finished,
+			Failed:   p.failed,
+			Bytes:    p.bytes,
+			Elapsed:  now.Sub(p.start),
+			ETA:      -1,
+		}
+		remaining   int64
+		sized, size int64
+	)
+	for _, t := range p.transfers {
+		ts := TransferState{
+			Location: t.Location,
+			Size:     t.Size,
+			Copied:   t.Copied(),
+			Rate:     t.Rate(now),
+			ETA:      t.ETA(now),
+		}
+		snap.Active = append(snap.Active, ts)
+		snap.Bytes += ts.Copied
+
+		if t.Size >= 0 {
+			remaining += t.Size - ts.Copied
+			sized++
+			size += t.Size
+		}
+	}
+	sort.Slice(snap.Active, func(i, j int) bool {
+		return snap.Active[i].Location < snap.Active[j].Location
+	})
+	// Estimate the size of the files that have not started yet from the
+	// average size of the ones we know about.
+	if known := int64(p.finished) + sized; known > 0 {
+		remaining += int64(len(p.queue)) * ((p.bytes + size) / known)
+	}
+	snap.Rate = rate(snap.Bytes, snap.Elapsed)
+
+	if snap.Rate > 0 {
+		snap.ETA = time.Duration(float64(remaining) / float64(snap.Rate) * float64(time.Second))
+	}
+	return snap
+}
+
+// Transfer is a single file transfer.
+type Transfer struct {
+	copied int64 // Accessed atomically, keep it 64-bit aligned.
+
+	Location string
+	Size     int64
+	Start    time.Time
+}
+
+// Copied returns the number of bytes copied so far.
+func (t *Transfer) Copied() int64 {
+	return atomic.LoadInt64(&t.copied)
+}
+
+// ETA returns the estimated time remaining, or -1 if it can not be estimated.
+func (t *Transfer) ETA(now time.Time) time.Duration {
+	r := t.Rate(now)
+	if t.Size < 0 || r <= 0 {
+		return -1
+	}
+	return time.Duration(float64(t.Size-t.Copied()) / float64(r) * float64(time.Second))
+}
+
+// Rate returns the transfer speed in bytes per second.
+func (t *Transfer) Rate(now time.Time) int64 {
+	return rate(t.Copied(), now.Sub(t.Start))
+}
+
+// Reader wraps r so that reads from it are counted by the transfer.
+func (t *Transfer) Reader(r io.Reader) io.Reader {
+	return transferReader{Reader: r, t: t}
+}
+
+type transferReader struct {
+	io.Reader
+	t *Transfer
+}
+
+func (tr transferReader) Read(p []byte) (int, error) {
+	n, err := tr.Reader.Read(p)
+	atomic.AddInt64(&tr.t.copied, int64(n))
+	return n, err
+}
+
+// FormatBytes formats a byte count for humans.
+// Negative counts are unknown.
+func FormatBytes(n int64) string {
+	const unit = 1024
+
+	if n < 0 {
+		return "?"
+	}
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// FormatETA formats an estimated time remaining for humans.
+// Negative durations are unknown.
+func FormatETA(d time.Duration) string {
+	if d < 0 {
+		return "?"
+	}
+	return d.Round(time.Second).String()
+}
+
+func rate(n int64, d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(float64(n) / d.Seconds())
+}
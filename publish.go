@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	stdurl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sumsName is the name of the checksums asset of a release, which
+// "sha256sum -c" checks.
+const sumsName = "SHA256SUMS"
+
+// githubAPI is the GitHub API, unless GITHUB_API_URL says otherwise, e.g.
+// for GitHub Enterprise.
+const githubAPI = "https://api.github.com"
+
+// Release is a GitHub release.
+type Release struct {
+	ID        int64          `json:"id"`
+	TagName   string         `json:"tag_name"`
+	HTMLURL   string         `json:"html_url"`
+	UploadURL string         `json:"upload_url"` // A URI template, e.g. .../assets{?name,label}.
+	Assets    []ReleaseAsset `json:"assets"`
+}
+
+// ReleaseAsset is a file attached to a release.
+type ReleaseAsset struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// GitHub is a client of the releases of a GitHub repository.
+type GitHub struct {
+	client *http.Client
+	api    string
+	repo   string // owner/repo
+	token  string
+}
+
+// NewGitHub creates a client of the releases of repo, e.g. owner/repo,
+// authenticated with the token in GITHUB_TOKEN or GH_TOKEN.
+func NewGitHub(client *http.Client, repo string) (*GitHub, error) {
+	if parts := strings.Split(repo, "/"); len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, errors.New("github must be owner/repo: " + repo)
+	}
+	token := os.Getenv("GITHUB_TOKEN")
+	if len(token) == 0 {
+		token = os.Getenv("GH_TOKEN")
+	}
+	if len(token) == 0 {
+		return nil, errors.New("publishing to GitHub needs a token in GITHUB_TOKEN")
+	}
+	api := githubAPI
+	if env := os.Getenv("GITHUB_API_URL"); len(env) > 0 {
+		api = strings.TrimSuffix(env, "/")
+	}
+	return &GitHub{client: client, api: api, repo: repo, token: token}, nil
+}
+
+// do sends a request to the API, decoding the JSON response into v unless
+// it is nil. It returns the status code of the response.
+func (gh *GitHub) do(ctx context.Context, method, url, contentType string, body io.Reader, size int64, v interface{}) (int, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return 0, errors.Wrap(err, "creating request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+gh.token)
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+		req.ContentLength = size
+	}
+	resp, err := gh.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }() // Best effort.
+
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&e) // The message is optional.
+		return resp.StatusCode, errors.Errorf("%s %s: %s %s", method, url, resp.Status, e.Message)
+	}
+	if v == nil {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, errors.Wrap(json.NewDecoder(resp.Body).Decode(v), "decoding response")
+}
+
+// Release returns the release of a tag, creating it if there is none.
+func (gh *GitHub) Release(ctx context.Context, tag string) (Release, error) {
+	var r Release
+
+	status, err := gh.do(ctx, http.MethodGet, gh.api+"/repos/"+gh.repo+"/releases/tags/"+stdurl.PathEscape(tag), "", nil, 0, &r)
+	if err != nil || status != http.StatusNotFound {
+		return r, err
+	}
+	body, _ := json.Marshal(map[string]string{"tag_name": tag, "name": tag}) // Can't fail.
+	_, err = gh.do(ctx, http.MethodPost, gh.api+"/repos/"+gh.repo+"/releases", "application/json", bytes.NewReader(body), int64(len(body)), &r)
+	return r, errors.Wrap(err, "creating release "+tag)
+}
+
+// Upload uploads a file as an asset of a release, replacing the asset of
+// the same name if there is one.
+func (gh *GitHub) Upload(ctx context.Context, r Release, name string) error {
+	base := filepath.Base(name)
+	for _, a := range r.Assets {
+		if a.Name == base {
+			if _, err := gh.do(ctx, http.MethodDelete, fmt.Sprintf("%s/repos/%s/releases/assets/%d", gh.api, gh.repo, a.ID), "", nil, 0, nil); err != nil {
+				return errors.Wrap(err, "replacing "+base)
+			}
+		}
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }() // Best effort.
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	upload := r.UploadURL
+	if i := strings.Index(upload, "{"); i >= 0 {
+		upload = upload[:i]
+	}
+	_, err = gh.do(ctx, http.MethodPost, upload+"?name="+stdurl.QueryEscape(base), "application/octet-stream", f, info.Size(), nil)
+	return errors.Wrap(err, "uploading "+base)
+}
+
+// writeSums writes the SHA-256 checksums of files, by base name, in the
+// format of sha256sum.
+func writeSums(name string, files []string) error {
+	var buf bytes.Buffer
+
+	for _, f := range files {
+		c, err := hashFile(f, false)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%s  %s\n", c.SHA256, filepath.Base(f))
+	}
+	return errors.Wrap(ioutil.WriteFile(name, buf.Bytes(), 0644), "writing "+name)
+}
+
+// publish runs the publish command: "iowa publish -github owner/repo
+// -release-tag TAG file..." uploads files, e.g. a built archive and its
+// manifest, as assets of the release of a tag, with a SHA256SUMS of them
+// and any signatures of them that -sign wrote. The release is created if
+// there isn't one.
+func (app *App) publish(ctx context.Context) error {
+	if len(app.GitHub) == 0 || len(app.ReleaseTag) == 0 || len(app.Args) == 0 {
+		return withKind(FailureConfig, errors.New("usage: iowa publish -github owner/repo -release-tag TAG file..."))
+	}
+	var files []string
+	for _, name := range app.Args {
+		info, err := os.Stat(name)
+		if err != nil {
+			return withKind(FailureConfig, err)
+		}
+		if info.IsDir() {
+			return withKind(FailureConfig, errors.New(name+" is a directory, publish an archive of it"))
+		}
+		if filepath.Base(name) == sumsName {
+			return withKind(FailureConfig, errors.New(sumsName+" is written by publish"))
+		}
+		files = append(files, name)
+	}
+	gh, err := NewGitHub(app.client, app.GitHub)
+	if err != nil {
+		return withKind(FailureConfig, err)
+	}
+	dir, err := ioutil.TempDir("", "iowa-publish")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary directory")
+	}
+	defer func() { _ = os.RemoveAll(dir) }() // Best effort.
+
+	sums := filepath.Join(dir, sumsName)
+	if err := writeSums(sums, files); err != nil {
+		return err
+	}
+	uploads := append([]string(nil), files...)
+	for _, f := range files {
+		if _, err := os.Stat(f + signatureSuffix); err == nil {
+			uploads = append(uploads, f+signatureSuffix)
+		}
+	}
+	uploads = append(uploads, sums)
+
+	r, err := gh.Release(ctx, app.ReleaseTag)
+	if err != nil {
+		return err
+	}
+	for _, name := range uploads {
+		if err := gh.Upload(ctx, r, name); err != nil {
+			return err
+		}
+		app.term.Status(StatusOK, "%s: uploaded %s", app.ReleaseTag, filepath.Base(name))
+	}
+	app.term.Status(StatusOK, "published %s", r.HTMLURL)
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// noteNames are the names of the notes of an octave, spelt with flats as
+// the file names of the site are.
+var noteNames = []string{"C", "Db", "D", "Eb", "E", "F", "Gb", "G", "Ab", "A", "Bb", "B"}
+
+// NoteName returns the name of a MIDI note, e.g. Bb3 for 58.
+func NoteName(midi int) string {
+	return noteNames[(midi%12+12)%12] + strconv.Itoa(int(math.Floor(float64(midi)/12))-1)
+}
+
+// NoteRange is a range of sampled notes.
+type NoteRange struct {
+	Low      string `json:"low"`
+	High     string `json:"high"`
+	LowMIDI  int    `json:"low_midi"`
+	HighMIDI int    `json:"high_midi"`
+	Notes    int    `json:"notes"` // Sampled notes in the range.
+}
+
+// DynamicCoverage is the range of the samples of a dynamic of an
+// instrument.
+type DynamicCoverage struct {
+	Dynamic string `json:"dynamic"`
+	NoteRange
+	Missing []string `json:"missing,omitempty"` // Notes of the instrument's range that the dynamic doesn't have.
+}
+
+// RangeReport is the sampled range of an instrument by the notes in the
+// names of its files and, with -detect-pitch, by their detected pitch.
+type RangeReport struct {
+	Page
+	Files    int               `json:"files"`
+	Range    *NoteRange        `json:"range,omitempty"` // nil if no file names a note.
+	Dynamics []DynamicCoverage `json:"dynamics,omitempty"`
+	Detected *NoteRange        `json:"detected,omitempty"`
+
+	// Mismatches are the files whose detected pitch is another note
+	// than the one their name says.
+	Mismatches []string `json:"mismatches,omitempty"`
+}
+
+// noteRange returns the range of a set of MIDI notes, nil if it is empty.
+func noteRange(notes map[int]bool) *NoteRange {
+	if len(notes) == 0 {
+		return nil
+	}
+	r := &NoteRange{LowMIDI: math.MaxInt32, HighMIDI: math.MinInt32, Notes: len(notes)}
+	for n := range notes {
+		if n < r.LowMIDI {
+			r.LowMIDI = n
+		}
+		if n > r.HighMIDI {
+			r.HighMIDI = n
+		}
+	}
+	r.Low, r.High = NoteName(r.LowMIDI), NoteName(r.HighMIDI)
+	return r
+}
+
+// fileNotes returns the notes that a file covers, every note of its range
+// if it is one.
+func fileNotes(f File) []int {
+	if f.MIDI == 0 {
+		return nil
+	}
+	high := f.HighMIDI
+	if high < f.MIDI {
+		high = f.MIDI
+	}
+	var notes []int
+	for n := f.MIDI; n <= high; n++ {
+		notes = append(notes, n)
+	}
+	return notes
+}
+
+// NewRangeReport reports the sampled range of an instrument from the notes
+// in the names of its files.
+func NewRangeReport(inst Instrument) RangeReport {
+	var (
+		r         = RangeReport{Page: inst.Page, Files: len(inst.Files)}
+		all       = map[int]bool{}
+		byDynamic = map[string]map[int]bool{}
+	)
+	for _, f := range inst.Files {
+		for _, n := range fileNotes(f) {
+			all[n] = true
+			if byDynamic[f.Dynamic] == nil {
+				byDynamic[f.Dynamic] = map[int]bool{}
+			}
+			byDynamic[f.Dynamic][n] = true
+		}
+	}
+	r.Range = noteRange(all)
+
+	for dynamic, notes := range byDynamic {
+		dc := DynamicCoverage{Dynamic: dynamic, NoteRange: *noteRange(notes)}
+		for n := r.Range.LowMIDI; n <= r.Range.HighMIDI; n++ {
+			if all[n] && !notes[n] {
+				dc.Missing = append(dc.Missing, NoteName(n))
+			}
+		}
+		r.Dynamics = append(r.Dynamics, dc)
+	}
+	sort.Slice(r.Dynamics, func(i, j int) bool {
+		return DynamicIndex(r.Dynamics[i].Dynamic) < DynamicIndex(r.Dynamics[j].Dynamic)
+	})
+	return r
+}
+
+// detectRange adds the range of an instrument by the detected pitch of its
+// files to its report, decoding as many at once as -jobs allows.
+func (app *App) detectRange(ctx context.Context, inst Instrument, r *RangeReport) error {
+	var (
+		detected = make([]int, len(inst.Files)) // 0 if there is no clear pitch.
+		g        errgroup.Group
+	)
+	for i, f := range inst.Files {
+		if f.MIDI == 0 || f.HighMIDI > f.MIDI {
+			continue // Only single notes have a pitch to expect.
+		}
+		i, f := i, f
+		g.Go(func() error {
+			if err := app.limiter.Acquire(ctx); err != nil {
+				return err
+			}
+			defer app.limiter.Release()
+
+			a, err := app.engine.Decode(f.Path)
+			if err != nil {
+				app.term.Status(StatusWarn, "%s: %s", f.Path, err)
+				return nil
+			}
+			hz, ok := DetectPitch(a, Frequency(f.MIDI, app.Pipeline.A4))
+			if ok {
+				detected[i] = int(math.Round(69 + 12*math.Log2(hz/app.Pipeline.A4)))
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	notes := map[int]bool{}
+	for i, n := range detected {
+		if n == 0 {
+			continue
+		}
+		notes[n] = true
+		if n != inst.Files[i].MIDI {
+			r.Mismatches = append(r.Mismatches, fmt.Sprintf("%s (%s)", inst.Files[i].Path, NoteName(n)))
+		}
+	}
+	r.Detected = noteRange(notes)
+	return nil
+}
+
+// ranges runs the range command: "iowa range [flags] [dir]" reports the
+// sampled range of every instrument under dir (the current directory by
+// default), its lowest and highest notes and the coverage of each of its
+// dynamics, by the notes in the names of the files and, with -detect-pitch,
+// by their detected pitch.
+func (app *App) ranges(ctx context.Context) error {
+	if len(app.Args) > 1 {
+		return withKind(FailureConfig, errors.New("usage: iowa range [flags] [dir]"))
+	}
+	root := "."
+	if len(app.Args) == 1 {
+		root = app.Args[0]
+	}
+	insts, err := app.localInstruments(root)
+	if err != nil {
+		return err
+	}
+	var (
+		reports = make([]RangeReport, len(insts))
+		rows    = make([][]string, len(insts))
+	)
+	for i, inst := range insts {
+		reports[i] = NewRangeReport(inst)
+		if app.DetectPitch {
+			if err := app.detectRange(ctx, inst, &reports[i]); err != nil {
+				return err
+			}
+		}
+	}
+	sort.SliceStable(reports, func(i, j int) bool { return reports[i].Page.String() < reports[j].Page.String() })
+
+	for i, r := range reports {
+		low, high, notes := "-", "-", "0"
+		if r.Range != nil {
+			low, high, notes = r.Range.Low, r.Range.High, strconv.Itoa(r.Range.Notes)
+		}
+		dynamics := make([]string, len(r.Dynamics))
+		for j, dc := range r.Dynamics {
+			name := dc.Dynamic
+			if len(name) == 0 {
+				name = "none"
+			}
+			dynamics[j] = fmt.Sprintf("%s %d/%d", name, dc.Notes, r.Range.Notes)
+		}
+		rows[i] = []string{r.Era, r.Section, r.Instrument, strconv.Itoa(r.Files), low, high, notes, strings.Join(dynamics, ", ")}
+		if app.DetectPitch {
+			detected := "-"
+			if r.Detected != nil {
+				detected = r.Detected.Low + "-" + r.Detected.High
+			}
+			rows[i] = append(rows[i], detected, strconv.Itoa(len(r.Mismatches)))
+		}
+	}
+	header := []string{"ERA", "SECTION", "INSTRUMENT", "FILES", "LOW", "HIGH", "NOTES", "DYNAMICS"}
+	if app.DetectPitch {
+		header = append(header, "DETECTED", "MISMATCHES")
+	}
+	return app.printList(os.Stdout, reports, header, rows)
+}
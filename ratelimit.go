@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the rate of requests to each host with a token
+// bucket, independently of how many transfers run at once.
+type RateLimiter struct {
+	rate  float64 // Requests per second, zero or less is unlimited.
+	burst float64
+
+	mu    sync.Mutex
+	hosts map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64 // Negative when requests are waiting.
+	last   time.Time
+}
+
+// NewRateLimiter creates a rate limiter that allows rps requests a second
+// to each host, in bursts of up to a second's worth.
+func NewRateLimiter(rps float64) *RateLimiter {
+	return &RateLimiter{
+		rate:  rps,
+		burst: math.Max(1, math.Ceil(rps)),
+		hosts: map[string]*bucket{},
+	}
+}
+
+// Wait blocks until a request to host may be made or ctx is done.
+func (rl *RateLimiter) Wait(ctx context.Context, host string) error {
+	if rl.rate <= 0 {
+		return nil
+	}
+	now := time.Now()
+
+	rl.mu.Lock()
+	b, ok := rl.hosts[host]
+	if !ok {
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.hosts[host] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+	b.tokens-- // Reserve a token, waiting for it if there is none.
+	wait := time.Duration(-b.tokens / rl.rate * float64(time.Second))
+	rl.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		rl.mu.Lock()
+		b.tokens++ // Give the reservation back.
+		rl.mu.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RS5K parameters set by the exported projects, by index, normalized to
+// 0..1. The pitch at the start and end of the note range is normalized
+// over -80 to +80 semitones.
+const (
+	rs5kNoteStart   = 3
+	rs5kNoteEnd     = 4
+	rs5kPitchStart  = 5
+	rs5kPitchEnd    = 6
+	rs5kObeyNoteOff = 11
+	rs5kMinVelocity = 17
+	rs5kMaxVelocity = 18
+)
+
+// rs5kID is the VST ID of ReaSamplOmatic5000, "rsom".
+const rs5kID = 1920167789
+
+// Reaper exports a REAPER project per instrument, e.g. MISCello2012.rpp,
+// with a folder track for the instrument and a child track per
+// articulation, armed for MIDI, holding a ReaSamplOmatic5000 for every
+// sample. The sample of each RS5K is its FILE0 in the plug-in's state, and
+// its note and velocity range and pitch are parameter envelopes of a
+// single point, which the project holds as plain text.
+type Reaper struct {
+	fill     Fill
+	velocity Velocity
+}
+
+// NewReaper creates a REAPER exporter.
+func NewReaper(conf Config, engine Engine) Exporter {
+	return Reaper{fill: conf.Fill, velocity: conf.Velocity}
+}
+
+// Export writes <instrument>.rpp.
+func (rp Reaper) Export(inst Instrument) error {
+	layers := Layers(inst.Files)
+	if len(layers) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+
+	buf.WriteString("<REAPER_PROJECT 0.1 \"6.0\" 0\n")
+	fmt.Fprintf(&buf, "  <NOTES 0 2\n    |%s (%s/%s)\n    |Generated by iowa from %s\n  >\n", inst.Page.Instrument, inst.Page.Era, inst.Page.Section, inst.Page.URL)
+	fmt.Fprintf(&buf, "  <TRACK\n    NAME %s\n    ISBUS 1 1\n  >\n", rppString(inst.Page.Instrument))
+
+	arts := groupLayers(layers)
+	for i, art := range arts {
+		bus := "0 0"
+		if i == len(arts)-1 {
+			bus = "2 -1" // Close the folder.
+		}
+		fmt.Fprintf(&buf, "  <TRACK\n    NAME %s\n    ISBUS %s\n", rppString(strings.TrimSpace(inst.Page.Instrument+" "+art[0].Articulation)), bus)
+		buf.WriteString("    REC 1 6112 1 0 0 0 0 0\n") // Armed, monitoring every MIDI input and channel.
+		buf.WriteString("    <FXCHAIN\n      SHOW 0\n      LASTSEL 0\n      DOCKED 0\n")
+
+		ranges := rp.velocity.Ranges(len(art))
+		for j, layer := range art {
+			for k, keys := range layer.KeyRanges(rp.fill) {
+				f := layer.Files[k]
+				file, err := filepath.Abs(f.Path)
+				if err != nil {
+					return errors.Wrap(err, "resolving "+f.Path)
+				}
+				writeRS5K(&buf, file, map[int]float64{
+					rs5kNoteStart:   float64(keys[0]) / 127,
+					rs5kNoteEnd:     float64(keys[1]) / 127,
+					rs5kPitchStart:  0.5 + float64(keys[0]-f.MIDI)/160,
+					rs5kPitchEnd:    0.5 + float64(keys[1]-f.MIDI)/160,
+					rs5kObeyNoteOff: 1,
+					rs5kMinVelocity: float64(ranges[j].Low) / 127,
+					rs5kMaxVelocity: float64(ranges[j].High) / 127,
+				})
+			}
+		}
+		buf.WriteString("    >\n  >\n")
+	}
+	buf.WriteString(">\n")
+
+	if err := ioutil.WriteFile(inst.Name()+".rpp", buf.Bytes(), 0644); err != nil {
+		return errors.Wrap(err, "writing reaper project")
+	}
+	return nil
+}
+
+// writeRS5K writes an RS5K playing file, with parameters set by index, to
+// an FX chain.
+func writeRS5K(buf *bytes.Buffer, file string, params map[int]float64) {
+	buf.WriteString("      BYPASS 0 0 0\n")
+	buf.WriteString("      <VST \"VSTi: ReaSamplOmatic5000 (Cockos)\" reasamplomatic.dll 0 \"\" 1920167789<56535472736F6D72656173616D706C6F> \"\"\n")
+
+	// The state is REAPER's header of the plug-in's pins and the size of
+	// its chunk, and RS5K's chunk, which starts with FILE0.
+	var (
+		header bytes.Buffer
+		chunk  = append([]byte(file), 0)
+	)
+	for _, v := range []uint32{rs5kID, 0xfeed5eee, 2, 1, 0, 2, 0, 2, 1, 0, 2, 0, uint32(len(chunk)), 1, 0x00100000} {
+		_ = binary.Write(&header, binary.LittleEndian, v) // Can't fail.
+	}
+	fmt.Fprintf(buf, "        %s\n", base64.StdEncoding.EncodeToString(header.Bytes()))
+	for len(chunk) > 0 {
+		n := len(chunk)
+		if n > 96 {
+			n = 96
+		}
+		fmt.Fprintf(buf, "        %s\n", base64.StdEncoding.EncodeToString(chunk[:n]))
+		chunk = chunk[n:]
+	}
+	fmt.Fprintf(buf, "        %s\n", base64.StdEncoding.EncodeToString([]byte("\x00Program 1\x00\x10\x00\x00\x00")))
+	buf.WriteString("      >\n      FLOATPOS 0 0 0 0\n")
+
+	for _, param := range []int{rs5kNoteStart, rs5kNoteEnd, rs5kPitchStart, rs5kPitchEnd, rs5kObeyNoteOff, rs5kMinVelocity, rs5kMaxVelocity} {
+		fmt.Fprintf(buf, "      <PARMENV %d 0 1 0.5\n        ACT 1 -1\n        VIS 0 1 1\n        LANEHEIGHT 0 0\n        ARM 0\n        DEFSHAPE 1 -1 -1\n        PT 0 %g 1\n      >\n", param, params[param])
+	}
+	buf.WriteString("      WAK 0 0\n")
+}
+
+// rppString quotes a string for a REAPER project, which has no escapes:
+// strings with double quotes are quoted with single quotes or backticks.
+func rppString(s string) string {
+	s = strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+	switch {
+	case !strings.Contains(s, `"`):
+		return `"` + s + `"`
+	case !strings.Contains(s, "'"):
+		return "'" + s + "'"
+	}
+	return "`" + strings.Replace(s, "`", "'", -1) + "`"
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Sample is the metadata encoded in an MIS audio file name, e.g.
+// Cello.arco.ff.sulA.A3.stereo.aif or Flute.vib.ff.B3B4.stereo.aif.
+type Sample struct {
+	Instrument   string   `json:"instrument"`
+	Articulation string   `json:"articulation,omitempty"`
+	Dynamic      string   `json:"dynamic,omitempty"`
+	Note         string   `json:"note,omitempty"`      // Lowest note, e.g. B3.
+	HighNote     string   `json:"high_note,omitempty"` // Highest note if the file is a range, e.g. B4.
+	MIDI         int      `json:"midi,omitempty"`      // MIDI number of Note, 0 if there is no note.
+	HighMIDI     int      `json:"high_midi,omitempty"` // MIDI number of HighNote (or Note).
+	Channels     string   `json:"channels,omitempty"`  // mono or stereo, if the name says.
+	Extra        []string `json:"extra,omitempty"`     // Anything else, e.g. sulA.
+}
+
+// Dynamics in order from softest to loudest.
+var Dynamics = []string{"pppp", "ppp", "pp", "p", "mp", "mf", "f", "ff", "fff", "ffff"}
+
+var noteRE = regexp.MustCompile(`^([A-Ga-g])(b|#)?(-?[0-9])(?:([A-Ga-g])(b|#)?([0-9]))?$`)
+
+// ParseSample parses the metadata from the name of an audio file.
+// The name may be a path or URL.
+func ParseSample(name string) Sample {
+	name = path.Base(name)
+	if i := strings.LastIndex(name, "."); i > 0 {
+		name = name[:i]
+	}
+	var (
+		fields = strings.Split(name, ".")
+		sample = Sample{Instrument: fields[0]}
+		arts   []string
+	)
+	for _, field := range fields[1:] {
+		switch lower := strings.ToLower(field); {
+		case len(sample.Dynamic) == 0 && DynamicIndex(lower) >= 0:
+			sample.Dynamic = lower
+		case lower == "mono" || lower == "stereo":
+			sample.Channels = lower
+		case len(sample.Note) == 0 && noteRE.MatchString(field):
+			m := noteRE.FindStringSubmatch(field)
+			sample.Note = strings.ToUpper(m[1]) + m[2] + m[3]
+			sample.MIDI = noteNumber(m[1], m[2], m[3])
+			sample.HighNote, sample.HighMIDI = sample.Note, sample.MIDI
+			if len(m[4]) > 0 {
+				sample.HighNote = strings.ToUpper(m[4]) + m[5] + m[6]
+				sample.HighMIDI = noteNumber(m[4], m[5], m[6])
+			}
+		case len(sample.Dynamic) == 0 && len(sample.Note) == 0:
+			// Anything before the dynamic and note is the articulation.
+			arts = append(arts, field)
+		default:
+			sample.Extra = append(sample.Extra, field)
+		}
+	}
+	sample.Articulation = strings.Join(arts, ".")
+	return sample
+}
+
+// DynamicIndex returns the position of a dynamic in Dynamics, or -1.
+func DynamicIndex(dynamic string) int {
+	for i, d := range Dynamics {
+		if d == dynamic {
+			return i
+		}
+	}
+	return -1
+}
+
+// noteNumber returns the MIDI note number of a note, where C4 is 60.
+func noteNumber(letter, accidental, octave string) int {
+	semitones := map[string]int{"c": 0, "d": 2, "e": 4, "f": 5, "g": 7, "a": 9, "b": 11}[strings.ToLower(letter)]
+
+	switch accidental {
+	case "b":
+		semitones--
+	case "#":
+		semitones++
+	}
+	oct, _ := strconv.Atoi(octave)
+	return (oct+1)*12 + semitones
+}
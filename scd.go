@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// SuperCollider exports an sclang script per instrument that reads every
+// sample into a Buffer. Each articulation gets an environment variable
+// holding a Dictionary from dynamic to a Dictionary from MIDI note to Buffer,
+// e.g. ~cello_arco[\ff][57].
+//
+// The script also adds sampler SynthDefs and, for each articulation, a
+// function that turns a pattern of \midinote and \dynamic events into
+// events that play the nearest sample, e.g.
+//
+//	~cello_arco_pattern.(Pbind(\midinote, Pseq([48, 55, 60]), \dynamic, \ff)).play;
+type SuperCollider struct {
+	fill Fill
+}
+
+// NewSuperCollider creates a SuperCollider exporter.
+func NewSuperCollider(conf Config, engine Engine) Exporter {
+	return SuperCollider{fill: conf.Fill}
+}
+
+// Export writes <instrument>.scd.
+func (sc SuperCollider) Export(inst Instrument) error {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "// %s (%s/%s)\n", inst.Page.Instrument, inst.Page.Era, inst.Page.Section)
+	fmt.Fprintf(&buf, "// Generated by iowa from %s\n", inst.Page.URL)
+	buf.WriteString("(\nvar dir = thisProcess.nowExecutingPath.dirname;\n\n")
+	buf.WriteString("s.waitForBoot {\n")
+	fmt.Fprintf(&buf, "\t~iowaFill = %s;\n\n", scSymbol(string(sc.fill)))
+	buf.WriteString(scSampler)
+	sc.writeBuffers(&buf, inst)
+	sc.writePatterns(&buf, inst)
+	buf.WriteString("\ts.sync;\n};\n)\n")
+
+	if err := ioutil.WriteFile(inst.Name()+".scd", buf.Bytes(), 0644); err != nil {
+		return errors.Wrap(err, "writing supercollider script")
+	}
+	return nil
+}
+
+// writeBuffers writes a Dictionary literal for each articulation.
+func (sc SuperCollider) writeBuffers(buf *bytes.Buffer, inst Instrument) {
+	for _, art := range groupFiles(inst.Files, func(f File) string { return f.Articulation }) {
+		fmt.Fprintf(buf, "\t%s = Dictionary[\n", scVariable(inst.Page.Instrument, art.key))
+
+		dynamics := groupFiles(art.files, func(f File) string { return f.Dynamic })
+		for i, dyn := range dynamics {
+			fmt.Fprintf(buf, "\t\t%s -> Dictionary[\n", scSymbol(dyn.key))
+
+			// Only the first file for each note is kept.
+			var (
+				seen    = map[string]bool{}
+				entries []string
+			)
+			for _, f := range dyn.files {
+				key := noteKey(f)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				entries = append(entries, fmt.Sprintf("\t\t\t%s -> Buffer.read(s, dir +/+ %s)", key, quoteString(f.Path)))
+			}
+			buf.WriteString(strings.Join(entries, ",\n"))
+			buf.WriteString("\n\t\t]")
+			if i < len(dynamics)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString("\t];\n")
+	}
+}
+
+// writePatterns writes a pattern function for each articulation.
+func (sc SuperCollider) writePatterns(buf *bytes.Buffer, inst Instrument) {
+	for _, art := range groupFiles(inst.Files, func(f File) string { return f.Articulation }) {
+		v := scVariable(inst.Page.Instrument, art.key)
+		fmt.Fprintf(buf, "\t%s_pattern = { |pattern| pattern.collect { |ev| ~iowaPlay.(%s, ev) } };\n", v, v)
+	}
+}
+
+// scSampler defines the sampler SynthDefs and the functions that map
+// events to samples.
+const scSampler = `	[1, 2].do { |channels|
+		SynthDef(("iowaSampler" ++ channels).asSymbol, { |out = 0, buf, rate = 1, amp = 0.5, pan = 0, gate = 1, attack = 0.005, release = 0.3|
+			var env = EnvGen.kr(Env.asr(attack, 1, release), gate, doneAction: Done.freeSelf);
+			var sig = PlayBuf.ar(channels, buf, rate * BufRateScale.kr(buf), doneAction: Done.freeSelf);
+			sig = if(channels == 1) { Pan2.ar(sig, pan) } { Balance2.ar(sig[0], sig[1], pan) };
+			Out.ar(out, sig * amp * env);
+		}).add;
+	};
+
+	// Returns the buffer that plays midinote for a dynamic, according to
+	// ~iowaFill, and the rate that retunes it.
+	~iowaNearest = { |samples, dynamic, midinote|
+		var layer = samples[dynamic] ?? { samples.values.first };
+		var notes = layer.keys.select(_.isNumber).asArray.sort;
+		var i, note;
+		if(notes.isEmpty) {
+			[layer.values.first, 1]
+		} {
+			i = switch(~iowaFill,
+				\up, { ((notes.indexOfGreaterThan(midinote) ? notes.size) - 1).max(0) },
+				\down, { notes.indexOfGreaterThan(midinote - 1) ? (notes.size - 1) },
+				{ notes.indexIn(midinote) }
+			);
+			note = notes[i];
+			[layer[note], (midinote - note).midiratio]
+		}
+	};
+
+	// Fills in the instrument, buffer, and rate of an event.
+	~iowaPlay = { |samples, ev|
+		var bufRate = ~iowaNearest.(samples, ev[\dynamic] ? \mf, ev.use { ~midinote.value });
+		ev[\instrument] = ("iowaSampler" ++ bufRate[0].numChannels.clip(1, 2)).asSymbol;
+		ev[\buf] = bufRate[0];
+		ev[\rate] = bufRate[1];
+		ev
+	};
+
+`
+
+// fileGroup is a set of files that share a key.
+type fileGroup struct {
+	key   string
+	files []File
+}
+
+// groupFiles groups files by key, preserving the order of the files.
+func groupFiles(files []File, key func(File) string) []fileGroup {
+	var (
+		groups []fileGroup
+		index  = map[string]int{}
+	)
+	for _, f := range files {
+		k := key(f)
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, fileGroup{key: k})
+		}
+		groups[i].files = append(groups[i].files, f)
+	}
+	return groups
+}
+
+// noteKey returns the MIDI note of a file, or a symbol made from its
+// name if it doesn't have a note (e.g. hand percussion).
+func noteKey(f File) string {
+	if f.MIDI > 0 {
+		return strconv.Itoa(f.MIDI)
+	}
+	return scSymbol(strings.TrimSuffix(path.Base(f.Path), path.Ext(f.Path)))
+}
+
+// scVariable returns an environment variable name, e.g. ~cello_arco.
+func scVariable(parts ...string) string {
+	var b strings.Builder
+
+	for _, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteRune('_')
+		}
+		for _, r := range part {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				b.WriteRune(r)
+			} else {
+				b.WriteRune('_')
+			}
+		}
+	}
+	name := []rune(b.String())
+	if len(name) == 0 || !unicode.IsLetter(name[0]) {
+		name = append([]rune("i"), name...)
+	}
+	name[0] = unicode.ToLower(name[0])
+	return "~" + string(name)
+}
+
+// scSymbol returns a quoted symbol literal.
+func scSymbol(s string) string {
+	if len(s) == 0 {
+		s = "none"
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
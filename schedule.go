@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// windowPoll is how often -active-hours are checked.
+const windowPoll = 30 * time.Second
+
+// Window is a daily window of time, e.g. 01:00-07:00, in local time. A
+// window that ends before it starts runs past midnight, e.g. 22:00-06:00.
+type Window struct {
+	Start, End time.Duration // Since midnight.
+}
+
+// ParseWindows parses comma-separated windows, e.g. 01:00-07:00,12:00-13:00.
+func ParseWindows(s string) ([]Window, error) {
+	var windows []Window
+
+	for _, w := range strings.Split(s, ",") {
+		if len(w) == 0 {
+			continue
+		}
+		i := strings.Index(w, "-")
+		if i < 0 {
+			return nil, errors.Errorf("window must be start-end, e.g. 01:00-07:00: %s", w)
+		}
+		start, err := parseClock(w[:i])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(w[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, Window{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+// parseClock parses a time of day, e.g. 07:00.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, errors.Errorf("invalid time of day %q, e.g. 07:00", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains returns true if t is in the window.
+func (w Window) Contains(t time.Time) bool {
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.End < w.Start {
+		return d >= w.Start || d < w.End
+	}
+	return d >= w.Start && d < w.End
+}
+
+func (w Window) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return clock(w.Start) + "-" + clock(w.End)
+}
+
+// inWindows returns true if t is in any of the windows.
+func inWindows(windows []Window, t time.Time) bool {
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Gate holds transfers back while it is closed.
+type Gate struct {
+	mu   sync.Mutex
+	open chan struct{} // Closed while the gate is open.
+}
+
+// NewGate creates an open gate.
+func NewGate() *Gate {
+	open := make(chan struct{})
+	close(open)
+	return &Gate{open: open}
+}
+
+// Open opens the gate, letting waiting transfers through. It returns false
+// if the gate was already open.
+func (g *Gate) Open() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case <-g.open:
+		return false
+	default:
+		close(g.open)
+		return true
+	}
+}
+
+// Close closes the gate. It returns false if the gate was already closed.
+func (g *Gate) Close() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case <-g.open:
+		g.open = make(chan struct{})
+		return true
+	default:
+		return false
+	}
+}
+
+// IsOpen returns true if the gate is open.
+func (g *Gate) IsOpen() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case <-g.open:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait blocks until the gate is open or ctx is done.
+func (g *Gate) Wait(ctx context.Context) error {
+	g.mu.Lock()
+	open := g.open
+	g.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-open:
+		return nil
+	}
+}
+
+// schedule keeps the window gate open during the -active-hours until ctx
+// is done. Transfers that are running when a window ends finish. The gate
+// is set before schedule returns, so that no transfer starts outside the
+// windows, and kept up to date by a goroutine.
+func (app *App) schedule(ctx context.Context) {
+	app.updateWindow()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(windowPoll):
+			}
+			app.updateWindow()
+		}
+	}()
+}
+
+// updateWindow opens the window gate if it is in the -active-hours and
+// closes it if not.
+func (app *App) updateWindow() {
+	hours := formatWindows(app.ActiveHours)
+
+	if inWindows(app.ActiveHours, time.Now()) {
+		if app.window.Open() {
+			app.term.Status(StatusOK, "in -active-hours %s, resuming transfers", hours)
+		}
+	} else if app.window.Close() {
+		app.term.Status(StatusWarn, "outside -active-hours %s, pausing transfers", hours)
+	}
+}
+
+func formatWindows(windows []Window) string {
+	strs := make([]string, len(windows))
+	for i, w := range windows {
+		strs[i] = w.String()
+	}
+	return strings.Join(strs, ",")
+}
+
+// acquire acquires a transfer slot once transfers aren't paused, by
+// -active-hours or a signal.
+func (app *App) acquire(ctx context.Context) error {
+	for {
+		if err := app.window.Wait(ctx); err != nil {
+			return err
+		}
+		if err := app.paused.Wait(ctx); err != nil {
+			return err
+		}
+		if err := app.limiter.Acquire(ctx); err != nil {
+			return err
+		}
+		if app.window.IsOpen() && app.paused.IsOpen() {
+			return nil
+		}
+		app.limiter.Release()
+	}
+}
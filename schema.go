@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// schemas are the JSON Schemas of the files and listings iowa writes and
+// reads, by name. Changes to the formats must keep existing documents
+// valid.
+var schemas = map[string]string{
+	"manifest": `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/briansorahan/iowa/schema/manifest.json",
+  "title": "iowa snapshot manifest",
+  "type": "object",
+  "required": ["files"],
+  "properties": {
+    "created": {"type": "string", "format": "date-time"},
+    "pages": {"type": "array", "items": {"$ref": "#/definitions/page"}},
+    "files": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["path"],
+        "properties": {
+          "path": {"type": "string", "minLength": 1},
+          "url": {"type": "string", "minLength": 1},
+          "size": {"type": "integer", "minimum": 0},
+          "sha256": {"type": "string", "pattern": "^([0-9a-f]{64})?$"},
+          "md5": {"type": "string", "pattern": "^([0-9a-f]{32})?$"}
+        }
+      }
+    }
+  },
+  "definitions": {
+    "page": {
+      "type": "object",
+      "required": ["url"],
+      "properties": {
+        "era": {"type": "string"},
+        "section": {"type": "string"},
+        "instrument": {"type": "string"},
+        "url": {"type": "string", "minLength": 1}
+      }
+    }
+  }
+}`,
+	"list": `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/briansorahan/iowa/schema/list.json",
+  "title": "iowa page list",
+  "type": "array",
+  "items": {"type": "string", "minLength": 1}
+}`,
+	"sections": `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/briansorahan/iowa/schema/sections.json",
+  "title": "iowa sections -format json",
+  "type": "array",
+  "items": {
+    "type": "object",
+    "required": ["era", "section", "instruments"],
+    "properties": {
+      "era": {"type": "string"},
+      "section": {"type": "string"},
+      "instruments": {"type": "integer", "minimum": 0}
+    }
+  }
+}`,
+	"history": `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/briansorahan/iowa/schema/history.json",
+  "title": "iowa history -format json",
+  "type": "array",
+  "items": {
+    "type": "object",
+    "required": ["id", "command", "args", "started"],
+    "properties": {
+      "id": {"type": "integer", "minimum": 1},
+      "command": {"type": "string"},
+      "args": {"type": "array", "items": {"type": "string"}},
+      "dir": {"type": "string"},
+      "started": {"type": "string", "format": "date-time"},
+      "ended": {"type": "string"},
+      "files": {"type": "integer", "minimum": 0},
+      "finished": {"type": "integer", "minimum": 0},
+      "failed": {"type": "integer", "minimum": 0},
+      "bytes": {"type": "integer", "minimum": 0},
+      "exit_code": {"type": "integer", "minimum": 0},
+      "error": {"type": "string"},
+      "failures": {
+        "type": "array",
+        "items": {
+          "type": "object",
+          "required": ["kind", "error"],
+          "properties": {
+            "kind": {"type": "string"},
+            "url": {"type": "string"},
+            "error": {"type": "string"}
+          }
+        }
+      }
+    }
+  }
+}`,
+	"instruments": `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/briansorahan/iowa/schema/instruments.json",
+  "title": "iowa instruments -format json",
+  "type": "array",
+  "items": {
+    "type": "object",
+    "required": ["era", "section", "instrument", "url"],
+    "properties": {
+      "era": {"type": "string"},
+      "section": {"type": "string"},
+      "instrument": {"type": "string"},
+      "url": {"type": "string", "minLength": 1},
+      "files": {"type": "integer", "minimum": 0}
+    }
+  }
+}`,
+}
+
+// schemaNames returns the names of the schemas, sorted.
+func schemaNames() []string {
+	var names []string
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// schema runs the schema command: "iowa schema name" prints a schema and
+// "iowa schema -validate name file" checks a file against it.
+func (app *App) schema() error {
+	usage := withKind(FailureConfig, errors.New("usage: iowa schema [-validate] "+strings.Join(schemaNames(), "|")+" [file]"))
+
+	if len(app.Args) == 0 || len(app.Args) > 2 || (len(app.Args) == 2) != app.Validate {
+		return usage
+	}
+	s, ok := schemas[app.Args[0]]
+	if !ok {
+		return usage
+	}
+	if !app.Validate {
+		_, err := fmt.Println(s)
+		return err
+	}
+	data, err := ioutil.ReadFile(app.Args[1])
+	if err != nil {
+		return errors.Wrap(err, "reading "+app.Args[1])
+	}
+	if err := ValidateJSON(app.Args[0], data); err != nil {
+		return withKind(FailureVerify, errors.Wrap(err, app.Args[1]))
+	}
+	app.term.Status(StatusOK, "%s is a valid %s", app.Args[1], app.Args[0])
+	return nil
+}
+
+// ValidateJSON checks a document against the schema called name. Only the
+// parts of JSON Schema that iowa's schemas use are supported.
+func ValidateJSON(name string, data []byte) error {
+	var root, doc interface{}
+	if err := json.Unmarshal([]byte(schemas[name]), &root); err != nil {
+		return errors.Wrap(err, "parsing schema "+name)
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return errors.Wrap(err, "parsing")
+	}
+	return validateValue(root.(map[string]interface{}), root.(map[string]interface{}), doc, "")
+}
+
+// validateValue checks v, at the JSON pointer ptr, against schema s.
+func validateValue(root, s map[string]interface{}, v interface{}, ptr string) error {
+	at := func(format string, args ...interface{}) error {
+		if len(ptr) == 0 {
+			ptr = "/"
+		}
+		return errors.Errorf("%s: %s", ptr, fmt.Sprintf(format, args...))
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		def, ok := root["definitions"].(map[string]interface{})[strings.TrimPrefix(ref, "#/definitions/")].(map[string]interface{})
+		if !ok {
+			return at("unknown $ref %s", ref)
+		}
+		return validateValue(root, def, v, ptr)
+	}
+	switch s["type"] {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return at("expected an object")
+		}
+		required, _ := s["required"].([]interface{})
+		for _, r := range required {
+			if _, ok := obj[r.(string)]; !ok {
+				return at("missing %s", r)
+			}
+		}
+		props, _ := s["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if ps, ok := props[k].(map[string]interface{}); ok {
+				if err := validateValue(root, ps, obj[k], ptr+"/"+k); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return at("expected an array")
+		}
+		items, _ := s["items"].(map[string]interface{})
+		for i, item := range arr {
+			if err := validateValue(root, items, item, fmt.Sprintf("%s/%d", ptr, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return at("expected a string")
+		}
+		if min, ok := s["minLength"].(float64); ok && len(str) < int(min) {
+			return at("must be at least %v characters", min)
+		}
+		if p, ok := s["pattern"].(string); ok && !regexp.MustCompile(p).MatchString(str) {
+			return at("%q doesn't match %s", str, p)
+		}
+		if s["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return at("expected an RFC 3339 time")
+			}
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return at("expected an integer")
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return at("expected an integer")
+		}
+		if min, ok := s["minimum"].(float64); ok && float64(i) < min {
+			return at("must be at least %v", min)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// SearchIndexFile is the full-text index of the audio files in the output
+// directory that the search command searches.
+const SearchIndexFile = ".iowa-search.json"
+
+// fieldGap separates the positions of the terms of the fields of a
+// document, so that phrases don't match across fields.
+const fieldGap = 100
+
+// SearchDoc is a file in the search index.
+type SearchDoc struct {
+	Path string `json:"path"`
+	URL  string `json:"url,omitempty"`  // Of the file, if the state database has it.
+	Page string `json:"page,omitempty"` // That links to the file, if the scrape cache has it.
+	Sample
+	Era     string `json:"era"`
+	Section string `json:"section"`
+
+	// Analysis is the analysis of the file by the analyze command, nil if
+	// it hasn't been analyzed.
+	Analysis *Analysis `json:"analysis,omitempty"`
+}
+
+// Posting is a document that a term of the search index is in, and the
+// positions of the term in it.
+type Posting struct {
+	Doc       int   `json:"doc"`
+	Positions []int `json:"positions"`
+}
+
+// SearchIndex is an inverted index of the names, articulations, notes and
+// source pages of audio files.
+type SearchIndex struct {
+	Built time.Time            `json:"built"`
+	Docs  []SearchDoc          `json:"docs"`
+	Terms map[string][]Posting `json:"terms"`
+}
+
+// tokenize splits text into lower case terms of letters, digits and #,
+// which notes are spelled with.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '#'
+	})
+}
+
+// fields returns the text of a document that is indexed, by field.
+func (doc SearchDoc) fields() []string {
+	page := ""
+	if len(doc.Page) > 0 {
+		page = InstrumentName(doc.Page) + " " + path.Base(doc.Page)
+	}
+	return []string{
+		doc.Instrument,
+		doc.Era + " " + doc.Section,
+		doc.Articulation,
+		doc.Dynamic,
+		doc.Note + " " + doc.HighNote,
+		doc.Channels,
+		strings.Join(doc.Extra, " "),
+		path.Base(doc.Path),
+		page,
+	}
+}
+
+// NewSearchIndex indexes docs.
+func NewSearchIndex(docs []SearchDoc) *SearchIndex {
+	idx := &SearchIndex{Built: time.Now().UTC(), Docs: docs, Terms: map[string][]Posting{}}
+
+	for i, doc := range docs {
+		positions := map[string][]int{}
+		pos := 0
+		for _, field := range doc.fields() {
+			for _, term := range tokenize(field) {
+				positions[term] = append(positions[term], pos)
+				pos++
+			}
+			pos += fieldGap
+		}
+		for term, ps := range positions {
+			idx.Terms[term] = append(idx.Terms[term], Posting{Doc: i, Positions: ps})
+		}
+	}
+	return idx
+}
+
+// LoadSearchIndex loads the search index in a file.
+func LoadSearchIndex(name string) (*SearchIndex, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var idx SearchIndex
+
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, errors.Wrap(err, "parsing "+name)
+	}
+	return &idx, nil
+}
+
+// Save writes the index to a file.
+func (idx *SearchIndex) Save(name string) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return errors.Wrap(err, "encoding search index")
+	}
+	return errors.Wrap(ioutil.WriteFile(name, append(data, '\n'), 0644), "writing search index")
+}
+
+// SearchQuery is a parsed query. Every clause has to match.
+type SearchQuery []clause
+
+// clause is a term, a prefix (e.g. vib*) or a phrase (e.g. "arco ff").
+type clause struct {
+	terms  []string
+	prefix bool
+}
+
+// ParseSearchQuery parses a query of terms, prefixes and quoted phrases.
+func ParseSearchQuery(s string) (SearchQuery, error) {
+	var q SearchQuery
+
+	for len(s) > 0 {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			break
+		}
+		var word string
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return nil, errors.New("unterminated phrase in query: " + s)
+			}
+			word, s = s[1:end+1], s[end+2:]
+			if terms := tokenize(word); len(terms) > 0 {
+				q = append(q, clause{terms: terms})
+			}
+			continue
+		}
+		if end := strings.IndexAny(s, " \t"); end >= 0 {
+			word, s = s[:end], s[end:]
+		} else {
+			word, s = s, ""
+		}
+		prefix := strings.HasSuffix(word, "*")
+		for _, term := range tokenize(word) {
+			q = append(q, clause{terms: []string{term}})
+		}
+		if prefix && len(q) > 0 {
+			q[len(q)-1].prefix = true
+		}
+	}
+	if len(q) == 0 {
+		return nil, errors.New("empty query")
+	}
+	return q, nil
+}
+
+// Search returns the documents that match every clause of q, best first.
+// With fuzzy, terms also match terms that are a typo or two away.
+func (idx *SearchIndex) Search(q SearchQuery, fuzzy bool) []SearchDoc {
+	var scores map[int]int
+
+	for _, c := range q {
+		matched := idx.match(c, fuzzy)
+		if scores == nil {
+			scores = matched
+			continue
+		}
+		for doc, score := range scores {
+			if m, ok := matched[doc]; ok {
+				scores[doc] = score + m
+			} else {
+				delete(scores, doc)
+			}
+		}
+	}
+	docs := make([]int, 0, len(scores))
+	for doc := range scores {
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		if scores[docs[i]] != scores[docs[j]] {
+			return scores[docs[i]] > scores[docs[j]]
+		}
+		return idx.Docs[docs[i]].Path < idx.Docs[docs[j]].Path
+	})
+	out := make([]SearchDoc, len(docs))
+	for i, doc := range docs {
+		out[i] = idx.Docs[doc]
+	}
+	return out
+}
+
+// match returns the score of every document that matches a clause: 3 for
+// an exact term or phrase, 2 for a prefix and 1 for a fuzzy match.
+func (idx *SearchIndex) match(c clause, fuzzy bool) map[int]int {
+	scores := map[int]int{}
+
+	if len(c.terms) > 1 {
+		for _, doc := range idx.phrase(c.terms) {
+			scores[doc] = 3
+		}
+		return scores
+	}
+	term := c.terms[0]
+	for t, postings := range idx.Terms {
+		score := 0
+		switch {
+		case t == term:
+			score = 3
+		case c.prefix && strings.HasPrefix(t, term):
+			score = 2
+		case fuzzy && !c.prefix && editDistance(t, term) <= fuzziness(term):
+			score = 1
+		}
+		for _, p := range postings {
+			if score > scores[p.Doc] {
+				scores[p.Doc] = score
+			}
+		}
+	}
+	for doc, score := range scores {
+		if score == 0 {
+			delete(scores, doc)
+		}
+	}
+	return scores
+}
+
+// phrase returns the documents that have the terms one after the other.
+func (idx *SearchIndex) phrase(terms []string) []int {
+	positions := make([]map[int]map[int]bool, len(terms))
+	for i, term := range terms {
+		positions[i] = map[int]map[int]bool{}
+		for _, p := range idx.Terms[term] {
+			positions[i][p.Doc] = map[int]bool{}
+			for _, pos := range p.Positions {
+				positions[i][p.Doc][pos] = true
+			}
+		}
+	}
+	var docs []int
+	for doc, starts := range positions[0] {
+	Starts:
+		for start := range starts {
+			for i := 1; i < len(terms); i++ {
+				if !positions[i][doc][start+i] {
+					continue Starts
+				}
+			}
+			docs = append(docs, doc)
+			break
+		}
+	}
+	return docs
+}
+
+// fuzziness is how many edits a fuzzy term may be away from a term of a
+// query: none for terms as short as notes and dynamics, which are a letter
+// or two away from each other, one for short terms and two otherwise.
+func fuzziness(term string) int {
+	switch n := len([]rune(term)); {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	}
+	return 2
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// searchIndex returns the search index of the output directory, building
+// it if there isn't one yet or with -reindex.
+func (app *App) searchIndex() (*SearchIndex, error) {
+	if !app.Reindex {
+		idx, err := LoadSearchIndex(SearchIndexFile)
+		if err == nil {
+			return idx, nil
+		}
+		if !os.IsNotExist(err) {
+			app.term.Status(StatusWarn, "%s, rebuilding it", err)
+		}
+	}
+	insts, err := app.localInstruments(".")
+	if err != nil {
+		return nil, err
+	}
+	urls, err := stateURLs(".")
+	if err != nil {
+		return nil, err
+	}
+	var (
+		pages = app.scrapes.LinkPages()
+		docs  []SearchDoc
+	)
+	for _, inst := range insts {
+		for _, f := range inst.Files {
+			url := urls[f.Path]
+			doc := SearchDoc{
+				Path:    f.Path,
+				URL:     url,
+				Page:    pages[url],
+				Sample:  f.Sample,
+				Era:     inst.Page.Era,
+				Section: inst.Page.Section,
+			}
+			if an, ok := app.analyses.Get(f.Path); ok {
+				doc.Analysis = &an
+			}
+			docs = append(docs, doc)
+		}
+	}
+	idx := NewSearchIndex(docs)
+	if err := idx.Save(SearchIndexFile); err != nil {
+		return nil, err
+	}
+	app.term.Status(StatusOK, "indexed %d files in %s", len(docs), SearchIndexFile)
+	return idx, nil
+}
+
+// search runs the search command: "iowa search [flags] [query]" lists the
+// downloaded files that match every term of the query, or every file in the
+// index without one, e.g. to export the catalog with -format parquet. Terms
+// ending in * match prefixes, quoted phrases match terms one after the
+// other, and with -fuzzy terms also match misspellings.
+func (app *App) search(ctx context.Context) error {
+	var q SearchQuery
+	if len(app.Args) > 0 {
+		var err error
+		if q, err = ParseSearchQuery(strings.Join(app.Args, " ")); err != nil {
+			return withKind(FailureConfig, err)
+		}
+	}
+	idx, err := app.searchIndex()
+	if err != nil {
+		return err
+	}
+	docs := idx.Docs
+	if q != nil {
+		docs = idx.Search(q, app.Fuzzy)
+	}
+
+	rows := make([][]string, len(docs))
+	for i, doc := range docs {
+		rows[i] = []string{doc.Path, doc.Instrument, doc.Articulation, doc.Dynamic, doc.Note, doc.Page}
+	}
+	return app.printList(os.Stdout, docs, []string{"PATH", "INSTRUMENT", "ARTICULATION", "DYNAMIC", "NOTE", "PAGE"}, rows)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Segmentation parameters.
+const (
+	segmentWindow   = 0.01 // Seconds per envelope window.
+	segmentAttack   = 0.9  // Level, relative to the peak, that ends the attack.
+	segmentSustain  = 0.5  // Level, relative to the peak, below which the release starts.
+	segmentMinimum  = 0.5  // Shortest sustain in seconds worth segmenting.
+	segmentLoopScan = 256  // Frames compared when matching loop points.
+)
+
+// Segments are the attack, sustain and release of a sample and a loop
+// in its sustain, in frames from the start of the sample.
+type Segments struct {
+	SustainStart int `json:"sustain_start"`
+	ReleaseStart int `json:"release_start"`
+	LoopStart    int `json:"loop_start"`
+	LoopEnd      int `json:"loop_end"` // Exclusive.
+	Frames       int `json:"frames"`
+}
+
+// Segment finds the attack, sustain and release of a sustained sample from
+// its RMS envelope, and a loop in the sustain between upward zero
+// crossings whose surrounding waveforms match best. It returns false if
+// the sustain is too short to loop.
+func Segment(a *Audio) (Segments, bool) {
+	var (
+		mono = copyAudio(a)
+		win  = int(segmentWindow * float64(a.SampleRate))
+	)
+	MixToMono(mono)
+	if win < 1 {
+		return Segments{}, false
+	}
+	var (
+		env  = make([]float64, mono.Frames()/win)
+		peak float64
+	)
+	for i := range env {
+		var sum float64
+		for _, s := range mono.Data[i*win : (i+1)*win] {
+			sum += s * s
+		}
+		env[i] = math.Sqrt(sum / float64(win))
+		peak = math.Max(peak, env[i])
+	}
+	if peak == 0 {
+		return Segments{}, false
+	}
+	seg := Segments{Frames: a.Frames()}
+	for i, e := range env {
+		if e >= segmentAttack*peak {
+			seg.SustainStart = i * win
+			break
+		}
+	}
+	for i := len(env) - 1; i >= 0; i-- {
+		if env[i] >= segmentSustain*peak {
+			seg.ReleaseStart = (i + 1) * win
+			break
+		}
+	}
+	if float64(seg.ReleaseStart-seg.SustainStart) < segmentMinimum*float64(a.SampleRate) {
+		return Segments{}, false
+	}
+	var (
+		x        = mono.Data
+		crossing = func(i int) bool { return x[i-1] < 0 && x[i] >= 0 }
+		end      = seg.ReleaseStart
+	)
+	if end > len(x)-1 {
+		end = len(x) - 1 // The last window may end the sustain.
+	}
+	for end > seg.SustainStart+segmentLoopScan && !crossing(end) {
+		end--
+	}
+	var (
+		best     = math.Inf(1)
+		midpoint = (seg.SustainStart + end) / 2
+	)
+	for start := seg.SustainStart + segmentLoopScan; start < midpoint; start++ {
+		if !crossing(start) {
+			continue
+		}
+		var diff float64
+		for k := 1; k <= segmentLoopScan; k++ {
+			d := x[start-k] - x[end-k]
+			diff += d * d
+		}
+		if diff < best {
+			best, seg.LoopStart = diff, start
+		}
+	}
+	if seg.LoopStart == 0 {
+		return Segments{}, false
+	}
+	seg.LoopEnd = end
+	return seg, true
+}
+
+// slice returns the frames of a from start to end.
+func (a *Audio) slice(start, end int) *Audio {
+	out := *a
+	out.Data = a.Data[start*a.Channels : end*a.Channels]
+	return &out
+}
+
+// writeSegments writes the attack, up to the loop, the loop and the release
+// of a next to out, e.g. as Cello.arco.ff.sulA.A3.stereo.attack.wav, so that
+// played one after the other they are the whole sample, and returns the
+// loop points of the whole sample.
+func writeSegments(out string, a *Audio, bits int) (*Segments, error) {
+	seg, ok := Segment(a)
+	if !ok {
+		return nil, nil
+	}
+	base := strings.TrimSuffix(out, filepath.Ext(out))
+
+	for _, part := range []struct {
+		name       string
+		start, end int
+	}{
+		{"attack", 0, seg.LoopStart},
+		{"sustain", seg.LoopStart, seg.LoopEnd},
+		{"release", seg.LoopEnd, seg.Frames},
+	} {
+		if err := WriteWAVFile(base+"."+part.name+".wav", a.slice(part.start, part.end), bits); err != nil {
+			return nil, errors.Wrap(err, "writing "+part.name)
+		}
+	}
+	return &seg, nil
+}
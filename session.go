@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/publicsuffix"
+)
+
+// NewCookieJar creates the cookie jar of the shared HTTP client, nil
+// unless -cookies or -login asks for one.
+func NewCookieJar(conf Config) (http.CookieJar, error) {
+	if !conf.Cookies && len(conf.Login) == 0 {
+		return nil, nil
+	}
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return jar, errors.Wrap(err, "creating cookie jar")
+}
+
+// login makes the -login request, keeping the session cookies it sets in
+// the cookie jar. With -login-data it POSTs the form, read from a file if
+// it starts with @, as with curl. It is made before the first request.
+func (app *App) login(ctx context.Context) error {
+	method, body := http.MethodGet, ""
+
+	if data := app.LoginData; len(data) > 0 {
+		if strings.HasPrefix(data, "@") {
+			b, err := ioutil.ReadFile(data[1:])
+			if err != nil {
+				return withKind(FailureConfig, errors.Wrap(err, "reading login data"))
+			}
+			data = strings.TrimSpace(string(b))
+		}
+		method, body = http.MethodPost, data
+	}
+	req, err := http.NewRequest(method, app.Login, strings.NewReader(body))
+	if err != nil {
+		return withKind(FailureConfig, errors.Wrap(err, "creating login request"))
+	}
+	app.authorize(req)
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := app.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "logging in")
+	}
+	_ = resp.Body.Close() // Best effort.
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return errors.New("logging in: " + resp.Status)
+	}
+	return nil
+}
+
+// ParseHeaders parses curl-style "Name: value" headers.
+func ParseHeaders(entries []string) (http.Header, error) {
+	header := http.Header{}
+
+	for _, entry := range entries {
+		i := strings.Index(entry, ":")
+		if i <= 0 {
+			return nil, errors.New("expected Name: value, got " + entry)
+		}
+		header.Add(strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:]))
+	}
+	return header, nil
+}
+
+// authorize adds the -header's and the basic auth credentials of -user
+// to a request.
+func (app *App) authorize(req *http.Request) {
+	for k, v := range app.header {
+		req.Header[k] = v
+	}
+	if i := strings.Index(app.User, ":"); i >= 0 {
+		req.SetBasicAuth(app.User[:i], app.User[i+1:])
+	}
+}
+
+// session logs in, once, if there is a -login. Every request waits for
+// it, and fails if it did.
+func (app *App) session(ctx context.Context) error {
+	if len(app.Login) == 0 {
+		return nil
+	}
+	app.loginOnce.Do(func() { app.loginErr = app.login(ctx) })
+	return app.loginErr
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// SFZ exports an SFZ instrument per instrument. Each articulation plays on
+// its own MIDI channel (wrapping around after 16), its dynamics are mapped
+// to velocity ranges, and every note plays the nearest sample. Notes with
+// several takes cycle through them as round robins.
+type SFZ struct {
+	fill     Fill
+	velocity Velocity
+}
+
+// NewSFZ creates an SFZ exporter.
+func NewSFZ(conf Config, engine Engine) Exporter {
+	return SFZ{fill: conf.Fill, velocity: conf.Velocity}
+}
+
+// Export writes <instrument>.sfz.
+func (sfz SFZ) Export(inst Instrument) error {
+	layers := Layers(inst.Files)
+	if len(layers) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "// %s (%s/%s)\n", inst.Page.Instrument, inst.Page.Era, inst.Page.Section)
+	fmt.Fprintf(&buf, "// Generated by iowa from %s\n", inst.Page.URL)
+
+	for i, art := range groupLayers(layers) {
+		var (
+			ch     = i%16 + 1
+			ranges = sfz.velocity.Ranges(len(art))
+		)
+		fmt.Fprintf(&buf, "\n// %s on channel %d\n", art[0].Articulation, ch)
+
+		for j, layer := range art {
+			r := ranges[j]
+			fmt.Fprintf(&buf, "<group> // %s\nlochan=%d hichan=%d lovel=%d hivel=%d\n", layer.Name(), ch, ch, r.Low, r.High)
+			if r.FadeIn > 0 {
+				fmt.Fprintf(&buf, "xfin_lovel=%d xfin_hivel=%d\n", r.Low, r.Low+r.FadeIn)
+			}
+			if r.FadeOut > 0 {
+				fmt.Fprintf(&buf, "xfout_lovel=%d xfout_hivel=%d\n", r.High-r.FadeOut, r.High)
+			}
+			if r.FadeIn > 0 || r.FadeOut > 0 {
+				buf.WriteString("xf_velcurve=power\n")
+			}
+			for k, keys := range layer.KeyRanges(sfz.fill) {
+				takes := layer.Takes[k]
+				for t, f := range takes {
+					fmt.Fprintf(&buf, "<region> lokey=%d hikey=%d pitch_keycenter=%d", keys[0], keys[1], f.MIDI)
+					if len(takes) > 1 {
+						fmt.Fprintf(&buf, " seq_length=%d seq_position=%d", len(takes), t+1)
+					}
+					fmt.Fprintf(&buf, " sample=%s\n", f.Path)
+				}
+			}
+		}
+	}
+	if err := ioutil.WriteFile(inst.Name()+".sfz", buf.Bytes(), 0644); err != nil {
+		return errors.Wrap(err, "writing sfz file")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// signatureSuffix is appended to the name of a file for its signature.
+const signatureSuffix = ".asc"
+
+// Sign has gpg write an armored detached signature of a file next to it
+// and returns the signature's name. key selects the key to sign with, the
+// default key of gpg if it is empty. The signature can be checked with
+// "gpg --verify name.asc name".
+func Sign(ctx context.Context, name, key string) (string, error) {
+	gpg, err := exec.LookPath("gpg")
+	if err != nil {
+		return "", errors.Wrap(err, "finding gpg")
+	}
+	var (
+		sig  = name + signatureSuffix
+		args = []string{"--batch", "--yes", "--armor", "--detach-sign", "--output", sig}
+	)
+	if len(key) > 0 {
+		args = append(args, "--local-user", key)
+	}
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, gpg, append(args, name)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			err = errors.Wrap(err, msg)
+		}
+		return "", errors.Wrap(err, "signing "+name)
+	}
+	return sig, nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// Size of the waveform images of the site.
+const (
+	waveformWidth  = 600
+	waveformHeight = 64
+)
+
+// siteInstrument is an instrument page of the site.
+type siteInstrument struct {
+	Page  Page
+	Href  string // Of the page, relative to the site.
+	Files []siteFile
+}
+
+// siteFile is a row of the note table of an instrument page.
+type siteFile struct {
+	File
+	Name     string
+	Size     string
+	Audio    string // Relative to the site.
+	Waveform string // Relative to the site, empty if the file couldn't be decoded.
+}
+
+// site runs the site command: "iowa site build [flags] [dir]" renders a
+// static HTML site of the audio files under dir (the current directory by
+// default) to -site-dir, with a page for every instrument that plays its
+// files and draws their waveforms. The audio files are linked into the
+// site, or copied if they can't be, so the directory can be published to
+// any static host as it is.
+func (app *App) site(ctx context.Context) error {
+	if app.Action != "build" || len(app.Args) > 1 {
+		return withKind(FailureConfig, errors.New("usage: iowa site build [flags] [dir]"))
+	}
+	root := "."
+	if len(app.Args) == 1 {
+		root = app.Args[0]
+	}
+	insts, err := app.localInstruments(root)
+	if err != nil {
+		return err
+	}
+	var (
+		pages = make([]siteInstrument, len(insts))
+		names = map[string]bool{}
+	)
+	for i, inst := range insts {
+		name := sitePageName(inst.Page)
+		for n := 2; names[name]; n++ {
+			name = fmt.Sprintf("%s-%d", sitePageName(inst.Page), n)
+		}
+		names[name] = true
+
+		pages[i] = siteInstrument{Page: inst.Page, Href: name + ".html"}
+	}
+	for i, inst := range insts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		files, err := app.publishFiles(ctx, root, inst.Files)
+		if err != nil {
+			return errors.Wrap(err, "building page of "+inst.Page.Instrument)
+		}
+		pages[i].Files = files
+
+		if err := writeTemplate(filepath.Join(app.SiteDir, pages[i].Href), instrumentTemplate, pages[i]); err != nil {
+			return err
+		}
+		app.term.Status(StatusOK, "%s", filepath.Join(app.SiteDir, pages[i].Href))
+	}
+	sort.SliceStable(pages, func(i, j int) bool { return pages[i].Page.String() < pages[j].Page.String() })
+
+	if err := writeTemplate(filepath.Join(app.SiteDir, "index.html"), indexTemplate, pages); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(app.SiteDir, "style.css"), []byte(siteStyle), 0644); err != nil {
+		return errors.Wrap(err, "writing style sheet")
+	}
+	app.term.Status(StatusOK, "%s: %d instruments", filepath.Join(app.SiteDir, "index.html"), len(pages))
+	return nil
+}
+
+// sitePageName returns the name of the page of an instrument, without an
+// extension, e.g. post-2012-strings-cello.
+func sitePageName(p Page) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, p.Era+"-"+p.Section+"-"+p.Instrument), "-")
+}
+
+// publishFiles links the files of an instrument under root into the site
+// and draws their waveforms, as many at once as -jobs allows.
+func (app *App) publishFiles(ctx context.Context, root string, files []File) ([]siteFile, error) {
+	var (
+		out = make([]siteFile, len(files))
+		g   errgroup.Group
+	)
+	for i, f := range files {
+		i, f := i, f
+		g.Go(func() error {
+			if err := app.limiter.Acquire(ctx); err != nil {
+				return err
+			}
+			defer app.limiter.Release()
+
+			name, err := filepath.Rel(root, filepath.FromSlash(f.Path))
+			if err != nil {
+				return errors.Wrap(err, f.Path)
+			}
+			var (
+				rel      = filepath.ToSlash(name)
+				audio    = path.Join("audio", rel)
+				waveform = path.Join("waveforms", rel+".svg")
+			)
+			info, err := os.Stat(f.Path)
+			if err != nil {
+				return err
+			}
+			if err := publishFile(f.Path, filepath.Join(app.SiteDir, filepath.FromSlash(audio))); err != nil {
+				return errors.Wrap(err, "publishing "+f.Path)
+			}
+			if err := writeWaveform(app.engine, f.Path, filepath.Join(app.SiteDir, filepath.FromSlash(waveform))); err != nil {
+				app.term.Status(StatusWarn, "%s: no waveform: %s", f.Path, err)
+				waveform = ""
+			}
+			out[i] = siteFile{
+				File:     f,
+				Name:     path.Base(rel),
+				Size:     FormatBytes(info.Size()),
+				Audio:    audio,
+				Waveform: waveform,
+			}
+			return nil
+		})
+	}
+	return out, g.Wait()
+}
+
+// upToDate returns true if dst exists and is no older than src.
+func upToDate(src, dst string) bool {
+	si, err := os.Stat(src)
+	if err != nil {
+		return false
+	}
+	di, err := os.Stat(dst)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(si, di) || !di.ModTime().Before(si.ModTime())
+}
+
+// publishFile hard links name to dst, or copies it if it can't be linked,
+// e.g. across file systems. An up to date dst is kept.
+func publishFile(name, dst string) error {
+	if upToDate(name, dst) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	_ = os.Remove(dst) // It may not be there.
+	if err := os.Link(name, dst); err == nil {
+		return nil
+	}
+	part := dst + partSuffix
+	f, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(f, name); err != nil {
+		_ = f.Close()       // Best effort.
+		_ = os.Remove(part) // Best effort.
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(part, dst)
+}
+
+// writeWaveform draws the waveform of an audio file, decoded with engine, to
+// an SVG image, unless the image is up to date.
+func writeWaveform(engine Engine, name, dst string) error {
+	if upToDate(name, dst) {
+		return nil
+	}
+	a, err := engine.Decode(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, waveformSVG(a), 0644)
+}
+
+// waveformSVG draws the peaks of every channel of a, mixed together, as an
+// SVG image waveformWidth wide.
+func waveformSVG(a *Audio) []byte {
+	var (
+		buf    bytes.Buffer
+		frames = a.Frames()
+		mid    = float64(waveformHeight) / 2
+		lows   = make([]float64, waveformWidth)
+		highs  = make([]float64, waveformWidth)
+	)
+	for x := range highs {
+		start, end := x*frames/waveformWidth, (x+1)*frames/waveformWidth
+		for i := start * a.Channels; i < end*a.Channels; i++ {
+			highs[x] = math.Max(highs[x], a.Data[i])
+			lows[x] = math.Min(lows[x], a.Data[i])
+		}
+	}
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" preserveAspectRatio="none">`, waveformWidth, waveformHeight)
+	fmt.Fprintf(&buf, `<path fill="#357" d="M0 %.1f`, mid)
+	for x, v := range highs {
+		fmt.Fprintf(&buf, "L%d %.1f", x, mid-v*mid)
+	}
+	for x := len(lows) - 1; x >= 0; x-- {
+		fmt.Fprintf(&buf, "L%d %.1f", x, mid-lows[x]*mid)
+	}
+	buf.WriteString("Z\"/></svg>\n")
+	return buf.Bytes()
+}
+
+// writeTemplate renders a template of the site to a file.
+func writeTemplate(name string, t *template.Template, data interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return errors.Wrap(err, "making site directory")
+	}
+	var buf bytes.Buffer
+
+	if err := t.Execute(&buf, data); err != nil {
+		return errors.Wrap(err, "rendering "+name)
+	}
+	return errors.Wrap(ioutil.WriteFile(name, buf.Bytes(), 0644), "writing "+name)
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>University of Iowa Musical Instrument Samples</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+<h1>University of Iowa Musical Instrument Samples</h1>
+<table>
+<tr><th>Era</th><th>Section</th><th>Instrument</th><th>Files</th></tr>
+{{- range .}}
+<tr><td>{{.Page.Era}}</td><td>{{.Page.Section}}</td><td><a href="{{.Href}}">{{.Page.Instrument}}</a></td><td>{{len .Files}}</td></tr>
+{{- end}}
+</table>
+<p>Samples from <a href="http://theremin.music.uiowa.edu/MIS.html">theremin.music.uiowa.edu</a>.</p>
+</body>
+</html>
+`))
+
+var instrumentTemplate = template.Must(template.New("instrument").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Page.Instrument}} ({{.Page.Era}}/{{.Page.Section}})</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+<p><a href="index.html">All instruments</a></p>
+<h1>{{.Page.Instrument}}</h1>
+<p>{{.Page.Era}}/{{.Page.Section}}, {{len .Files}} files</p>
+<table>
+<tr><th>Note</th><th>Dynamic</th><th>Articulation</th><th>Channels</th><th>File</th><th>Size</th></tr>
+{{- range .Files}}
+<tr>
+<td>{{.Note}}{{if and .HighNote (ne .HighNote .Note)}}-{{.HighNote}}{{end}}</td><td>{{.Dynamic}}</td><td>{{.Articulation}}</td><td>{{.Channels}}</td>
+<td>{{if .Waveform}}<img class="waveform" src="{{.Waveform}}" alt="waveform of {{.Name}}"><br>{{end}}<audio controls preload="none" src="{{.Audio}}"></audio><br><a href="{{.Audio}}">{{.Name}}</a></td>
+<td>{{.Size}}</td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+const siteStyle = `body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border-bottom: 1px solid #ccc; padding: 0.4em 0.8em; text-align: left; vertical-align: top; }
+.waveform { width: 300px; height: 48px; }
+`
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// snapshotTime is the format of the timestamp snapshot manifests are named
+// by, which sorts by time and is safe in file names on every platform.
+const snapshotTime = "20060102T150405Z"
+
+// Manifest is a snapshot of the state of the collection that a run
+// downloaded, for citing it.
+type Manifest struct {
+	Created time.Time      `json:"created"`
+	Pages   []Page         `json:"pages"`
+	Files   []ManifestFile `json:"files"`
+}
+
+// ManifestFile is a file in a manifest.
+type ManifestFile struct {
+	Path   string `json:"path"`
+	URL    string `json:"url,omitempty"` // Empty for files iowa didn't download, e.g. in bundles.
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5,omitempty"`
+}
+
+// writeSnapshot writes a snapshot of files, downloaded from pages, to a new
+// read-only file in the -snapshot directory and returns its name.
+func (app *App) writeSnapshot(pages []Page, files []File) (string, error) {
+	snap := Manifest{Created: time.Now().UTC(), Pages: pages}
+
+	for _, f := range files {
+		if f.Checksums == nil {
+			f.Checksums = app.knownChecksums(f)
+		}
+		sf, err := manifestFile(f, app.MD5)
+		if err != nil {
+			return "", err
+		}
+		snap.Files = append(snap.Files, sf)
+	}
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "encoding snapshot")
+	}
+	if err := os.MkdirAll(app.Snapshot, os.ModePerm); err != nil {
+		return "", errors.Wrap(err, "making snapshot directory")
+	}
+	name := filepath.Join(app.Snapshot, snap.Created.Format(snapshotTime)+".json")
+
+	// Snapshots are never overwritten.
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return "", errors.Wrap(err, "creating snapshot")
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close() // Best effort.
+		return "", errors.Wrap(err, "writing snapshot")
+	}
+	if app.Fsync {
+		if err := f.Sync(); err != nil {
+			_ = f.Close() // Best effort.
+			return "", errors.Wrap(err, "syncing snapshot")
+		}
+	}
+	if err := f.Close(); err != nil {
+		return "", errors.Wrap(err, "writing snapshot")
+	}
+	if app.Fsync {
+		if err := syncDir(app.Snapshot); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+// manifestFile returns the manifest entry of a downloaded file, with the
+// checksums computed while it was downloaded. Files that weren't, e.g.
+// because they were already there, are read to checksum them.
+func manifestFile(f File, withMD5 bool) (ManifestFile, error) {
+	c := f.Checksums
+	if c == nil || (withMD5 && len(c.MD5) == 0) {
+		var err error
+		if c, err = hashFile(f.Path, withMD5); err != nil {
+			return ManifestFile{}, err
+		}
+	}
+	return ManifestFile{
+		Path:   f.Path,
+		URL:    f.URL,
+		Size:   c.Size,
+		SHA256: c.SHA256,
+		MD5:    c.MD5,
+	}, nil
+}
+
+// LoadManifest loads a snapshot manifest.
+func LoadManifest(name string) (Manifest, error) {
+	var m Manifest
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return m, errors.Wrap(err, "reading manifest")
+	}
+	if err := ValidateJSON("manifest", data); err != nil {
+		return m, errors.Wrap(err, name)
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, errors.Wrap(err, "parsing "+name)
+	}
+	return m, nil
+}
+
+// latestSnapshot returns the name of the newest manifest in dir.
+func latestSnapshot(dir string) (string, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return "", errors.Wrap(err, "listing snapshots")
+	}
+	if len(names) == 0 {
+		return "", errors.New("no snapshots in " + dir)
+	}
+	sort.Strings(names)
+	return names[len(names)-1], nil
+}
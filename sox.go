@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SoX exports a shell script per instrument that runs the processing
+// pipeline over the instrument's files with sox instead of in-process.
+type SoX struct {
+	pipeline Pipeline
+}
+
+// NewSoX creates a SoX script exporter.
+func NewSoX(conf Config, engine Engine) Exporter {
+	return SoX{pipeline: conf.Pipeline}
+}
+
+// Export writes <instrument>.sox.sh.
+func (sx SoX) Export(inst Instrument) error {
+	var (
+		buf  bytes.Buffer
+		dirs = map[string]bool{}
+	)
+	buf.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&buf, "# %s (%s/%s)\n", inst.Page.Instrument, inst.Page.Era, inst.Page.Section)
+	fmt.Fprintf(&buf, "# Generated by iowa from %s\n", inst.Page.URL)
+	buf.WriteString("set -e\ncd \"$(dirname \"$0\")\"\n\n")
+
+	for _, f := range inst.Files {
+		out := sx.pipeline.Output(f.Path)
+		if dir := filepath.Dir(out); !dirs[dir] {
+			dirs[dir] = true
+			fmt.Fprintf(&buf, "mkdir -p %s\n", shellQuote(dir))
+		}
+		fmt.Fprintf(&buf, "sox %s\n", strings.Join(sx.args(f.Path, out), " "))
+	}
+	if err := ioutil.WriteFile(inst.Name()+".sox.sh", buf.Bytes(), 0755); err != nil {
+		return errors.Wrap(err, "writing sox script")
+	}
+	return nil
+}
+
+// args returns the sox arguments that process in to out the same way
+// Pipeline.Apply does.
+func (sx SoX) args(in, out string) []string {
+	p := sx.pipeline
+	args := []string{shellQuote(in)}
+
+	if p.Bits > 0 {
+		args = append(args, "-b", strconv.Itoa(p.Bits))
+	}
+	args = append(args, shellQuote(out))
+
+	if p.Trim < 0 {
+		threshold := strconv.FormatFloat(p.Trim, 'g', -1, 64) + "d"
+		args = append(args, "silence", "1", "0", threshold, "reverse", "silence", "1", "0", threshold, "reverse")
+	}
+	if p.Mono {
+		args = append(args, "channels", "1")
+	}
+	if p.Rate > 0 {
+		args = append(args, "rate", "-v", strconv.Itoa(p.Rate))
+	}
+	if p.Normalize != nil {
+		args = append(args, "gain", "-n", strconv.FormatFloat(*p.Normalize, 'g', -1, 64))
+	}
+	return args
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
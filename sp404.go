@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// SP404 exports each instrument to the IMPORT folder of an SP-404MKII SD
+// card as 48 kHz, 16-bit WAV files, e.g. IMPORT/CELLO12/ARCO-FF-SULA-A3.wav.
+// Copy the IMPORT folder to the root of the card and import the samples
+// from the sampler's utility menu.
+type SP404 struct {
+	engine Engine
+}
+
+// NewSP404 creates an SP-404MKII exporter.
+func NewSP404(conf Config, engine Engine) Exporter {
+	return SP404{engine: engine}
+}
+
+// Export converts the instrument's files into IMPORT/<INSTRUMENT>.
+func (sp SP404) Export(inst Instrument) error {
+	var (
+		dir   = filepath.Join("IMPORT", strings.ToUpper(shortInstrumentName(inst.Page)))
+		names = map[string]int{}
+	)
+	return convertFiles(sp.engine, dir, inst.Files, 16, func(f File) string {
+		return strings.ToUpper(uniqueName(names, shortSampleName(f)))
+	}, func(a *Audio) *Audio {
+		return Resample(a, 48000)
+	})
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// StateFile is the database of the state of every file iowa has
+// downloaded, in the directory it downloads to.
+const StateFile = ".iowa-state.db"
+
+// stateTimeout is how long opening the state database waits for another
+// run that has it open.
+const stateTimeout = time.Second
+
+var filesBucket = []byte("files")
+
+// Statuses of files in the state database.
+const (
+	FileDownloading = "downloading"
+	FileDone        = "done"
+	FileFailed      = "failed"
+)
+
+// FileState is the state of a file, by URL, in the state database.
+type FileState struct {
+	URL       string     `json:"url"`
+	Path      string     `json:"path,omitempty"`
+	Sample    *Sample    `json:"sample,omitempty"` // Parsed from the URL, as the path may not say, e.g. with -layout by-note.
+	Status    string     `json:"status"`
+	Attempts  int        `json:"attempts"` // Downloads started.
+	Error     string     `json:"error,omitempty"`
+	Started   time.Time  `json:"started"`  // Of the last download.
+	Finished  time.Time  `json:"finished"` // Of the last successful download.
+	Updated   time.Time  `json:"updated"`
+	ModTime   time.Time  `json:"mod_time"` // Of the file when Checksums were computed.
+	Checksums *Checksums `json:"checksums,omitempty"`
+}
+
+// State is the state database, which is shared by everything that needs
+// to know about the files of earlier runs.
+type State struct {
+	db *bolt.DB
+}
+
+// OpenState opens the state database, creating it if there isn't one.
+// Only one run can have it open at a time.
+func OpenState(name string) (*State, error) {
+	db, err := bolt.Open(name, 0644, &bolt.Options{Timeout: stateTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, errors.New(name + " is in use, is iowa already running here?")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "opening "+name)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(filesBucket)
+		return err
+	}); err != nil {
+		_ = db.Close() // Best effort.
+		return nil, errors.Wrap(err, "initializing "+name)
+	}
+	return &State{db: db}, nil
+}
+
+// Close closes the database.
+func (s *State) Close() error {
+	return errors.Wrap(s.db.Close(), "closing state")
+}
+
+// File returns the state of the file with a URL, and false if there is
+// none.
+func (s *State) File(url string) (FileState, bool, error) {
+	var (
+		fs FileState
+		ok bool
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(filesBucket).Get([]byte(url))
+		if data == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(data, &fs)
+	})
+	return fs, ok, errors.Wrap(err, "reading state of "+url)
+}
+
+// Files returns the state of every file, by URL.
+func (s *State) Files() ([]FileState, error) {
+	var files []FileState
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(filesBucket).ForEach(func(k, v []byte) error {
+			var fs FileState
+			if err := json.Unmarshal(v, &fs); err != nil {
+				return errors.Wrap(err, string(k))
+			}
+			files = append(files, fs)
+			return nil
+		})
+	})
+	return files, errors.Wrap(err, "reading state")
+}
+
+// Update changes the state of the file with a URL. Concurrent updates are
+// committed together.
+func (s *State) Update(url string, change func(fs *FileState)) error {
+	err := s.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(filesBucket)
+
+		fs := FileState{URL: url}
+		if data := b.Get([]byte(url)); data != nil {
+			if err := json.Unmarshal(data, &fs); err != nil {
+				return err
+			}
+		}
+		change(&fs)
+		fs.Updated = time.Now().UTC()
+
+		data, err := json.Marshal(fs)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(url), data)
+	})
+	return errors.Wrap(err, "updating state of "+url)
+}
+
+// openState opens the state database for a command that downloads or
+// verifies files.
+func (app *App) openState() error {
+	if app.state != nil {
+		return nil
+	}
+	state, err := OpenState(StateFile)
+	if err != nil {
+		return withKind(FailureConfig, err)
+	}
+	app.state = state
+	app.beginRun()
+	return nil
+}
+
+// stateFiles returns the state of the files in the state database of a
+// directory, by path relative to it, for commands that don't download
+// anything. There are none if nothing has been downloaded there.
+func stateFiles(dir string) (map[string]FileState, error) {
+	var (
+		byPath = map[string]FileState{}
+		name   = filepath.Join(dir, StateFile)
+	)
+	if _, err := os.Stat(name); err != nil {
+		return byPath, nil
+	}
+	state, err := OpenState(name)
+	if err != nil {
+		return nil, withKind(FailureConfig, err)
+	}
+	files, err := state.Files()
+	_ = state.Close() // Only read.
+	if err != nil {
+		return nil, err
+	}
+	for _, fs := range files {
+		byPath[fs.Path] = fs
+	}
+	return byPath, nil
+}
+
+// stateURLs returns the URL's of the files in the state database of a
+// directory, by path relative to it, see stateFiles.
+func stateURLs(dir string) (map[string]string, error) {
+	files, err := stateFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	urls := map[string]string{}
+	for name, fs := range files {
+		urls[name] = fs.URL
+	}
+	return urls, nil
+}
+
+// record changes the state of a file, if the state database is open,
+// warning about failures rather than failing the run.
+func (app *App) record(url string, change func(fs *FileState)) {
+	if app.state == nil || len(url) == 0 {
+		return
+	}
+	if err := app.state.Update(url, change); err != nil {
+		app.term.Status(StatusWarn, "%s", err)
+	}
+}
+
+// recordDone records that a file was downloaded to name, or found there,
+// with the metadata parsed from its URL and its checksums if they are
+// known.
+func (app *App) recordDone(url, name string, s *Sample, c *Checksums) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return
+	}
+	app.record(url, func(fs *FileState) {
+		if fs.Path != name || !fs.ModTime.Equal(info.ModTime()) {
+			fs.Checksums, fs.ModTime = nil, time.Time{}
+		}
+		fs.Path, fs.Status, fs.Error = name, FileDone, ""
+		if s != nil {
+			fs.Sample = s
+		}
+		if c != nil {
+			fs.Checksums, fs.ModTime = c, info.ModTime()
+			fs.Finished = time.Now().UTC()
+		}
+	})
+}
+
+// knownChecksums returns the checksums of a file recorded in the state
+// database, nil if they aren't known or the file has changed since.
+func (app *App) knownChecksums(f File) *Checksums {
+	if app.state == nil {
+		return nil
+	}
+	fs, ok, err := app.state.File(f.URL)
+	if err != nil || !ok || fs.Path != f.Path || fs.Checksums == nil {
+		return nil
+	}
+	info, err := os.Stat(f.Path)
+	if err != nil || !info.ModTime().Equal(fs.ModTime) || info.Size() != fs.Checksums.Size {
+		return nil
+	}
+	return fs.Checksums
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// LinkCountsFile is the file in the output directory that records how
+// many audio links each instrument page had the last time it was scraped.
+const LinkCountsFile = ".iowa-links.json"
+
+// maxLinkChange is how many times more or fewer links than last time a
+// page may have in strict mode.
+const maxLinkChange = 2
+
+// maxShortfall is the fraction of the expected links a page may be
+// missing, see Expect.
+const maxShortfall = 0.1
+
+// LinkCounts are the number of audio links of each instrument page, by URL.
+type LinkCounts struct {
+	mu     sync.Mutex
+	name   string
+	counts map[string]int
+}
+
+// LoadLinkCounts loads the link counts recorded in a file, if it exists.
+func LoadLinkCounts(name string) (*LinkCounts, error) {
+	lc := &LinkCounts{name: name, counts: map[string]int{}}
+
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return lc, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading link counts")
+	}
+	if err := json.Unmarshal(data, &lc.counts); err != nil {
+		return nil, errors.Wrap(err, "parsing "+name)
+	}
+	return lc, nil
+}
+
+// Check returns an error if a page has no links, or many more or fewer
+// than it had last time, which usually means the site has changed.
+func (lc *LinkCounts) Check(url string, n int) error {
+	lc.mu.Lock()
+	last, ok := lc.counts[url]
+	lc.mu.Unlock()
+
+	switch {
+	case n == 0:
+		return errors.New("no audio links")
+	case ok && (n*maxLinkChange < last || n > last*maxLinkChange):
+		return errors.Errorf("%d audio links, %d last time", n, last)
+	}
+	return nil
+}
+
+// Expect returns an error if a page has significantly fewer links than
+// the counts, used as a baseline, say it should, e.g. because it didn't
+// load completely. Pages missing from the baseline aren't checked.
+func (lc *LinkCounts) Expect(url string, n int) error {
+	lc.mu.Lock()
+	want, ok := lc.counts[url]
+	lc.mu.Unlock()
+
+	if ok && float64(n) < float64(want)*(1-maxShortfall) {
+		return errors.Errorf("%d audio links, expected %d", n, want)
+	}
+	return nil
+}
+
+// Record records the number of links of a page and saves the counts.
+func (lc *LinkCounts) Record(url string, n int) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	lc.counts[url] = n
+
+	data, err := json.MarshalIndent(lc.counts, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding link counts")
+	}
+	if err := ioutil.WriteFile(lc.name, append(data, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "writing link counts")
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// SyncFile is the file in the output directory that records the paths of
+// the files each instrument page had the last time it was synced.
+const SyncFile = ".iowa-sync.json"
+
+// syncTree runs the sync subcommand: it downloads the files of the selected
+// pages that are new or have changed, then reports local files that pages
+// no longer link to, removing them with -delete. Only files that an
+// earlier sync recorded in SyncFile are considered, so files from other
+// sections and ones downloaded without sync are never removed.
+func (app *App) syncTree(ctx context.Context) error {
+	if app.Stream {
+		return withKind(FailureConfig, errors.New("sync keeps the original files, it can't be used with -stream"))
+	}
+	pages, err := app.pages()
+	if err != nil {
+		return errors.Wrap(err, "getting urls")
+	}
+	last, err := loadSynced(SyncFile)
+	if err != nil {
+		return err
+	}
+	app.IfChanged = true
+	app.synced = map[string][]string{}
+
+	// Don't remove anything unless every page was synced completely.
+	if err := app.downloadPages(ctx, pages); err != nil {
+		return err
+	}
+	if app.quota.Reached() {
+		app.term.Status(StatusWarn, "not looking for files that are no longer on the site, the download limit was reached")
+		return app.validators.Save()
+	}
+	current := map[string][]string{}
+	for url, paths := range last {
+		current[url] = paths
+	}
+	for url, paths := range app.synced {
+		current[url] = paths
+	}
+	kept := map[string]bool{}
+	for _, paths := range current {
+		for _, p := range paths {
+			kept[p] = true
+		}
+	}
+	var stale []string
+	for url := range app.synced {
+		for _, p := range last[url] {
+			if kept[p] {
+				continue
+			}
+			stale = append(stale, p)
+			kept[p] = true // Once.
+
+			// Keep reporting it until it is removed.
+			if !app.Delete {
+				current[url] = append(current[url], p)
+			}
+		}
+	}
+	sort.Strings(stale)
+
+	for _, p := range stale {
+		if !app.Delete {
+			app.term.Status(StatusWarn, "%s: no longer on the site", p)
+			continue
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "removing "+p)
+		}
+		app.validators.Put(p, Validator{})
+		app.term.Status(StatusOK, "%s: removed, it is no longer on the site", p)
+	}
+	if len(stale) > 0 && !app.Delete {
+		app.term.Status(StatusWarn, "%d files are no longer on the site, sync with -delete to remove them", len(stale))
+	}
+	if err := app.validators.Save(); err != nil {
+		return err
+	}
+	return saveSynced(SyncFile, current)
+}
+
+// loadSynced loads the paths recorded by the last sync, if there was one.
+func loadSynced(name string) (map[string][]string, error) {
+	synced := map[string][]string{}
+
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return synced, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading synced files")
+	}
+	if err := json.Unmarshal(data, &synced); err != nil {
+		return nil, errors.Wrap(err, "parsing "+name)
+	}
+	return synced, nil
+}
+
+// saveSynced records the paths of the files of every page synced.
+func saveSynced(name string, synced map[string][]string) error {
+	data, err := json.MarshalIndent(synced, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding synced files")
+	}
+	if err := ioutil.WriteFile(name, append(data, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "writing synced files")
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TagsFile is the file in the output directory that tags and collections
+// are kept in. It is meant to be edited by hand or committed as well.
+const TagsFile = ".iowa-tags.json"
+
+// Tags are named sets of files, by pattern, and named collections of tags.
+type Tags struct {
+	Tags        map[string][]string `json:"tags"`        // Patterns by tag.
+	Collections map[string][]string `json:"collections"` // Tags by collection.
+}
+
+// LoadTags loads the tags in a file, if it exists.
+func LoadTags(name string) (*Tags, error) {
+	t := &Tags{Tags: map[string][]string{}, Collections: map[string][]string{}}
+
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading tags")
+	}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, errors.Wrap(err, "parsing "+name)
+	}
+	if t.Tags == nil {
+		t.Tags = map[string][]string{}
+	}
+	if t.Collections == nil {
+		t.Collections = map[string][]string{}
+	}
+	return t, nil
+}
+
+// Save writes the tags to a file.
+func (t *Tags) Save(name string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding tags")
+	}
+	return errors.Wrap(ioutil.WriteFile(name, append(data, '\n'), 0644), "writing tags")
+}
+
+// MatchPattern returns true if a glob pattern, e.g. cello*ff*, matches the
+// name of a file, its path or the name of its instrument's directory,
+// ignoring case. p is a slash-separated path.
+func MatchPattern(pattern, p string) bool {
+	pattern, p = strings.ToLower(pattern), strings.ToLower(p)
+	for _, s := range []string{path.Base(p), p, path.Base(path.Dir(p))} {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Has returns true if a file has a tag.
+func (t *Tags) Has(tag, p string) bool {
+	for _, pattern := range t.Tags[tag] {
+		if MatchPattern(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// InCollection returns true if a file has any of the tags of a collection.
+func (t *Tags) InCollection(name, p string) bool {
+	for _, tag := range t.Collections[name] {
+		if t.Has(tag, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// addNamed adds the values to a set of named lists, skipping duplicates.
+func addNamed(sets map[string][]string, name string, values []string) {
+	for _, v := range values {
+		if !contains(sets[name], v) {
+			sets[name] = append(sets[name], v)
+		}
+	}
+}
+
+// removeNamed removes values from a set of named lists, or the whole list
+// if there are none, and returns false if there was nothing to remove.
+func removeNamed(sets map[string][]string, name string, values []string) bool {
+	if _, ok := sets[name]; !ok {
+		return false
+	}
+	if len(values) == 0 {
+		delete(sets, name)
+		return true
+	}
+	var kept []string
+	for _, v := range sets[name] {
+		if !contains(values, v) {
+			kept = append(kept, v)
+		}
+	}
+	removed := len(kept) < len(sets[name])
+	if len(kept) == 0 {
+		delete(sets, name)
+	} else {
+		sets[name] = kept
+	}
+	return removed
+}
+
+// inScope returns true if a file, by its local path, is in the
+// -collection, or there is none.
+func (app *App) inScope(p string) bool {
+	return len(app.Collection) == 0 || app.tags.InCollection(app.Collection, p)
+}
+
+// scopeDownloads returns the downloads that are in the -collection.
+func (app *App) scopeDownloads(downloads []string) []string {
+	if len(app.Collection) == 0 {
+		return downloads
+	}
+	var out []string
+	for _, dl := range downloads {
+		if local, err := LocalPath(dl); err != nil || app.inScope(local) {
+			out = append(out, dl) // Invalid URL's fail later, as they would without a collection.
+		}
+	}
+	return out
+}
+
+// localPaths returns the paths of the audio files in the output directory.
+func (app *App) localPaths() ([]string, error) {
+	insts, err := app.localInstruments(".")
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, inst := range insts {
+		for _, f := range inst.Files {
+			paths = append(paths, f.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// tag runs the tag command:
+//
+//	iowa tag add TAG PATTERN...   tags the files that match the patterns
+//	iowa tag rm TAG [PATTERN...]  removes patterns, or the whole tag
+//	iowa tag list [TAG]           lists the tags, or the files with a tag
+func (app *App) tag(ctx context.Context) error {
+	switch {
+	case app.Action == "add" && len(app.Args) >= 2:
+		addNamed(app.tags.Tags, app.Args[0], app.Args[1:])
+		return app.tags.Save(TagsFile)
+	case app.Action == "rm" && len(app.Args) >= 1:
+		if !removeNamed(app.tags.Tags, app.Args[0], app.Args[1:]) {
+			return withKind(FailureConfig, errors.New("nothing to remove from tag "+app.Args[0]))
+		}
+		return app.tags.Save(TagsFile)
+	case app.Action == "list" && len(app.Args) <= 1:
+		return app.listTagged(app.tags.Tags, app.Args, app.tags.Has)
+	}
+	return withKind(FailureConfig, errors.New("usage: iowa tag add TAG PATTERN... | rm TAG [PATTERN...] | list [TAG]"))
+}
+
+// collection runs the collection command:
+//
+//	iowa collection add NAME TAG...   adds tags to a collection
+//	iowa collection rm NAME [TAG...]  removes tags, or the whole collection
+//	iowa collection list [NAME]       lists the collections, or the files of one
+//	iowa collection export NAME       writes a manifest of the files of one
+//
+// The files of a collection are those with any of its tags. A collection
+// is also the scope of a download or conversion with -collection.
+func (app *App) collection(ctx context.Context) error {
+	switch {
+	case app.Action == "add" && len(app.Args) >= 2:
+		for _, tag := range app.Args[1:] {
+			if _, ok := app.tags.Tags[tag]; !ok {
+				app.term.Status(StatusWarn, "there is no tag %s yet", tag)
+			}
+		}
+		addNamed(app.tags.Collections, app.Args[0], app.Args[1:])
+		return app.tags.Save(TagsFile)
+	case app.Action == "rm" && len(app.Args) >= 1:
+		if !removeNamed(app.tags.Collections, app.Args[0], app.Args[1:]) {
+			return withKind(FailureConfig, errors.New("nothing to remove from collection "+app.Args[0]))
+		}
+		return app.tags.Save(TagsFile)
+	case app.Action == "list" && len(app.Args) <= 1:
+		return app.listTagged(app.tags.Collections, app.Args, app.tags.InCollection)
+	case app.Action == "export" && len(app.Args) == 1:
+		return app.exportCollection(app.Args[0])
+	}
+	return withKind(FailureConfig, errors.New("usage: iowa collection add NAME TAG... | rm NAME [TAG...] | list [NAME] | export NAME"))
+}
+
+// listTagged lists named sets of tags or patterns with the number of
+// files in each, or the files in the one named by args.
+func (app *App) listTagged(sets map[string][]string, args []string, has func(name, p string) bool) error {
+	if len(args) == 1 {
+		if _, ok := sets[args[0]]; !ok {
+			return withKind(FailureConfig, errors.New("there is no "+app.Command+" "+args[0]))
+		}
+	}
+	paths, err := app.localPaths()
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		var (
+			files []string
+			rows  [][]string
+		)
+		for _, p := range paths {
+			if has(args[0], p) {
+				files = append(files, p)
+				rows = append(rows, []string{p})
+			}
+		}
+		if files == nil {
+			files = []string{}
+		}
+		return app.printList(os.Stdout, files, []string{"PATH"}, rows)
+	}
+	var (
+		names = make([]string, 0, len(sets))
+		rows  [][]string
+	)
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		n := 0
+		for _, p := range paths {
+			if has(name, p) {
+				n++
+			}
+		}
+		rows = append(rows, []string{name, strings.Join(sets[name], ","), strconv.Itoa(n)})
+	}
+	members := "PATTERNS"
+	if app.Command == "collection" {
+		members = "TAGS"
+	}
+	return app.printList(os.Stdout, sets, []string{"NAME", members, "FILES"}, rows)
+}
+
+// exportCollection writes a manifest of the files of a collection to
+// stdout, which -manifest downloads elsewhere.
+func (app *App) exportCollection(name string) error {
+	if _, ok := app.tags.Collections[name]; !ok {
+		return withKind(FailureConfig, errors.New("there is no collection "+name))
+	}
+	paths, err := app.localPaths()
+	if err != nil {
+		return err
+	}
+	urls, err := stateURLs(".")
+	if err != nil {
+		return err
+	}
+	m := Manifest{Created: time.Now().UTC(), Files: []ManifestFile{}}
+
+	for _, p := range paths {
+		if !app.tags.InCollection(name, p) {
+			continue
+		}
+		if len(urls[p]) == 0 {
+			app.term.Status(StatusWarn, "%s: not downloaded by iowa, leaving it out", p)
+			continue
+		}
+		f := File{URL: urls[p], Path: p}
+		mf, err := manifestFile(f, app.MD5)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, mf)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(m), "writing manifest")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TALSampler exports a TAL-Sampler JSON preset per articulation of an
+// instrument, e.g. MISCello2012.arco.json, with a zone for every sample.
+// TAL-Sampler has no way to switch articulations within a preset.
+type TALSampler struct {
+	fill     Fill
+	velocity Velocity
+}
+
+// NewTALSampler creates a TAL-Sampler exporter.
+func NewTALSampler(conf Config, engine Engine) Exporter {
+	return TALSampler{fill: conf.Fill, velocity: conf.Velocity}
+}
+
+// talPreset is a TAL-Sampler preset.
+type talPreset struct {
+	Name    string     `json:"name"`
+	Author  string     `json:"author"`
+	Comment string     `json:"comment"`
+	Layers  []talLayer `json:"layers"`
+}
+
+// talLayer is one of the (up to four) sample layers of a preset.
+type talLayer struct {
+	Name  string    `json:"name"`
+	Zones []talZone `json:"zones"`
+}
+
+// talZone maps a sample to a key and velocity range.
+type talZone struct {
+	Sample       string `json:"sample"` // Absolute path.
+	RootKey      int    `json:"rootKey"`
+	KeyLow       int    `json:"keyLow"`
+	KeyHigh      int    `json:"keyHigh"`
+	VelocityLow  int    `json:"velocityLow"`
+	VelocityHigh int    `json:"velocityHigh"`
+}
+
+// Export writes <instrument>.<articulation>.json for every articulation.
+func (tal TALSampler) Export(inst Instrument) error {
+	for _, art := range groupLayers(Layers(inst.Files)) {
+		var (
+			name   = strings.Join(nonEmpty(inst.Name(), art[0].Articulation), ".")
+			ranges = tal.velocity.Ranges(len(art))
+			layer  = talLayer{Name: "A"}
+		)
+		for i, l := range art {
+			for j, keys := range l.KeyRanges(tal.fill) {
+				f := l.Files[j]
+				sample, err := filepath.Abs(f.Path)
+				if err != nil {
+					return errors.Wrap(err, "resolving "+f.Path)
+				}
+				layer.Zones = append(layer.Zones, talZone{
+					Sample:       sample,
+					RootKey:      f.MIDI,
+					KeyLow:       keys[0],
+					KeyHigh:      keys[1],
+					VelocityLow:  ranges[i].Low,
+					VelocityHigh: ranges[i].High,
+				})
+			}
+		}
+		preset := talPreset{
+			Name:    strings.TrimSpace(inst.Page.Instrument + " " + art[0].Articulation),
+			Author:  "University of Iowa Electronic Music Studios",
+			Comment: "Generated by iowa from " + inst.Page.URL,
+			Layers:  []talLayer{layer},
+		}
+		data, err := json.MarshalIndent(preset, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "encoding tal-sampler preset")
+		}
+		if err := ioutil.WriteFile(name+".json", append(data, '\n'), 0644); err != nil {
+			return errors.Wrap(err, "writing tal-sampler preset")
+		}
+	}
+	return nil
+}
+
+// nonEmpty returns the strings that aren't empty.
+func nonEmpty(strs ...string) []string {
+	var out []string
+
+	for _, s := range strs {
+		if len(s) > 0 {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// s3Scheme starts the -tee destinations that are S3 buckets.
+const s3Scheme = "s3://"
+
+// Destination is somewhere downloaded files are written to as well as the
+// local disk, each under the same path.
+type Destination interface {
+	Create(ctx context.Context, name string) (Upload, error)
+}
+
+// Upload is a file being written to a destination. Commit keeps it and
+// Abort throws it away.
+type Upload interface {
+	io.Writer
+	Commit() error
+	Abort()
+}
+
+// NewDestinations creates the -tee destinations: s3://bucket/prefix, which
+// uploads with the aws command line tool, or a directory.
+func NewDestinations(conf Config) ([]Destination, error) {
+	var dests []Destination
+
+	for _, tee := range conf.Tee {
+		if !strings.HasPrefix(tee, s3Scheme) {
+			dests = append(dests, DirDestination(tee))
+			continue
+		}
+		aws, err := exec.LookPath("aws")
+		if err != nil {
+			return nil, withKind(FailureConfig, errors.Wrap(err, "finding aws for "+tee))
+		}
+		dests = append(dests, S3Destination{AWS: aws, URL: strings.TrimSuffix(tee, "/")})
+	}
+	return dests, nil
+}
+
+// DirDestination writes files under a directory.
+type DirDestination string
+
+// Create creates a partial file that is renamed to name under the
+// directory when it is committed. Each upload has its own, so that uploads
+// of the same name, see ConflictOverwrite, don't write over each other.
+func (dir DirDestination) Create(ctx context.Context, name string) (Upload, error) {
+	name = filepath.Join(string(dir), filepath.FromSlash(name))
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, errors.Wrap(err, "creating directory")
+	}
+	f, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".*"+partSuffix)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating file")
+	}
+	if err := f.Chmod(0644); err != nil {
+		_ = f.Close()           // Best effort.
+		_ = os.Remove(f.Name()) // Best effort.
+		return nil, errors.Wrap(err, "creating file")
+	}
+	return dirUpload{File: f, name: name}, nil
+}
+
+type dirUpload struct {
+	*os.File
+	name string
+}
+
+func (u dirUpload) Commit() error {
+	if err := u.Close(); err != nil {
+		_ = os.Remove(u.File.Name()) // Best effort.
+		return errors.Wrap(err, "writing "+u.name)
+	}
+	return errors.Wrap(os.Rename(u.File.Name(), u.name), "renaming partial file")
+}
+
+func (u dirUpload) Abort() {
+	_ = u.Close()                // Best effort.
+	_ = os.Remove(u.File.Name()) // Best effort.
+}
+
+// S3Destination uploads files under an s3://bucket/prefix URL by piping
+// them to "aws s3 cp", with the credentials and region aws is configured
+// with.
+type S3Destination struct {
+	AWS string // Path of the aws command.
+	URL string
+}
+
+// Create starts an upload of name. The object only appears once the
+// upload is committed.
+func (s3 S3Destination) Create(ctx context.Context, name string) (Upload, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	u := &s3Upload{
+		cmd:    exec.CommandContext(ctx, s3.AWS, "s3", "cp", "--only-show-errors", "-", s3.URL+"/"+path.Clean(name)),
+		cancel: cancel,
+	}
+	u.cmd.Stderr = &u.stderr
+
+	var err error
+	if u.WriteCloser, err = u.cmd.StdinPipe(); err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "uploading "+name)
+	}
+	if err := u.cmd.Start(); err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "uploading "+name)
+	}
+	return u, nil
+}
+
+type s3Upload struct {
+	io.WriteCloser // Standard input of aws.
+
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	stderr bytes.Buffer
+}
+
+func (u *s3Upload) Commit() error {
+	defer u.cancel()
+
+	_ = u.Close() // Wait reports any failure.
+	if err := u.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(u.stderr.String()); len(msg) > 0 {
+			err = errors.Wrap(err, msg)
+		}
+		return errors.Wrap(err, "uploading to "+u.cmd.Args[len(u.cmd.Args)-1])
+	}
+	return nil
+}
+
+// Abort kills aws before it completes the upload.
+func (u *s3Upload) Abort() {
+	u.cancel()
+	_ = u.Close()    // Best effort.
+	_ = u.cmd.Wait() // Killed.
+}
+
+// uploads are the uploads of a download to every destination.
+type uploads []Upload
+
+// createUploads starts uploading a download to every destination. The
+// uploads of a resumed download start with the offset bytes of its
+// partial file.
+func (app *App) createUploads(ctx context.Context, name, part string, offset int64) (uploads, error) {
+	var ups uploads
+
+	for _, dest := range app.destinations {
+		up, err := dest.Create(ctx, name)
+		if err != nil {
+			ups.Abort()
+			return nil, err
+		}
+		ups = append(ups, up)
+	}
+	if len(ups) == 0 || offset == 0 {
+		return ups, nil
+	}
+	f, err := os.Open(part)
+	if err != nil {
+		ups.Abort()
+		return nil, errors.Wrap(err, "opening partial file")
+	}
+	defer func() { _ = f.Close() }() // Best effort.
+
+	if _, err := app.buffers.Copy(ups.Writer(), io.LimitReader(f, offset)); err != nil {
+		ups.Abort()
+		return nil, errors.Wrap(err, "copying partial file")
+	}
+	return ups, nil
+}
+
+// Writer returns a writer to all of the uploads, nil if there are none.
+func (ups uploads) Writer() io.Writer {
+	if len(ups) == 0 {
+		return nil
+	}
+	ws := make([]io.Writer, len(ups))
+	for i, up := range ups {
+		ws[i] = up
+	}
+	return io.MultiWriter(ws...)
+}
+
+// Commit commits every upload, returning the first error.
+func (ups uploads) Commit() error {
+	var first error
+	for _, up := range ups {
+		if err := up.Commit(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Abort aborts every upload.
+func (ups uploads) Abort() {
+	for _, up := range ups {
+		up.Abort()
+	}
+}
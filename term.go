@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Status is the outcome of handling a single file.
+type Status int
+
+// Statuses.
+const (
+	StatusOK Status = iota
+	StatusSkip
+	StatusFail
+	StatusWarn
+)
+
+// String returns the label printed for the status.
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusSkip:
+		return "skip"
+	case StatusFail:
+		return "fail"
+	case StatusWarn:
+		return "warn"
+	}
+	return "?"
+}
+
+func (s Status) color() string {
+	switch s {
+	case StatusOK:
+		return "\x1b[32m" // Green
+	case StatusSkip, StatusWarn:
+		return "\x1b[33m" // Yellow
+	case StatusFail:
+		return "\x1b[31m" // Red
+	}
+	return ""
+}
+
+const colorReset = "\x1b[0m"
+
+// Term writes status lines to a terminal, colorizing them when the
+// terminal supports it.
+type Term struct {
+	mu    sync.Mutex
+	w     io.Writer
+	color bool
+}
+
+// NewTerm creates a new terminal writer.
+// Color is only used if w is a TTY and noColor is false.
+func NewTerm(w io.Writer, noColor bool) *Term {
+	return &Term{
+		w:     w,
+		color: !noColor && os.Getenv("NO_COLOR") == "" && IsTerminal(w),
+	}
+}
+
+// Status writes a status line.
+func (t *Term) Status(s Status, format string, args ...interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	label := fmt.Sprintf("%-4s", s)
+	if t.color {
+		label = s.color() + label + colorReset
+	}
+	_, _ = fmt.Fprintf(t.w, label+" "+format+"\n", args...)
+}
+
+// IsTerminal returns true if w is a character device.
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
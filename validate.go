@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ValidateAudioFile checks the structure of an AIFF, AIFC or WAV file
+// without decoding it, see ValidateAudio. Files with other extensions are
+// only checked for being empty. Partial files are checked by the extension
+// of the file they are downloaded to.
+func ValidateAudioFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }() // Best effort.
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return errors.New("empty file")
+	}
+	target, _ := partialTarget(name)
+	switch strings.ToLower(filepath.Ext(target)) {
+	case ".aif", ".aiff", ".aifc", ".wav":
+		return ValidateAudio(f, info.Size())
+	}
+	return nil
+}
+
+// ValidateAudio checks that the chunks of an AIFF, AIFC or WAV file of
+// size bytes add up: the FORM or RIFF chunk and every chunk in it fit in
+// the file, the format chunk is there and the sound data chunk holds as
+// many frames as the format says. A file that was cut short or garbled in
+// transit fails even though it was served with a 200.
+func ValidateAudio(r io.ReaderAt, size int64) error {
+	var header [12]byte
+
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return errors.New("truncated header")
+	}
+	var (
+		order binary.ByteOrder = binary.BigEndian
+		aifc  bool
+	)
+	switch {
+	case string(header[0:4]) == "FORM" && string(header[8:12]) == "AIFF":
+	case string(header[0:4]) == "FORM" && string(header[8:12]) == "AIFC":
+		aifc = true
+	case string(header[0:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		order = binary.LittleEndian
+	default:
+		return errors.New("not an AIFF or WAV file")
+	}
+	end := 8 + int64(order.Uint32(header[4:8]))
+	if end > size {
+		return errors.Errorf("truncated: %s chunk is %d bytes but the file is %d", header[0:4], end, size)
+	}
+	if order == binary.LittleEndian {
+		return validateWAV(r, end)
+	}
+	return validateAIFF(r, end, aifc)
+}
+
+// chunk is the position of a chunk in a file.
+type chunk struct {
+	id   string
+	data int64 // Offset of the chunk's data.
+	size int64
+}
+
+// readChunks returns the chunks between offset 12 and end, checking that
+// each fits.
+func readChunks(r io.ReaderAt, end int64, order binary.ByteOrder) ([]chunk, error) {
+	var chunks []chunk
+
+	for off := int64(12); off+8 <= end; {
+		var header [8]byte
+		if _, err := r.ReadAt(header[:], off); err != nil {
+			return nil, errors.Wrap(err, "reading chunk header")
+		}
+		c := chunk{id: string(header[0:4]), data: off + 8, size: int64(order.Uint32(header[4:8]))}
+		if c.data+c.size > end {
+			return nil, errors.Errorf("truncated: %q chunk at %d is %d bytes but only %d remain", c.id, off, c.size, end-c.data)
+		}
+		chunks = append(chunks, c)
+		off = c.data + c.size + c.size&1
+	}
+	return chunks, nil
+}
+
+func validateAIFF(r io.ReaderAt, end int64, aifc bool) error {
+	chunks, err := readChunks(r, end, binary.BigEndian)
+	if err != nil {
+		return err
+	}
+	var comm, ssnd *chunk
+
+	for i := range chunks {
+		switch chunks[i].id {
+		case "COMM":
+			comm = &chunks[i]
+		case "SSND":
+			ssnd = &chunks[i]
+		}
+	}
+	if comm == nil {
+		return errors.New("no COMM chunk")
+	}
+	if ssnd == nil {
+		return errors.New("no SSND chunk")
+	}
+	if comm.size < 18 {
+		return errors.New("short COMM chunk")
+	}
+	var c [22]byte // Channels, frames, bits, rate and the AIFC compression type.
+
+	n := 18
+	if aifc && comm.size >= 22 {
+		n = 22
+	}
+	if _, err := r.ReadAt(c[:n], comm.data); err != nil {
+		return errors.Wrap(err, "reading COMM chunk")
+	}
+	var (
+		channels = int64(binary.BigEndian.Uint16(c[0:2]))
+		frames   = int64(binary.BigEndian.Uint32(c[2:6]))
+		bits     = int64(binary.BigEndian.Uint16(c[6:8]))
+		format   = sampleFormat{Size: int((bits + 7) / 8)}
+	)
+	if n == 22 {
+		f, ok := aifcFormats[string(c[18:22])]
+		if !ok {
+			return nil // Compressed, the size of the data is unknown.
+		}
+		if f.Size > 0 {
+			format.Size = f.Size
+		}
+	}
+	if channels == 0 || format.Size == 0 {
+		return errors.New("no channels or bit depth")
+	}
+	frame := channels * int64(format.Size)
+
+	if ssnd.size < 8 {
+		return errors.New("short SSND chunk")
+	}
+	var offset [4]byte
+	if _, err := r.ReadAt(offset[:], ssnd.data); err != nil {
+		return errors.Wrap(err, "reading SSND chunk")
+	}
+	if have := (ssnd.size - 8 - int64(binary.BigEndian.Uint32(offset[:]))) / frame; have < frames {
+		return errors.Errorf("SSND chunk holds %d of %d sample frames", have, frames)
+	}
+	return nil
+}
+
+func validateWAV(r io.ReaderAt, end int64) error {
+	chunks, err := readChunks(r, end, binary.LittleEndian)
+	if err != nil {
+		return err
+	}
+	var fmtChunk, data *chunk
+
+	for i := range chunks {
+		switch chunks[i].id {
+		case "fmt ":
+			fmtChunk = &chunks[i]
+		case "data":
+			data = &chunks[i]
+		}
+	}
+	if fmtChunk == nil {
+		return errors.New("no fmt chunk")
+	}
+	if data == nil {
+		return errors.New("no data chunk")
+	}
+	if fmtChunk.size < 16 {
+		return errors.New("short fmt chunk")
+	}
+	var c [16]byte
+
+	if _, err := r.ReadAt(c[:], fmtChunk.data); err != nil {
+		return errors.Wrap(err, "reading fmt chunk")
+	}
+	if channels := binary.LittleEndian.Uint16(c[2:4]); channels == 0 {
+		return errors.New("no channels")
+	}
+	align := int64(binary.LittleEndian.Uint16(c[12:14]))
+	if align == 0 {
+		return errors.New("invalid block alignment")
+	}
+	if data.size%align != 0 {
+		return errors.Errorf("data chunk of %d bytes isn't a whole number of %d byte frames", data.size, align)
+	}
+	return nil
+}
+
+// corruptError is the error of a download that failed validation.
+type corruptError struct {
+	err error
+}
+
+func (e corruptError) Error() string { return "corrupt: " + e.err.Error() }
+func (e corruptError) Cause() error  { return e.err }
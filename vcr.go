@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A cassette is a tar file of HTTP interactions. Each interaction is a
+// pair of entries: NNNNNN.req holds the method, URL and Range of the
+// request on one line, and NNNNNN.resp the response as sent on the wire.
+
+// cassetteKey identifies a request in a cassette.
+func cassetteKey(req *http.Request) string {
+	key := req.Method + " " + req.URL.String()
+	if r := req.Header.Get("Range"); len(r) > 0 {
+		key += " " + r
+	}
+	return key
+}
+
+// Recorder is a transport that records every interaction in a cassette.
+type Recorder struct {
+	transport http.RoundTripper
+
+	mu sync.Mutex
+	f  *os.File
+	tw *tar.Writer
+	n  int
+}
+
+// NewRecorder creates a recorder that writes the interactions of
+// transport to the cassette name.
+func NewRecorder(transport http.RoundTripper, name string) (*Recorder, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating cassette")
+	}
+	return &Recorder{transport: transport, f: f, tw: tar.NewWriter(f)}, nil
+}
+
+// RoundTrip makes the request and records the response.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close() // Best effort.
+	if err != nil {
+		return nil, errors.Wrap(err, "reading response")
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "recording response")
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := fmt.Sprintf("%06d", r.n)
+	r.n++
+	if err := r.write(name+".req", []byte(cassetteKey(req)+"\n")); err != nil {
+		return nil, err
+	}
+	if err := r.write(name+".resp", dump); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// write must be called with r.mu held.
+func (r *Recorder) write(name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := r.tw.WriteHeader(hdr); err != nil {
+		return errors.Wrap(err, "writing cassette")
+	}
+	if _, err := r.tw.Write(data); err != nil {
+		return errors.Wrap(err, "writing cassette")
+	}
+	return nil
+}
+
+// Close finishes the cassette.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.tw.Close(); err != nil {
+		_ = r.f.Close() // Best effort.
+		return errors.Wrap(err, "writing cassette")
+	}
+	return r.f.Close()
+}
+
+// Replayer is a transport that answers requests from a cassette without
+// touching the network. Requests that were made more than once get their
+// responses in the order they were recorded, and the last one after that.
+type Replayer struct {
+	mu        sync.Mutex
+	responses map[string][][]byte
+}
+
+// NewReplayer loads the cassette name.
+func NewReplayer(name string) (*Replayer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening cassette")
+	}
+	defer func() { _ = f.Close() }() // Best effort.
+
+	var (
+		r   = &Replayer{responses: map[string][][]byte{}}
+		tr  = tar.NewReader(f)
+		key string
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return r, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "reading cassette")
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading cassette")
+		}
+		switch {
+		case strings.HasSuffix(hdr.Name, ".req"):
+			key = strings.TrimSpace(string(data))
+		case strings.HasSuffix(hdr.Name, ".resp"):
+			if len(key) == 0 {
+				return nil, errors.New("response without a request in " + hdr.Name)
+			}
+			r.responses[key] = append(r.responses[key], data)
+			key = ""
+		}
+	}
+}
+
+// RoundTrip answers the request from the cassette.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := cassetteKey(req)
+
+	r.mu.Lock()
+	queue := r.responses[key]
+	if len(queue) == 0 {
+		r.mu.Unlock()
+		return nil, errors.New("not in cassette: " + key)
+	}
+	data := queue[0]
+	if len(queue) > 1 {
+		r.responses[key] = queue[1:]
+	}
+	r.mu.Unlock()
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading recorded response")
+	}
+	return resp, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// verify runs the verify subcommand: "iowa verify [manifest]" checks the
+// size and checksum of every file in a snapshot manifest, the newest one
+// in -snapshot by default, and that it is an intact audio file. With
+// -repair, files that fail are downloaded again.
+func (app *App) verify(ctx context.Context) error {
+	if len(app.Args) > 1 {
+		return withKind(FailureConfig, errors.New("usage: iowa verify [-repair] [manifest]"))
+	}
+	var name string
+	switch {
+	case len(app.Args) == 1:
+		name = app.Args[0]
+	case len(app.Snapshot) > 0:
+		latest, err := latestSnapshot(app.Snapshot)
+		if err != nil {
+			return withKind(FailureConfig, err)
+		}
+		name = latest
+	default:
+		return withKind(FailureConfig, errors.New("verify needs a manifest or -snapshot"))
+	}
+	m, err := LoadManifest(name)
+	if err != nil {
+		return withKind(FailureConfig, err)
+	}
+	if err := app.openState(); err != nil {
+		return err
+	}
+	var broken, repaired int
+
+	for _, mf := range m.Files {
+		err := verifyFile(mf)
+		if err == nil {
+			continue
+		}
+		broken++
+		app.record(mf.URL, func(fs *FileState) {
+			fs.Path, fs.Status, fs.Error = mf.Path, FileFailed, err.Error()
+		})
+		if !app.Repair {
+			app.term.Status(StatusFail, "%s: %s", mf.Path, err)
+			app.failures.Add(FailureVerify, mf.URL, err)
+			continue
+		}
+		app.progress.Add(mf.URL)
+		if err := app.fetchFile(ctx, mf); err != nil {
+			app.term.Status(StatusFail, "%s: %s", mf.Path, err)
+			app.failures.Add(FailureVerify, mf.URL, err)
+			continue
+		}
+		app.term.Status(StatusOK, "%s: repaired", mf.Path)
+		repaired++
+	}
+	app.term.Status(StatusOK, "verified %d files in %s, %d broken, %d repaired", len(m.Files), name, broken, repaired)
+
+	if n := app.failures.Count(FailureVerify); n > 0 {
+		return withKind(FailureVerify, errors.Errorf("%d files failed verification", n))
+	}
+	return nil
+}
+
+// verifyFile returns an error if a file doesn't match its manifest entry
+// or isn't an intact audio file. A size or checksum left out of the entry,
+// e.g. in a manifest written by hand, isn't checked.
+func verifyFile(mf ManifestFile) error {
+	got, err := hashFile(mf.Path, len(mf.MD5) > 0)
+	if err != nil {
+		return err
+	}
+	if err := got.check(mf); err != nil {
+		return err
+	}
+	return ValidateAudioFile(mf.Path)
+}
+
+// fetchFile downloads a file in a manifest, replacing the local copy only
+// if the download matches the manifest.
+func (app *App) fetchFile(ctx context.Context, mf ManifestFile) error {
+	if err := app.mkdirAll(path.Dir(mf.Path)); err != nil {
+		return err
+	}
+	resp, err := app.get(ctx, mf.URL)
+	if err != nil {
+		return errors.Wrap(err, "downloading")
+	}
+	defer func() { _ = resp.Body.Close() }() // Best effort.
+
+	part := mf.Path + partSuffix
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "creating file")
+	}
+	var (
+		t = app.progress.Start(mf.URL, resp.ContentLength)
+		h = newHasher(len(mf.MD5) > 0)
+	)
+	if err := app.writePart(f, nil, t, io.TeeReader(resp.Body, h)); err != nil {
+		return errors.Wrap(err, "downloading")
+	}
+	sums := h.Sum()
+	if err := sums.check(mf); err != nil {
+		_ = os.Remove(part) // Best effort.
+		return errors.Wrap(err, "the file has changed on the site")
+	}
+	if err := os.Rename(part, mf.Path); err != nil {
+		return errors.Wrap(err, "renaming partial file")
+	}
+	if err := app.own(mf.Path, app.FileMode); err != nil {
+		return err
+	}
+	if app.Fsync {
+		if err := syncDir(path.Dir(mf.Path)); err != nil {
+			return err
+		}
+	}
+	app.progress.Finish(t)
+	app.recordDone(mf.URL, mf.Path, nil, sums)
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import (
+	"os"
+)
+
+// windowSize returns false, the size of the terminal is only queried on
+// systems with the TIOCGWINSZ ioctl.
+func windowSize(f *os.File) (width, height int, ok bool) {
+	return 0, 0, false
+}